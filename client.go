@@ -0,0 +1,67 @@
+package main
+
+import (
+	"runtime/debug"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+)
+
+// version is the version of the controller, which is set on release builds
+// with -ldflags "-X main.version=v0.1.0".
+var version string
+
+// controllerVersion returns the version set with -ldflags, or the version of
+// the module stamped by the Go toolchain otherwise.
+func controllerVersion() string {
+	if version != "" {
+		return version
+	}
+	if info, ok := debug.ReadBuildInfo(); ok && info.Main.Version != "" {
+		return info.Main.Version
+	}
+	return "(devel)"
+}
+
+// inClusterConfig returns the config of the service account of the pod,
+// which is replaced in tests.
+var inClusterConfig = rest.InClusterConfig
+
+// buildRestConfig returns the config of the API server from the kubeconfig
+// file, or $KUBECONFIG or ~/.kube/config if it's empty, with the server and
+// the context overridden if they aren't empty. The in-cluster config is used
+// if no kubeconfig file is found, such as in a pod, with the server
+// overridden in the same way.
+func buildRestConfig(kubeconfig, master, context string, clientConnection configv1alpha1.ClientConnectionConfiguration) (*rest.Config, error) {
+	loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+	loadingRules.ExplicitPath = kubeconfig
+	overrides := &clientcmd.ConfigOverrides{CurrentContext: context}
+	overrides.ClusterInfo.Server = master
+	clientConfig := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, overrides)
+	rawConfig, err := clientConfig.RawConfig()
+	if err != nil {
+		return nil, err
+	}
+	var config *rest.Config
+	if master != "" && clientcmdapi.IsConfigEmpty(&rawConfig) {
+		// clientcmd only falls back to the in-cluster config without
+		// overrides, and returns the server without credentials otherwise.
+		config, err = inClusterConfig()
+		if err == nil {
+			config.Host = master
+		}
+	} else {
+		config, err = clientConfig.ClientConfig()
+	}
+	if err != nil {
+		return nil, err
+	}
+	config.QPS = float32(clientConnection.QPS)
+	config.Burst = int(clientConnection.Burst)
+	config.UserAgent = controller.UserAgent(controllerVersion())
+	return config, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/fake"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+)
+
+// fakeClients are the fake clientsets of the controller and of the load
+// generator, which share the trackers so the load generator's requests are
+// not counted as the controller's.
+type fakeClients struct {
+	controllerKube    *k8sfake.Clientset
+	controllerExample *fake.Clientset
+	loadKube          *k8sfake.Clientset
+	loadExample       *fake.Clientset
+}
+
+// newFakeClients returns the fake clientsets whose requests of the controller
+// are counted by the counter.
+func newFakeClients(counter *requestCounter) *fakeClients {
+	server := &fakeServer{}
+	clients := &fakeClients{
+		controllerKube:    k8sfake.NewSimpleClientset(),
+		controllerExample: fake.NewSimpleClientset(),
+	}
+	kubeTracker := clients.controllerKube.Tracker()
+	exampleTracker := clients.controllerExample.Tracker()
+	clients.loadKube = &k8sfake.Clientset{}
+	clients.loadExample = &fake.Clientset{}
+	for _, c := range []struct {
+		fake    *core.Fake
+		tracker core.ObjectTracker
+		counted bool
+	}{
+		{fake: &clients.controllerKube.Fake, tracker: kubeTracker, counted: true},
+		{fake: &clients.controllerExample.Fake, tracker: exampleTracker, counted: true},
+		{fake: &clients.loadKube.Fake, tracker: kubeTracker},
+		{fake: &clients.loadExample.Fake, tracker: exampleTracker},
+	} {
+		if c.fake.ReactionChain == nil {
+			// The clientsets of the load generator share the trackers of
+			// the controller's.
+			tracker := c.tracker
+			c.fake.AddReactor("*", "*", core.ObjectReaction(tracker))
+			c.fake.AddWatchReactor("*", func(action core.Action) (bool, watch.Interface, error) {
+				w, err := tracker.Watch(action.GetResource(), action.GetNamespace())
+				return true, w, err
+			})
+		}
+		c.fake.PrependReactor("*", "*", server.reactor(c.tracker))
+		if c.counted {
+			c.fake.PrependReactor("*", "*", counter.reactor)
+			c.fake.PrependWatchReactor("*", func(action core.Action) (bool, watch.Interface, error) {
+				counter.count(action.GetVerb(), action.GetResource().Resource)
+				return false, nil, nil
+			})
+		}
+	}
+	return clients
+}
+
+// fakeServer makes the fake clientsets behave like the API server in the ways
+// the controller relies on: every write bumps the resource version, an update
+// with a stale resource version is a conflict, the generation is bumped on a
+// change of the spec, and the status is only written through the status
+// subresource.
+type fakeServer struct {
+	mu              sync.Mutex
+	resourceVersion int
+}
+
+func (s *fakeServer) reactor(tracker core.ObjectTracker) core.ReactionFunc {
+	return func(action core.Action) (bool, runtime.Object, error) {
+		switch action := action.(type) {
+		case core.CreateActionImpl:
+			object, err := meta.Accessor(action.GetObject())
+			if err != nil {
+				return true, nil, err
+			}
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.resourceVersion++
+			object.SetResourceVersion(fmt.Sprint(s.resourceVersion))
+			object.SetUID(types.UID(fmt.Sprintf("uid-%d", s.resourceVersion)))
+			object.SetGeneration(1)
+			if err := tracker.Create(action.GetResource(), action.GetObject(), action.GetNamespace()); err != nil {
+				return true, nil, err
+			}
+			return true, action.GetObject(), nil
+		case core.UpdateActionImpl:
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			obj, err := s.update(tracker, action)
+			return true, obj, err
+		}
+		return false, nil, nil
+	}
+}
+
+func (s *fakeServer) update(tracker core.ObjectTracker, action core.UpdateActionImpl) (runtime.Object, error) {
+	obj := action.GetObject().DeepCopyObject()
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := tracker.Get(action.GetResource(), action.GetNamespace(), object.GetName())
+	if err != nil {
+		return nil, err
+	}
+	storedObject, err := meta.Accessor(stored)
+	if err != nil {
+		return nil, err
+	}
+	// An update without a resource version is unconditional.
+	if object.GetResourceVersion() != "" && object.GetResourceVersion() != storedObject.GetResourceVersion() {
+		return nil, errors.NewConflict(action.GetResource().GroupResource(), object.GetName(), fmt.Errorf("the object has been modified"))
+	}
+	status := action.GetSubresource() == "status"
+	switch updated := obj.(type) {
+	case *samplev1alpha1.Foo:
+		current := stored.(*samplev1alpha1.Foo)
+		if status {
+			updated.Spec, updated.ObjectMeta = current.Spec, current.ObjectMeta
+		} else {
+			updated.Status = current.Status
+			updated.Generation = current.Generation
+			if !equality.Semantic.DeepEqual(current.Spec, updated.Spec) {
+				updated.Generation++
+			}
+		}
+	case *appsv1.Deployment:
+		current := stored.(*appsv1.Deployment)
+		if status {
+			updated.Spec, updated.ObjectMeta = current.Spec, current.ObjectMeta
+		} else {
+			updated.Status = current.Status
+			updated.Generation = current.Generation
+			if !equality.Semantic.DeepEqual(current.Spec, updated.Spec) {
+				updated.Generation++
+			}
+		}
+	}
+	object.SetUID(storedObject.GetUID())
+	s.resourceVersion++
+	object.SetResourceVersion(fmt.Sprint(s.resourceVersion))
+	if err := tracker.Update(action.GetResource(), obj, action.GetNamespace()); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// clearActions drops the actions recorded by the fake clientsets, which are
+// not used and would grow for the duration of the load test.
+func (c *fakeClients) clearActions() {
+	c.controllerKube.ClearActions()
+	c.controllerExample.ClearActions()
+	c.loadKube.ClearActions()
+	c.loadExample.ClearActions()
+}
@@ -0,0 +1,505 @@
+// Command foo-loadtest measures how many Foos a controller can handle. It runs
+// the controller in process against the fake clientsets or an API server,
+// creates Foos and changes them at a configurable rate, and reports the
+// end-to-end reconcile latency, the depth of the workqueue and the rate of the
+// API requests of the controller.
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+	exampleinformers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/clientcmd"
+	"k8s.io/client-go/util/retry"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+	"k8s.io/utils/pointer"
+)
+
+// loadTestLabel is set on the Foos created by the load test.
+const loadTestLabel = "example.com/load-test"
+
+// options are the options of the load test.
+type options struct {
+	kubeconfig     string
+	namespace      string
+	foos           int
+	createQPS      float64
+	duration       time.Duration
+	churnQPS       float64
+	deleteRatio    float64
+	maxReplicas    int
+	sampleInterval time.Duration
+	settleTimeout  time.Duration
+	seed           int64
+	output         string
+	format         string
+	cleanup        bool
+	logSampleQPS   float64
+	logSampleBurst int
+}
+
+func main() {
+	klog.InitFlags(nil)
+	var opts options
+	flag.StringVar(&opts.kubeconfig, "kubeconfig", "", "path to the kubeconfig of the API server (the fake clientsets if empty)")
+	flag.StringVar(&opts.namespace, "namespace", "foo-loadtest", "namespace of the Foos")
+	flag.IntVar(&opts.foos, "foos", 100, "number of Foos")
+	flag.Float64Var(&opts.createQPS, "create-qps", 50, "rate of the creations of the Foos")
+	flag.DurationVar(&opts.duration, "duration", time.Minute, "duration of the churn after the Foos are created")
+	flag.Float64Var(&opts.churnQPS, "churn-qps", 10, "rate of the changes of the Foos during the churn")
+	flag.Float64Var(&opts.deleteRatio, "delete-ratio", 0.1, "ratio of the changes which delete a Foo and create a new one instead of scaling it")
+	flag.IntVar(&opts.maxReplicas, "max-replicas", 5, "maximum replicas of a Foo")
+	flag.DurationVar(&opts.sampleInterval, "sample-interval", time.Second, "interval of the samples of the report")
+	flag.DurationVar(&opts.settleTimeout, "settle-timeout", time.Minute, "time to wait for the changes to be observed after the churn")
+	flag.Int64Var(&opts.seed, "seed", 1, "seed of the random changes")
+	flag.StringVar(&opts.output, "output", "-", "path of the report (stdout if -)")
+	flag.StringVar(&opts.format, "format", ReportFormatJSON, "format of the report (json or csv)")
+	flag.BoolVar(&opts.cleanup, "cleanup", true, "delete the Foos of the load test from the API server at the end")
+	flag.Float64Var(&opts.logSampleQPS, "log-sample-qps", 0.01, "rate of the reconciles of each Foo that are logged after --log-sample-burst reconciles (0 to log all)")
+	flag.IntVar(&opts.logSampleBurst, "log-sample-burst", 1, "number of the reconciles of each Foo that are logged at once")
+	flag.Parse()
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	logger := klog.FromContext(ctx)
+
+	if opts.format != ReportFormatJSON && opts.format != ReportFormatCSV {
+		logger.Error(nil, "--format must be json or csv", "format", opts.format)
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	if err := run(ctx, opts); err != nil {
+		logger.Error(err, "Load test failed")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+}
+
+// clients are the clientsets of the controller, whose requests are counted,
+// and of the load generator.
+type clients struct {
+	mode              string
+	controllerKube    kubernetes.Interface
+	controllerExample clientset.Interface
+	loadKube          kubernetes.Interface
+	loadExample       clientset.Interface
+	// clearActions drops the actions recorded by the fake clientsets.
+	clearActions func()
+}
+
+func newClients(opts options, counter *requestCounter) (*clients, error) {
+	if opts.kubeconfig == "" {
+		fakes := newFakeClients(counter)
+		return &clients{
+			mode:              "fake",
+			controllerKube:    fakes.controllerKube,
+			controllerExample: fakes.controllerExample,
+			loadKube:          fakes.loadKube,
+			loadExample:       fakes.loadExample,
+			clearActions:      fakes.clearActions,
+		}, nil
+	}
+
+	config, err := clientcmd.BuildConfigFromFlags("", opts.kubeconfig)
+	if err != nil {
+		return nil, err
+	}
+	controllerConfig := rest.CopyConfig(config)
+	controllerConfig.Wrap(counter.wrap)
+	// The load generator is not throttled by the client.
+	loadConfig := rest.CopyConfig(config)
+	loadConfig.QPS, loadConfig.Burst = -1, 0
+	c := &clients{mode: config.Host, clearActions: func() {}}
+	if c.controllerKube, err = kubernetes.NewForConfig(controllerConfig); err != nil {
+		return nil, err
+	}
+	if c.controllerExample, err = clientset.NewForConfig(controllerConfig); err != nil {
+		return nil, err
+	}
+	if c.loadKube, err = kubernetes.NewForConfig(loadConfig); err != nil {
+		return nil, err
+	}
+	if c.loadExample, err = clientset.NewForConfig(loadConfig); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func run(ctx context.Context, opts options) error {
+	logger := klog.FromContext(ctx)
+	counter := newRequestCounter()
+	c, err := newClients(opts, counter)
+	if err != nil {
+		return err
+	}
+	_, err = c.loadKube.CoreV1().Namespaces().Create(ctx, &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: opts.namespace}}, metav1.CreateOptions{})
+	if err != nil && !errors.IsAlreadyExists(err) {
+		return err
+	}
+
+	controllerCtx, stopController := context.WithCancel(ctx)
+	defer stopController()
+	if err := startController(controllerCtx, c, opts); err != nil {
+		return err
+	}
+	latencies := newLatencyTracker()
+	if err := watchFoos(controllerCtx, c.loadExample, opts.namespace, latencies); err != nil {
+		return err
+	}
+
+	start := time.Now()
+	s := &sampler{
+		interval:  opts.sampleInterval,
+		start:     start,
+		counter:   counter,
+		latencies: latencies,
+		gatherer:  prometheus.DefaultGatherer,
+		clear:     c.clearActions,
+	}
+	var wg sync.WaitGroup
+	samplerCtx, stopSampler := context.WithCancel(ctx)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		s.run(samplerCtx)
+	}()
+
+	g := &generator{
+		client:    c.loadExample,
+		kube:      c.loadKube,
+		opts:      opts,
+		rand:      rand.New(rand.NewSource(opts.seed)),
+		latencies: latencies,
+	}
+	logger.Info("Creating Foos", "foos", opts.foos)
+	if err := g.create(ctx); err != nil {
+		stopSampler()
+		return err
+	}
+	logger.Info("Changing Foos", "duration", opts.duration, "qps", opts.churnQPS)
+	if err := g.churn(ctx); err != nil {
+		stopSampler()
+		return err
+	}
+	logger.Info("Waiting for the changes to be observed", "pending", latencies.pendingChanges())
+	settleCtx, cancel := context.WithTimeout(ctx, opts.settleTimeout)
+	defer cancel()
+	for latencies.pendingChanges() > 0 && settleCtx.Err() == nil {
+		select {
+		case <-settleCtx.Done():
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	elapsed := time.Since(start)
+	stopSampler()
+	wg.Wait()
+
+	report := s.report(opts, c.mode, elapsed)
+	if opts.cleanup && c.mode != "fake" {
+		logger.Info("Deleting Foos")
+		if err := g.cleanup(ctx); err != nil {
+			logger.Error(err, "Failed to delete Foos")
+		}
+	}
+	return writeReportTo(opts.output, report, opts.format)
+}
+
+// startController starts the informers and the controller in the background.
+func startController(ctx context.Context, c *clients, opts options) error {
+	informerSets, factories, err := controller.NewInformers(c.controllerKube, c.controllerExample, []string{opts.namespace}, "", "", 0)
+	if err != nil {
+		return err
+	}
+	fooController := controller.NewController(ctx, c.controllerKube, c.controllerExample, informerSets, controller.ControllerOptions{
+		RateLimiter:    controller.NewRateLimiter(controller.DefaultRateLimiterOptions(), clock.RealClock{}),
+		LogSampleQPS:   opts.logSampleQPS,
+		LogSampleBurst: opts.logSampleBurst,
+	})
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	go func() {
+		if err := fooController.Run(ctx); err != nil {
+			klog.FromContext(ctx).Error(err, "Error running controller")
+		}
+	}()
+	return nil
+}
+
+// watchFoos feeds the status updates of the Foos to the latency tracker.
+func watchFoos(ctx context.Context, client clientset.Interface, namespace string, latencies *latencyTracker) error {
+	factory := exampleinformers.NewSharedInformerFactoryWithOptions(client, 0, exampleinformers.WithNamespace(namespace))
+	informer := factory.Example().V1alpha1().Foos().Informer()
+	if _, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			latencies.observe(obj.(*samplev1alpha1.Foo))
+		},
+		UpdateFunc: func(_, obj interface{}) {
+			latencies.observe(obj.(*samplev1alpha1.Foo))
+		},
+	}); err != nil {
+		return err
+	}
+	factory.Start(ctx.Done())
+	if !cache.WaitForCacheSync(ctx.Done(), informer.HasSynced) {
+		return fmt.Errorf("failed to wait for the Foo cache to sync")
+	}
+	return nil
+}
+
+// generator creates and changes the Foos.
+type generator struct {
+	client    clientset.Interface
+	kube      kubernetes.Interface
+	opts      options
+	rand      *rand.Rand
+	latencies *latencyTracker
+
+	// foos are the names of the live Foos, and next the index of the name of
+	// the next Foo.
+	foos []string
+	uids map[string]types.UID
+	next int
+}
+
+// create creates the Foos at the creation rate.
+func (g *generator) create(ctx context.Context) error {
+	g.uids = map[string]types.UID{}
+	limiter := rate.NewLimiter(rate.Limit(g.opts.createQPS), 1)
+	for len(g.foos) < g.opts.foos {
+		if err := limiter.Wait(ctx); err != nil {
+			return err
+		}
+		name, err := g.createFoo(ctx)
+		if err != nil {
+			return err
+		}
+		g.foos = append(g.foos, name)
+	}
+	return nil
+}
+
+// churn scales the Foos, or deletes them and creates new ones, at the churn
+// rate for the duration.
+func (g *generator) churn(ctx context.Context) error {
+	if len(g.foos) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, g.opts.duration)
+	defer cancel()
+	limiter := rate.NewLimiter(rate.Limit(g.opts.churnQPS), 1)
+	for {
+		if err := limiter.Wait(ctx); err != nil {
+			// The duration is over.
+			return nil
+		}
+		i := g.rand.Intn(len(g.foos))
+		if g.rand.Float64() < g.opts.deleteRatio {
+			if err := g.deleteFoo(ctx, g.foos[i]); err != nil {
+				return err
+			}
+			name, err := g.createFoo(ctx)
+			if err != nil {
+				return err
+			}
+			g.foos[i] = name
+			continue
+		}
+		if err := g.scaleFoo(ctx, g.foos[i]); err != nil {
+			return err
+		}
+	}
+}
+
+func (g *generator) createFoo(ctx context.Context) (string, error) {
+	name := fmt.Sprintf("loadtest-%d", g.next)
+	g.next++
+	foo := &samplev1alpha1.Foo{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: g.opts.namespace,
+			Labels:    map[string]string{loadTestLabel: "true"},
+		},
+		Spec: samplev1alpha1.FooSpec{
+			DeploymentName: name,
+			Replicas:       pointer.Int32(g.replicas(0)),
+		},
+	}
+	at := time.Now()
+	created, err := g.client.ExampleV1alpha1().Foos(g.opts.namespace).Create(ctx, foo, metav1.CreateOptions{})
+	if err != nil {
+		return "", err
+	}
+	g.uids[name] = created.UID
+	g.latencies.changed(created.UID, created.Generation, at)
+	return name, nil
+}
+
+func (g *generator) scaleFoo(ctx context.Context, name string) error {
+	return retry.RetryOnConflict(retry.DefaultRetry, func() error {
+		foo, err := g.client.ExampleV1alpha1().Foos(g.opts.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return err
+		}
+		current := int32(1)
+		if foo.Spec.Replicas != nil {
+			current = *foo.Spec.Replicas
+		}
+		foo.Spec.Replicas = pointer.Int32(g.replicas(current))
+		at := time.Now()
+		updated, err := g.client.ExampleV1alpha1().Foos(g.opts.namespace).Update(ctx, foo, metav1.UpdateOptions{})
+		if err != nil {
+			return err
+		}
+		g.latencies.changed(updated.UID, updated.Generation, at)
+		return nil
+	})
+}
+
+// deleteFoo deletes the Foo and its Deployment, as the garbage collector
+// does, so the Deployments don't pile up without a kube-controller-manager.
+func (g *generator) deleteFoo(ctx context.Context, name string) error {
+	err := g.client.ExampleV1alpha1().Foos(g.opts.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	g.latencies.deleted(g.uids[name])
+	delete(g.uids, name)
+	err = g.kube.AppsV1().Deployments(g.opts.namespace).Delete(ctx, name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// replicas returns random replicas other than the current replicas.
+func (g *generator) replicas(current int32) int32 {
+	if g.opts.maxReplicas <= 1 {
+		return 1
+	}
+	for {
+		if replicas := int32(g.rand.Intn(g.opts.maxReplicas) + 1); replicas != current {
+			return replicas
+		}
+	}
+}
+
+// cleanup deletes the Foos of the load test and their Deployments.
+func (g *generator) cleanup(ctx context.Context) error {
+	for _, name := range g.foos {
+		if err := g.deleteFoo(ctx, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sampler samples the measurements at every interval.
+type sampler struct {
+	interval  time.Duration
+	start     time.Time
+	counter   *requestCounter
+	latencies *latencyTracker
+	gatherer  prometheus.Gatherer
+	clear     func()
+
+	samples   []Sample
+	all       []time.Duration
+	lastTotal int
+}
+
+func (s *sampler) run(ctx context.Context) {
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+	last := s.start
+	for {
+		select {
+		case <-ctx.Done():
+			s.sample(time.Now(), last)
+			return
+		case now := <-ticker.C:
+			s.sample(now, last)
+			last = now
+		}
+	}
+}
+
+func (s *sampler) sample(now, last time.Time) {
+	total, _ := s.counter.snapshot()
+	latencies := s.latencies.drain()
+	s.all = append(s.all, latencies...)
+	sortDurations(latencies)
+	sample := Sample{
+		Elapsed:    now.Sub(s.start).Seconds(),
+		QueueDepth: queueDepth(s.gatherer),
+		Observed:   len(latencies),
+		LatencyP50: milliseconds(percentile(latencies, 50)),
+		LatencyP99: milliseconds(percentile(latencies, 99)),
+	}
+	if seconds := now.Sub(last).Seconds(); seconds > 0 {
+		sample.APIQPS = float64(total-s.lastTotal) / seconds
+	}
+	s.lastTotal = total
+	s.samples = append(s.samples, sample)
+	s.clear()
+}
+
+// report returns the report of the samples, which must be called after run
+// returns.
+func (s *sampler) report(opts options, mode string, elapsed time.Duration) *Report {
+	total, counts := s.counter.snapshot()
+	changes, abandoned := s.latencies.counts()
+	report := &Report{
+		Mode:        mode,
+		Foos:        opts.foos,
+		ChurnQPS:    opts.churnQPS,
+		Duration:    elapsed.Round(time.Millisecond).String(),
+		Changes:     changes,
+		Observed:    len(s.all),
+		Abandoned:   abandoned,
+		Unobserved:  s.latencies.pendingChanges(),
+		Latency:     summarizeLatencies(s.all),
+		QueueDepth:  summarizeDepths(s.samples),
+		APIRequests: counts,
+		Samples:     s.samples,
+	}
+	if seconds := elapsed.Seconds(); seconds > 0 {
+		report.APIQPS = float64(total) / seconds
+	}
+	return report
+}
+
+func writeReportTo(path string, report *Report, format string) error {
+	if path == "-" {
+		return writeReport(os.Stdout, report, format)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeReport(f, report, format); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestPercentile(t *testing.T) {
+	var tenSamples []time.Duration
+	for i := 1; i <= 10; i++ {
+		tenSamples = append(tenSamples, time.Duration(i)*time.Millisecond)
+	}
+	for _, tc := range []struct {
+		name   string
+		sorted []time.Duration
+		p      float64
+		want   time.Duration
+	}{
+		{name: "empty", p: 50},
+		{name: "single sample p50", sorted: []time.Duration{time.Second}, p: 50, want: time.Second},
+		{name: "single sample p99", sorted: []time.Duration{time.Second}, p: 99, want: time.Second},
+		{name: "p0", sorted: tenSamples, p: 0, want: time.Millisecond},
+		{name: "p50", sorted: tenSamples, p: 50, want: 5 * time.Millisecond},
+		{name: "p90", sorted: tenSamples, p: 90, want: 9 * time.Millisecond},
+		{name: "p99", sorted: tenSamples, p: 99, want: 9 * time.Millisecond},
+		{name: "p100", sorted: tenSamples, p: 100, want: 10 * time.Millisecond},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := percentile(tc.sorted, tc.p); got != tc.want {
+				t.Errorf("Expected p%v of %v to be %v, got %v", tc.p, tc.sorted, tc.want, got)
+			}
+		})
+	}
+}
+
+func TestSummarizeLatencies(t *testing.T) {
+	latencies := []time.Duration{4 * time.Millisecond, time.Millisecond, 3 * time.Millisecond, 2 * time.Millisecond}
+	want := LatencySummary{Min: 1, Mean: 2.5, P50: 2, P90: 3, P99: 3, Max: 4}
+	if diff := cmp.Diff(want, summarizeLatencies(latencies)); diff != "" {
+		t.Errorf("unexpected summary (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff(LatencySummary{}, summarizeLatencies(nil)); diff != "" {
+		t.Errorf("unexpected summary of no latency (-want +got):\n%s", diff)
+	}
+}
+
+// TestLatencyTracker checks the latency of a change is measured once its
+// generation is observed, even if the status is updated before the change is
+// recorded, and the changes of a deleted Foo are abandoned.
+func TestLatencyTracker(t *testing.T) {
+	tracker := newLatencyTracker()
+	observe := func(generation int64) {
+		tracker.observe(&samplev1alpha1.Foo{
+			ObjectMeta: metav1.ObjectMeta{UID: "a"},
+			Status:     samplev1alpha1.FooStatus{ObservedGeneration: generation},
+		})
+	}
+	changedAt := time.Now().Add(-time.Second)
+
+	tracker.changed("a", 1, changedAt)
+	tracker.changed("a", 2, changedAt)
+	observe(1)
+	if latencies := tracker.drain(); len(latencies) != 1 || latencies[0] < time.Second {
+		t.Errorf("Expected the latency of the first generation of at least 1s, got %v", latencies)
+	}
+	if pending := tracker.pendingChanges(); pending != 1 {
+		t.Errorf("Expected 1 pending change, got %d", pending)
+	}
+
+	// The status observing the third generation is delivered before the
+	// update is recorded.
+	observe(3)
+	tracker.changed("a", 3, changedAt)
+	if latencies := tracker.drain(); len(latencies) != 2 {
+		t.Errorf("Expected the latencies of the second and third generations, got %v", latencies)
+	}
+
+	tracker.changed("a", 4, changedAt)
+	tracker.deleted("a")
+	if changes, abandoned := tracker.counts(); changes != 4 || abandoned != 1 {
+		t.Errorf("Expected 4 changes and 1 abandoned, got %d and %d", changes, abandoned)
+	}
+	if pending := tracker.pendingChanges(); pending != 0 {
+		t.Errorf("Expected no pending change, got %d", pending)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	report := &Report{
+		Mode:     "fake",
+		Foos:     10,
+		ChurnQPS: 5,
+		Duration: "1m0s",
+		Changes:  20,
+		Observed: 20,
+		Latency:  LatencySummary{Min: 1, Mean: 2, P50: 2, P90: 3, P99: 4, Max: 4},
+		Samples: []Sample{
+			{Elapsed: 1, QueueDepth: 2, APIQPS: 10.5, Observed: 5, LatencyP50: 2, LatencyP99: 4},
+			{Elapsed: 2, Observed: 15, LatencyP50: 1.25, LatencyP99: 3},
+		},
+	}
+
+	t.Run("csv", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeReport(&buf, report, ReportFormatCSV); err != nil {
+			t.Fatal(err)
+		}
+		want := `elapsed_seconds,queue_depth,api_qps,observed,latency_p50_ms,latency_p99_ms
+1.000,2.000,10.500,5,2.000,4.000
+2.000,0.000,0.000,15,1.250,3.000
+`
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("unexpected CSV report (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		if err := writeReport(&buf, report, ReportFormatJSON); err != nil {
+			t.Fatal(err)
+		}
+		var got Report
+		if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+			t.Fatalf("Expected a JSON report, got %q: %v", buf.String(), err)
+		}
+		if diff := cmp.Diff(report, &got); diff != "" {
+			t.Errorf("unexpected JSON report (-want +got):\n%s", diff)
+		}
+	})
+
+	if err := writeReport(&bytes.Buffer{}, report, "xml"); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
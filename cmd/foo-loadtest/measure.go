@@ -0,0 +1,239 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	core "k8s.io/client-go/testing"
+)
+
+// requestCounter counts the API requests of the controller by verb and
+// resource.
+type requestCounter struct {
+	mu     sync.Mutex
+	counts map[string]int
+	total  int
+}
+
+func newRequestCounter() *requestCounter {
+	return &requestCounter{counts: map[string]int{}}
+}
+
+func (c *requestCounter) count(verb, resource string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.counts[verb+" "+resource]++
+	c.total++
+}
+
+// reactor counts the requests to a fake clientset.
+func (c *requestCounter) reactor(action core.Action) (bool, runtime.Object, error) {
+	resource := action.GetResource().Resource
+	if subresource := action.GetSubresource(); subresource != "" {
+		resource += "/" + subresource
+	}
+	c.count(action.GetVerb(), resource)
+	return false, nil, nil
+}
+
+// wrap counts the requests sent through the transport by HTTP method and
+// resource.
+func (c *requestCounter) wrap(rt http.RoundTripper) http.RoundTripper {
+	return roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		verb := req.Method
+		if req.URL.Query().Get("watch") == "true" {
+			verb = "WATCH"
+		}
+		c.count(verb, resourceOfPath(req.URL.Path))
+		return rt.RoundTrip(req)
+	})
+}
+
+// snapshot returns the total number of requests and the counts by verb and
+// resource.
+func (c *requestCounter) snapshot() (int, map[string]int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	counts := make(map[string]int, len(c.counts))
+	for key, count := range c.counts {
+		counts[key] = count
+	}
+	return c.total, counts
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+// resourceOfPath returns the resource and the subresource of the path of a
+// request, such as "deployments" for /apis/apps/v1/namespaces/default/deployments/foo
+// and "foos/status" for its status.
+func resourceOfPath(path string) string {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	// Skip /api/v1 or /apis/group/version.
+	switch {
+	case len(parts) >= 2 && parts[0] == "api":
+		parts = parts[2:]
+	case len(parts) >= 3 && parts[0] == "apis":
+		parts = parts[3:]
+	default:
+		return path
+	}
+	if len(parts) >= 2 && parts[0] == "namespaces" && len(parts) != 2 {
+		parts = parts[2:]
+	}
+	switch len(parts) {
+	case 0:
+		return path
+	case 1, 2:
+		return parts[0]
+	default:
+		return parts[0] + "/" + parts[2]
+	}
+}
+
+// latencyTracker measures the time from a change of a Foo to the update of
+// its status observing the generation of the change.
+type latencyTracker struct {
+	mu sync.Mutex
+	// pending is the time of the changes not observed yet by the UID and
+	// the generation of the Foo.
+	pending map[types.UID]map[int64]time.Time
+	// observed is the last observed generation of each Foo, in case the
+	// status is updated before the change is recorded.
+	observed map[types.UID]int64
+	// latencies are the latencies since the last drain.
+	latencies []time.Duration
+	changes   int
+	abandoned int
+}
+
+func newLatencyTracker() *latencyTracker {
+	return &latencyTracker{
+		pending:  map[types.UID]map[int64]time.Time{},
+		observed: map[types.UID]int64{},
+	}
+}
+
+// changed records the change of the Foo to the generation at the time.
+func (t *latencyTracker) changed(uid types.UID, generation int64, at time.Time) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.changes++
+	if t.observed[uid] >= generation {
+		t.latencies = append(t.latencies, time.Since(at))
+		return
+	}
+	if t.pending[uid] == nil {
+		t.pending[uid] = map[int64]time.Time{}
+	}
+	t.pending[uid][generation] = at
+}
+
+// observe records the latencies of the changes observed by the status of
+// the Foo.
+func (t *latencyTracker) observe(foo *samplev1alpha1.Foo) {
+	now := time.Now()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	generation := foo.Status.ObservedGeneration
+	if generation <= t.observed[foo.UID] {
+		return
+	}
+	t.observed[foo.UID] = generation
+	for changed, at := range t.pending[foo.UID] {
+		if changed <= generation {
+			t.latencies = append(t.latencies, now.Sub(at))
+			delete(t.pending[foo.UID], changed)
+		}
+	}
+}
+
+// deleted abandons the pending changes of the deleted Foo.
+func (t *latencyTracker) deleted(uid types.UID) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.abandoned += len(t.pending[uid])
+	delete(t.pending, uid)
+	delete(t.observed, uid)
+}
+
+// drain returns the latencies measured since the last drain.
+func (t *latencyTracker) drain() []time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	latencies := t.latencies
+	t.latencies = nil
+	return latencies
+}
+
+// counts returns the number of the changes and of the abandoned changes.
+func (t *latencyTracker) counts() (int, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.changes, t.abandoned
+}
+
+// pendingChanges returns the number of the changes not observed yet.
+func (t *latencyTracker) pendingChanges() int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	pending := 0
+	for _, changes := range t.pending {
+		pending += len(changes)
+	}
+	return pending
+}
+
+// queueDepth returns the depth of the workqueue of the controller from the
+// workqueue metrics.
+func queueDepth(gatherer prometheus.Gatherer) float64 {
+	families, err := gatherer.Gather()
+	if err != nil {
+		return 0
+	}
+	for _, family := range families {
+		if family.GetName() != "workqueue_depth" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			if hasLabel(metric, "name", "foo") {
+				return metric.GetGauge().GetValue()
+			}
+		}
+	}
+	return 0
+}
+
+func hasLabel(metric *dto.Metric, name, value string) bool {
+	for _, label := range metric.GetLabel() {
+		if label.GetName() == name && label.GetValue() == value {
+			return true
+		}
+	}
+	return false
+}
+
+// percentile returns the p-th percentile of the sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	i := int(float64(len(sorted)-1) * p / 100)
+	return sorted[i]
+}
+
+func sortDurations(durations []time.Duration) {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+const (
+	// ReportFormatJSON writes the summary and the samples as JSON.
+	ReportFormatJSON = "json"
+	// ReportFormatCSV writes the samples as CSV with a header.
+	ReportFormatCSV = "csv"
+)
+
+// Report is the result of a load test.
+type Report struct {
+	// Mode is either "fake" or the host of the API server.
+	Mode     string  `json:"mode"`
+	Foos     int     `json:"foos"`
+	ChurnQPS float64 `json:"churnQPS"`
+	Duration string  `json:"duration"`
+
+	// Changes is the number of creations and updates of the Foos, Observed
+	// the number of them observed by the status of the Foos, and Abandoned
+	// the number of them whose Foo was deleted before.
+	Changes   int `json:"changes"`
+	Observed  int `json:"observed"`
+	Abandoned int `json:"abandoned"`
+	// Unobserved is the number of changes not observed by the end.
+	Unobserved int `json:"unobserved"`
+
+	Latency    LatencySummary `json:"latency"`
+	QueueDepth DepthSummary   `json:"queueDepth"`
+	// APIQPS is the average rate of the requests of the controller.
+	APIQPS float64 `json:"apiQPS"`
+	// APIRequests is the number of the requests of the controller by verb
+	// and resource.
+	APIRequests map[string]int `json:"apiRequests"`
+
+	Samples []Sample `json:"samples"`
+}
+
+// LatencySummary summarizes the end-to-end reconcile latencies in
+// milliseconds.
+type LatencySummary struct {
+	Min  float64 `json:"minMs"`
+	Mean float64 `json:"meanMs"`
+	P50  float64 `json:"p50Ms"`
+	P90  float64 `json:"p90Ms"`
+	P99  float64 `json:"p99Ms"`
+	Max  float64 `json:"maxMs"`
+}
+
+// DepthSummary summarizes the sampled depths of the workqueue.
+type DepthSummary struct {
+	Mean float64 `json:"mean"`
+	Max  float64 `json:"max"`
+}
+
+// Sample is the measurements of an interval of the load test.
+type Sample struct {
+	// Elapsed is the time since the start at the end of the interval.
+	Elapsed    float64 `json:"elapsedSeconds"`
+	QueueDepth float64 `json:"queueDepth"`
+	APIQPS     float64 `json:"apiQPS"`
+	// Observed is the number of changes observed in the interval, and
+	// LatencyP50 and LatencyP99 their latencies in milliseconds.
+	Observed   int     `json:"observed"`
+	LatencyP50 float64 `json:"latencyP50Ms"`
+	LatencyP99 float64 `json:"latencyP99Ms"`
+}
+
+// summarizeLatencies returns the summary of the latencies, which are sorted.
+func summarizeLatencies(latencies []time.Duration) LatencySummary {
+	if len(latencies) == 0 {
+		return LatencySummary{}
+	}
+	sortDurations(latencies)
+	var total time.Duration
+	for _, latency := range latencies {
+		total += latency
+	}
+	return LatencySummary{
+		Min:  milliseconds(latencies[0]),
+		Mean: milliseconds(total / time.Duration(len(latencies))),
+		P50:  milliseconds(percentile(latencies, 50)),
+		P90:  milliseconds(percentile(latencies, 90)),
+		P99:  milliseconds(percentile(latencies, 99)),
+		Max:  milliseconds(latencies[len(latencies)-1]),
+	}
+}
+
+func summarizeDepths(samples []Sample) DepthSummary {
+	var summary DepthSummary
+	if len(samples) == 0 {
+		return summary
+	}
+	for _, sample := range samples {
+		summary.Mean += sample.QueueDepth
+		if sample.QueueDepth > summary.Max {
+			summary.Max = sample.QueueDepth
+		}
+	}
+	summary.Mean /= float64(len(samples))
+	return summary
+}
+
+func milliseconds(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// writeReport writes the report in the format.
+func writeReport(w io.Writer, report *Report, format string) error {
+	switch format {
+	case ReportFormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	case ReportFormatCSV:
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"elapsed_seconds", "queue_depth", "api_qps", "observed", "latency_p50_ms", "latency_p99_ms"}); err != nil {
+			return err
+		}
+		for _, sample := range report.Samples {
+			if err := writer.Write([]string{
+				formatFloat(sample.Elapsed),
+				formatFloat(sample.QueueDepth),
+				formatFloat(sample.APIQPS),
+				strconv.Itoa(sample.Observed),
+				formatFloat(sample.LatencyP50),
+				formatFloat(sample.LatencyP99),
+			}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	default:
+		return fmt.Errorf("unknown report format %q", format)
+	}
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', 3, 64)
+}
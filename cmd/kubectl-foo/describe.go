@@ -0,0 +1,259 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+func newDescribeCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:     "describe NAME",
+		Short:   "Show a Foo with its Deployments and recent Events",
+		Example: `  kubectl foo describe foo-sample`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeClient, exampleClient, err := f.clients()
+			if err != nil {
+				return err
+			}
+			namespace, err := f.namespace()
+			if err != nil {
+				return err
+			}
+			foo, err := exampleClient.ExampleV1alpha1().Foos(namespace).Get(cmd.Context(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			return describeFoo(cmd.Context(), streams.Out, kubeClient, foo)
+		},
+	}
+}
+
+// describeFoo writes the Foo, the Deployments it owns and the Events of all
+// of them.
+func describeFoo(ctx context.Context, out io.Writer, kubeClient kubernetes.Interface, foo *samplev1alpha1.Foo) error {
+	deployments, err := ownedDeployments(ctx, kubeClient, foo)
+	if err != nil {
+		return err
+	}
+	uids := []types.UID{foo.UID}
+	for i := range deployments {
+		uids = append(uids, deployments[i].UID)
+	}
+	events, err := listEvents(ctx, kubeClient, foo.Namespace, uids)
+	if err != nil {
+		return err
+	}
+
+	w := newDescribeWriter(out)
+	w.write(0, "Name:\t%s\n", foo.Name)
+	w.write(0, "Namespace:\t%s\n", foo.Namespace)
+	w.write(0, "Labels:\t%s\n", formatMap(foo.Labels))
+	w.write(0, "Annotations:\t%s\n", formatMap(foo.Annotations))
+	w.write(0, "Generation:\t%d (observed %d)\n", foo.Generation, foo.Status.ObservedGeneration)
+
+	w.write(0, "Spec:\n")
+	w.write(1, "Deployment Name:\t%s\n", foo.Spec.DeploymentName)
+	if foo.Spec.Replicas != nil {
+		w.write(1, "Replicas:\t%d\n", *foo.Spec.Replicas)
+	}
+	if autoscaling := foo.Spec.Autoscaling; autoscaling != nil {
+		w.write(1, "Autoscaling:\t%d to %d replicas\n", minReplicas(autoscaling.MinReplicas), autoscaling.MaxReplicas)
+	}
+	if foo.Spec.Strategy != nil && foo.Spec.Strategy.Type != "" {
+		w.write(1, "Strategy:\t%s\n", foo.Spec.Strategy.Type)
+	}
+	if foo.Spec.Rollout != nil && foo.Spec.Rollout.PauseAt != nil {
+		w.write(1, "Rollout Pause At:\t%d%%\n", *foo.Spec.Rollout.PauseAt)
+	}
+	if canary := foo.Spec.Canary; canary != nil {
+		switch {
+		case canary.Replicas != nil:
+			w.write(1, "Canary:\t%d replicas\n", *canary.Replicas)
+		case canary.Weight != nil:
+			w.write(1, "Canary:\t%d%% weight\n", *canary.Weight)
+		default:
+			w.write(1, "Canary:\t0 replicas\n")
+		}
+		if canary.Template.Image != "" {
+			w.write(2, "Image:\t%s\n", canary.Template.Image)
+		}
+	}
+
+	w.write(0, "Status:\n")
+	status, message := fooStatus(foo)
+	if message != "" {
+		status += ": " + message
+	}
+	w.write(1, "Summary:\t%s\n", status)
+	w.write(1, "Available Replicas:\t%d\n", foo.Status.AvailableReplicas)
+	w.write(1, "Updated Replicas:\t%d\n", foo.Status.UpdatedReplicas)
+	if foo.Status.TemplateHash != "" {
+		w.write(1, "Template Hash:\t%s\n", foo.Status.TemplateHash)
+	}
+	if foo.Status.PreviousTemplateHash != "" {
+		w.write(1, "Previous Template Hash:\t%s\n", foo.Status.PreviousTemplateHash)
+	}
+	if health := foo.Status.Health; health != nil {
+		w.write(1, "Health:\t%d restarts, %d pods in CrashLoopBackOff, %d pods failing to pull images\n", health.Restarts, health.CrashLoopBackOffPods, health.ImagePullErrorPods)
+	}
+
+	if len(foo.Status.Conditions) == 0 {
+		w.write(0, "Conditions:\t<none>\n")
+	} else {
+		w.write(0, "Conditions:\n")
+		w.write(1, "Type\tStatus\tReason\tAge\tMessage\n")
+		w.write(1, "----\t------\t------\t---\t-------\n")
+		for _, c := range foo.Status.Conditions {
+			w.write(1, "%s\t%s\t%s\t%s\t%s\n", c.Type, c.Status, c.Reason, age(c.LastTransitionTime), c.Message)
+		}
+	}
+
+	if len(deployments) == 0 {
+		w.write(0, "Deployments:\t<none>\n")
+	} else {
+		w.write(0, "Deployments:\n")
+		for i := range deployments {
+			describeDeployment(w, &deployments[i])
+		}
+	}
+
+	if len(events) == 0 {
+		w.write(0, "Events:\t<none>\n")
+	} else {
+		w.write(0, "Events:\n")
+		w.write(1, "Type\tReason\tAge\tObject\tMessage\n")
+		w.write(1, "----\t------\t---\t------\t-------\n")
+		for _, e := range events {
+			w.write(1, "%s\t%s\t%s\t%s/%s\t%s\n", e.Type, e.Reason, age(eventTime(&e)), e.InvolvedObject.Kind, e.InvolvedObject.Name, strings.TrimSpace(e.Message))
+		}
+	}
+	return w.flush()
+}
+
+func describeDeployment(w *describeWriter, deployment *appsv1.Deployment) {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	w.write(1, "%s:\n", deployment.Name)
+	w.write(2, "Replicas:\t%d desired | %d updated | %d total | %d available | %d unavailable\n",
+		replicas, deployment.Status.UpdatedReplicas, deployment.Status.Replicas, deployment.Status.AvailableReplicas, deployment.Status.UnavailableReplicas)
+	w.write(2, "Paused:\t%t\n", deployment.Spec.Paused)
+	var images []string
+	for _, container := range deployment.Spec.Template.Spec.Containers {
+		images = append(images, container.Image)
+	}
+	w.write(2, "Images:\t%s\n", strings.Join(images, ", "))
+	for _, c := range deployment.Status.Conditions {
+		w.write(2, "%s:\t%s (%s)\n", c.Type, c.Status, c.Reason)
+	}
+}
+
+// ownedDeployments returns the Deployments controlled by the Foo, which are
+// the stable Deployment and the canary Deployment if any.
+func ownedDeployments(ctx context.Context, kubeClient kubernetes.Interface, foo *samplev1alpha1.Foo) ([]appsv1.Deployment, error) {
+	var deployments []appsv1.Deployment
+	names := []string{foo.Spec.DeploymentName}
+	if foo.Status.Canary != nil {
+		names = append(names, foo.Status.Canary.DeploymentName)
+	}
+	for _, name := range names {
+		deployment, err := kubeClient.AppsV1().Deployments(foo.Namespace).Get(ctx, name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if metav1.IsControlledBy(deployment, foo) {
+			deployments = append(deployments, *deployment)
+		}
+	}
+	return deployments, nil
+}
+
+// listEvents returns the Events of the objects of the UIDs from the oldest.
+func listEvents(ctx context.Context, kubeClient kubernetes.Interface, namespace string, uids []types.UID) ([]corev1.Event, error) {
+	var events []corev1.Event
+	for _, uid := range uids {
+		selector := fields.OneTermEqualSelector("involvedObject.uid", string(uid)).String()
+		list, err := kubeClient.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{FieldSelector: selector})
+		if err != nil {
+			return nil, err
+		}
+		for _, event := range list.Items {
+			// The field selector isn't supported by every server, e.g. the
+			// fake clientsets, so the Events are filtered again.
+			if event.InvolvedObject.UID == uid {
+				events = append(events, event)
+			}
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventTime(&events[i]).Time.Before(eventTime(&events[j]).Time)
+	})
+	return events, nil
+}
+
+// eventTime returns the time the Event was last seen.
+func eventTime(event *corev1.Event) metav1.Time {
+	switch {
+	case !event.LastTimestamp.IsZero():
+		return event.LastTimestamp
+	case event.Series != nil:
+		return metav1.Time{Time: event.Series.LastObservedTime.Time}
+	default:
+		return metav1.Time{Time: event.EventTime.Time}
+	}
+}
+
+// formatMap formats the labels or annotations in lines of key=value.
+func formatMap(m map[string]string) string {
+	if len(m) == 0 {
+		return "<none>"
+	}
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	lines := make([]string, 0, len(keys))
+	for _, key := range keys {
+		lines = append(lines, key+"="+m[key])
+	}
+	return strings.Join(lines, "\n\t")
+}
+
+// describeWriter writes the aligned and indented lines of describe.
+type describeWriter struct {
+	w *tabwriter.Writer
+}
+
+func newDescribeWriter(out io.Writer) *describeWriter {
+	return &describeWriter{w: tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)}
+}
+
+func (w *describeWriter) write(level int, format string, args ...interface{}) {
+	fmt.Fprintf(w.w, strings.Repeat("  ", level)+format, args...)
+}
+
+func (w *describeWriter) flush() error {
+	return w.w.Flush()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+type getOptions struct {
+	output        string
+	selector      string
+	allNamespaces bool
+}
+
+func newGetCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	o := &getOptions{}
+	cmd := &cobra.Command{
+		Use:   "get [NAME...]",
+		Short: "List Foos with the status of their Deployments",
+		Example: `  kubectl foo get
+  kubectl foo get foo-sample -o yaml
+  kubectl foo get -A -l team=a -o wide`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.Context(), f, streams, args)
+		},
+	}
+	cmd.Flags().StringVarP(&o.output, "output", "o", "", "output format: wide, yaml or json")
+	cmd.Flags().StringVarP(&o.selector, "selector", "l", "", "label selector of the Foos to list")
+	cmd.Flags().BoolVarP(&o.allNamespaces, "all-namespaces", "A", false, "list the Foos in all the namespaces")
+	return cmd
+}
+
+func (o *getOptions) run(ctx context.Context, f *factory, streams genericiooptions.IOStreams, names []string) error {
+	if err := validateOutput(o.output); err != nil {
+		return err
+	}
+	_, exampleClient, err := f.clients()
+	if err != nil {
+		return err
+	}
+	namespace, err := f.namespace()
+	if err != nil {
+		return err
+	}
+	if o.allNamespaces {
+		if len(names) > 0 {
+			return fmt.Errorf("a Foo cannot be retrieved by name across all namespaces")
+		}
+		namespace = metav1.NamespaceAll
+	}
+
+	var foos []samplev1alpha1.Foo
+	if len(names) == 0 {
+		list, err := exampleClient.ExampleV1alpha1().Foos(namespace).List(ctx, metav1.ListOptions{LabelSelector: o.selector})
+		if err != nil {
+			return err
+		}
+		foos = list.Items
+	} else {
+		for _, name := range names {
+			foo, err := exampleClient.ExampleV1alpha1().Foos(namespace).Get(ctx, name, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			foos = append(foos, *foo)
+		}
+	}
+	if len(foos) == 0 && (o.output == "" || o.output == outputWide) {
+		if o.allNamespaces {
+			fmt.Fprintln(streams.ErrOut, "No Foos found.")
+		} else {
+			fmt.Fprintf(streams.ErrOut, "No Foos found in %s namespace.\n", namespace)
+		}
+		return nil
+	}
+	return printFoos(streams.Out, foos, o.output, len(names) == 1, o.allNamespaces)
+}
@@ -0,0 +1,95 @@
+// Command kubectl-foo is a kubectl plugin to inspect and operate Foos. Put it
+// in the PATH and run it as "kubectl foo".
+package main
+
+import (
+	"os"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+
+	"github.com/spf13/cobra"
+	"k8s.io/cli-runtime/pkg/genericclioptions"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+// fooResource is the resource of the Foos printed in the messages of the
+// subcommands, like "foo.example.com/foo-sample scaled".
+var fooResource = "foo." + samplev1alpha1.SchemeGroupVersion.Group
+
+func main() {
+	streams := genericiooptions.IOStreams{In: os.Stdin, Out: os.Stdout, ErrOut: os.Stderr}
+	if err := newRootCommand(newFactory(), streams).Execute(); err != nil {
+		os.Exit(1)
+	}
+}
+
+// factory creates the clientsets and resolves the namespace from the standard
+// kubeconfig flags.
+type factory struct {
+	configFlags *genericclioptions.ConfigFlags
+
+	// kubeClient and exampleClient are created on the first use unless they
+	// are set, which the tests do with the fake clientsets.
+	kubeClient    kubernetes.Interface
+	exampleClient clientset.Interface
+}
+
+func newFactory() *factory {
+	return &factory{configFlags: genericclioptions.NewConfigFlags(true)}
+}
+
+// clients returns the clientsets of the Kubernetes API and of the Foos.
+func (f *factory) clients() (kubernetes.Interface, clientset.Interface, error) {
+	if f.kubeClient != nil && f.exampleClient != nil {
+		return f.kubeClient, f.exampleClient, nil
+	}
+	config, err := f.configFlags.ToRESTConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.kubeClient, err = kubernetes.NewForConfig(config); err != nil {
+		return nil, nil, err
+	}
+	if f.exampleClient, err = clientset.NewForConfig(config); err != nil {
+		return nil, nil, err
+	}
+	return f.kubeClient, f.exampleClient, nil
+}
+
+// namespace returns the namespace of --namespace or of the current context.
+func (f *factory) namespace() (string, error) {
+	if f.configFlags.Namespace != nil && *f.configFlags.Namespace != "" {
+		return *f.configFlags.Namespace, nil
+	}
+	namespace, _, err := f.configFlags.ToRawKubeConfigLoader().Namespace()
+	return namespace, err
+}
+
+func newRootCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "kubectl-foo",
+		Short: "Inspect and operate Foos",
+		Long: `Inspect and operate the Foos of sample-controller and the objects they own.
+
+Run it as "kubectl foo" with the binary in the PATH.`,
+		SilenceUsage: true,
+	}
+	cmd.SetIn(streams.In)
+	cmd.SetOut(streams.Out)
+	cmd.SetErr(streams.ErrOut)
+	f.configFlags.AddFlags(cmd.PersistentFlags())
+
+	cmd.AddCommand(
+		newGetCommand(f, streams),
+		newDescribeCommand(f, streams),
+		newScaleCommand(f, streams),
+		newPauseCommand(f, streams),
+		newResumeCommand(f, streams),
+		newTreeCommand(f, streams),
+		newWaitCommand(f, streams),
+		newRenderCommand(streams),
+	)
+	return cmd
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/fake"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/yaml"
+)
+
+func newFoo(name string) *samplev1alpha1.Foo {
+	return &samplev1alpha1.Foo{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault, UID: "foo-uid", Generation: 2},
+		Spec:       samplev1alpha1.FooSpec{DeploymentName: name, Replicas: pointer.Int32(2)},
+		Status: samplev1alpha1.FooStatus{
+			AvailableReplicas:  2,
+			UpdatedReplicas:    2,
+			ObservedGeneration: 2,
+			Conditions: []metav1.Condition{
+				{Type: controller.ConditionTypeProgressing, Status: metav1.ConditionFalse, ObservedGeneration: 2, Reason: "RolloutComplete"},
+				{Type: controller.ConditionTypeDegraded, Status: metav1.ConditionFalse, ObservedGeneration: 2, Reason: "Healthy"},
+				{Type: controller.ConditionTypeReady, Status: metav1.ConditionTrue, ObservedGeneration: 2, Reason: "ReplicasAvailable", Message: "2 of 2 replicas available"},
+			},
+		},
+	}
+}
+
+func newOwnedObjects(foo *samplev1alpha1.Foo) []runtime.Object {
+	deployment := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            foo.Spec.DeploymentName,
+			Namespace:       foo.Namespace,
+			UID:             "deployment-uid",
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))},
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: pointer.Int32(2)},
+		Status: appsv1.DeploymentStatus{Replicas: 2, UpdatedReplicas: 2, AvailableReplicas: 2},
+	}
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            deployment.Name + "-abc",
+			Namespace:       foo.Namespace,
+			UID:             "rs-uid",
+			Annotations:     map[string]string{revisionAnnotation: "1"},
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(deployment, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec:   appsv1.ReplicaSetSpec{Replicas: pointer.Int32(2)},
+		Status: appsv1.ReplicaSetStatus{ReadyReplicas: 2},
+	}
+	var objects []runtime.Object
+	objects = append(objects, deployment, rs)
+	for _, name := range []string{"x", "y"} {
+		objects = append(objects, &corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:            rs.Name + "-" + name,
+				Namespace:       foo.Namespace,
+				OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(rs, appsv1.SchemeGroupVersion.WithKind("ReplicaSet"))},
+			},
+			Spec:   corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx"}}},
+			Status: corev1.PodStatus{Phase: corev1.PodRunning, ContainerStatuses: []corev1.ContainerStatus{{Name: "nginx", Ready: true}}},
+		})
+	}
+	objects = append(objects, &corev1.Event{
+		ObjectMeta:     metav1.ObjectMeta{Name: "synced", Namespace: foo.Namespace},
+		InvolvedObject: corev1.ObjectReference{Kind: "Foo", Name: foo.Name, UID: foo.UID},
+		Type:           corev1.EventTypeNormal,
+		Reason:         "Synced",
+		Message:        "Foo synced successfully",
+	})
+	return objects
+}
+
+type cliFixture struct {
+	t             *testing.T
+	kubeClient    *k8sfake.Clientset
+	exampleClient *fake.Clientset
+	// stdin is the input of the command.
+	stdin string
+}
+
+func newCLIFixture(t *testing.T, foo *samplev1alpha1.Foo) *cliFixture {
+	return &cliFixture{
+		t:             t,
+		kubeClient:    k8sfake.NewSimpleClientset(newOwnedObjects(foo)...),
+		exampleClient: fake.NewSimpleClientset(foo),
+	}
+}
+
+// run runs kubectl-foo with the arguments in the default namespace, and
+// returns the output.
+func (f *cliFixture) run(args ...string) (string, error) {
+	factory := newFactory()
+	factory.kubeClient = f.kubeClient
+	factory.exampleClient = f.exampleClient
+	var out bytes.Buffer
+	streams := genericiooptions.IOStreams{In: strings.NewReader(f.stdin), Out: &out, ErrOut: &out}
+	cmd := newRootCommand(factory, streams)
+	cmd.SetArgs(append(args, "--namespace", metav1.NamespaceDefault))
+	err := cmd.ExecuteContext(context.Background())
+	return out.String(), err
+}
+
+func (f *cliFixture) getFoo(name string) *samplev1alpha1.Foo {
+	foo, err := f.exampleClient.ExampleV1alpha1().Foos(metav1.NamespaceDefault).Get(context.Background(), name, metav1.GetOptions{})
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	return foo
+}
+
+func TestGet(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+
+	out, err := f.run("get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `NAME         READY   UP-TO-DATE   STATUS   AGE
+foo-sample   2/2     2            Ready    <unknown>
+`
+	if out != want {
+		t.Errorf("Expected the table\n%s\ngot\n%s", want, out)
+	}
+
+	out, err = f.run("get", "-o", "wide")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, "MESSAGE") || !strings.Contains(out, "2 of 2 replicas available") {
+		t.Errorf("Expected the wide columns, got\n%s", out)
+	}
+
+	out, err = f.run("get", "foo-sample", "-o", "yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(out, "apiVersion: example.com/v1alpha1\nkind: Foo\n") {
+		t.Errorf("Expected a Foo in YAML, got\n%s", out)
+	}
+
+	out, err = f.run("get", "-o", "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out, `"kind": "FooList"`) {
+		t.Errorf("Expected a FooList in JSON, got\n%s", out)
+	}
+
+	if _, err := f.run("get", "-o", "name"); err == nil {
+		t.Errorf("Expected an error for an unknown output format")
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+
+	out, err := f.run("describe", "foo-sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"Summary:             Ready: 2 of 2 replicas available",
+		"Replicas:  2 desired | 2 updated | 2 total | 2 available | 0 unavailable",
+		"Normal  Synced  <unknown>  Foo/foo-sample  Foo synced successfully",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in\n%s", want, out)
+		}
+	}
+}
+
+func TestScale(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+
+	out, err := f.run("scale", "foo-sample", "--replicas", "5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "foo.example.com/foo-sample scaled\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+	if replicas := *f.getFoo("foo-sample").Spec.Replicas; replicas != 5 {
+		t.Errorf("Expected 5 replicas, got %d", replicas)
+	}
+
+	foo := newFoo("autoscaled")
+	foo.Spec.Autoscaling = &samplev1alpha1.FooAutoscaling{MaxReplicas: 3}
+	f = newCLIFixture(t, foo)
+	if _, err := f.run("scale", "autoscaled", "--replicas", "5"); err == nil {
+		t.Errorf("Expected an error for an autoscaled Foo")
+	}
+}
+
+func TestPauseAndResume(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+
+	if _, err := f.run("resume", "foo-sample"); err == nil {
+		t.Errorf("Expected an error to resume a Foo not paused")
+	}
+	if _, err := f.run("pause", "foo-sample"); err != nil {
+		t.Fatal(err)
+	}
+	if rollout := f.getFoo("foo-sample").Spec.Rollout; rollout == nil || rollout.PauseAt == nil || *rollout.PauseAt != 0 {
+		t.Errorf("Expected spec.rollout.pauseAt to be 0, got %+v", rollout)
+	}
+	if _, err := f.run("pause", "foo-sample"); err == nil {
+		t.Errorf("Expected an error to pause a paused Foo")
+	}
+	if _, err := f.run("resume", "foo-sample"); err != nil {
+		t.Fatal(err)
+	}
+	if rollout := f.getFoo("foo-sample").Spec.Rollout; rollout != nil && rollout.PauseAt != nil {
+		t.Errorf("Expected spec.rollout.pauseAt to be removed, got %d", *rollout.PauseAt)
+	}
+}
+
+func TestTree(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+
+	out, err := f.run("tree", "foo-sample")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `NAME                           READY  STATUS      AGE
+Foo/foo-sample                 2/2    Ready       <unknown>
+└─Deployment/foo-sample        2/2    Available   <unknown>
+  └─ReplicaSet/foo-sample-abc  2/2    revision 1  <unknown>
+    ├─Pod/foo-sample-abc-x     1/1    Running     <unknown>
+    └─Pod/foo-sample-abc-y     1/1    Running     <unknown>
+`
+	if out != want {
+		t.Errorf("Expected the tree\n%s\ngot\n%s", want, out)
+	}
+}
+
+func TestWait(t *testing.T) {
+	foo := newFoo("foo-sample")
+	foo.Status.ObservedGeneration = 1
+	f := newCLIFixture(t, foo)
+
+	done := make(chan error)
+	var out string
+	go func() {
+		var err error
+		out, err = f.run("wait", "foo-sample", "--for=condition=Ready", "--timeout=10s")
+		done <- err
+	}()
+	// The Foo is Ready once the latest generation is observed.
+	time.Sleep(100 * time.Millisecond)
+	foo.Status.ObservedGeneration = 2
+	if _, err := f.exampleClient.ExampleV1alpha1().Foos(foo.Namespace).UpdateStatus(context.Background(), foo, metav1.UpdateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if out != "foo.example.com/foo-sample condition met\n" {
+		t.Errorf("Unexpected output %q", out)
+	}
+
+	if _, err := f.run("wait", "foo-sample", "--for=condition=Degraded", "--timeout=100ms"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if _, err := f.run("wait", "missing", "--timeout=1s"); err == nil {
+		t.Errorf("Expected an error for a missing Foo")
+	}
+}
+
+func TestParseWaitCondition(t *testing.T) {
+	for _, tc := range []struct {
+		in      string
+		want    waitCondition
+		wantErr bool
+	}{
+		{in: "condition=Ready", want: waitCondition{conditionType: "Ready", status: metav1.ConditionTrue}},
+		{in: "condition=Degraded=false", want: waitCondition{conditionType: "Degraded", status: metav1.ConditionFalse}},
+		{in: "delete", want: waitCondition{delete: true}},
+		{in: "condition=Ready=maybe", wantErr: true},
+		{in: "jsonpath={.status}", wantErr: true},
+	} {
+		got, err := parseWaitCondition(tc.in)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: unexpected error %v", tc.in, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("%s: expected %+v, got %+v", tc.in, tc.want, got)
+		}
+	}
+}
+
+// renderedFoo is a manifest of a Foo with a comment-only document.
+const renderedFoo = `# Foos of the sample
+---
+apiVersion: example.com/v1alpha1
+kind: Foo
+metadata:
+  name: foo-sample
+  namespace: default
+spec:
+  deploymentName: foo-sample
+  replicas: 3
+  disruptionBudget:
+    minAvailable: 1
+`
+
+func TestRender(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+	f.stdin = renderedFoo
+
+	out, err := f.run("render")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{
+		"kind: Deployment\n",
+		"  replicas: 3\n",
+		"  revisionHistoryLimit: 10\n",
+		"        imagePullPolicy: Always\n",
+		"---\napiVersion: policy/v1\nkind: PodDisruptionBudget\n",
+		"  minAvailable: 1\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("Expected %q in the output, got\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "HorizontalPodAutoscaler") {
+		t.Errorf("Expected no HorizontalPodAutoscaler without autoscaling, got\n%s", out)
+	}
+
+	f.stdin = "apiVersion: v1\nkind: ConfigMap\nmetadata:\n  name: config\n"
+	if _, err := f.run("render"); err == nil {
+		t.Error("Expected an error rendering a ConfigMap")
+	}
+}
+
+func TestRenderLive(t *testing.T) {
+	f := newCLIFixture(t, newFoo("foo-sample"))
+	f.stdin = renderedFoo
+
+	foo := newFoo("foo-sample")
+	foo.Spec.Replicas = pointer.Int32(2)
+	rendered, err := controller.Render(foo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The live Deployment has the fields set by the API server and a label
+	// added by someone else.
+	live := rendered[0].(*appsv1.Deployment)
+	live.UID = "deployment-uid"
+	live.Annotations = map[string]string{revisionAnnotation: "1"}
+	live.Labels["team"] = "a"
+	live.Status.Replicas = 2
+	data, err := yaml.Marshal(live)
+	if err != nil {
+		t.Fatal(err)
+	}
+	filename := filepath.Join(t.TempDir(), "deployment.yaml")
+	if err := os.WriteFile(filename, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	out, err := f.run("render", "--live", filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `Deployment/foo-sample:
+  - metadata.labels.team: "a"
+  ~ spec.replicas: 2 -> 3
+`
+	if out != want {
+		t.Errorf("Expected the diff\n%s\ngot\n%s", want, out)
+	}
+}
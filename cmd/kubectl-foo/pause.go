@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+const (
+	// pausePatch sets spec.rollout.pauseAt to 0, so the controller pauses
+	// the Deployment as soon as a rollout starts.
+	pausePatch = `{"spec":{"rollout":{"pauseAt":0}}}`
+	// resumePatch removes spec.rollout.pauseAt, so the rollouts run to the
+	// end.
+	resumePatch = `{"spec":{"rollout":{"pauseAt":null}}}`
+)
+
+func newPauseCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "pause NAME",
+		Short: "Pause the rollouts of a Foo",
+		Long: `Pause the rollouts of the Deployment of a Foo by setting spec.rollout.pauseAt
+to 0. The rollout in progress is paused, and a new rollout is paused as soon as
+it starts. Resume the rollouts with "kubectl foo resume".`,
+		Example: `  kubectl foo pause foo-sample`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return patchRollout(cmd.Context(), f, streams, args[0], true)
+		},
+	}
+}
+
+func newResumeCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume NAME",
+		Short: "Resume the rollouts of a Foo",
+		Long: `Resume the rollouts of the Deployment of a Foo by removing spec.rollout.pauseAt,
+whether it was set by "kubectl foo pause" or to pause at a percentage. A rollout
+paused by spec.health.autoPause stays paused while the Foo is Degraded.`,
+		Example: `  kubectl foo resume foo-sample`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return patchRollout(cmd.Context(), f, streams, args[0], false)
+		},
+	}
+}
+
+// patchRollout pauses or resumes the rollouts of the Foo unless it's already
+// paused or resumed.
+func patchRollout(ctx context.Context, f *factory, streams genericiooptions.IOStreams, name string, pause bool) error {
+	_, exampleClient, err := f.clients()
+	if err != nil {
+		return err
+	}
+	namespace, err := f.namespace()
+	if err != nil {
+		return err
+	}
+	foos := exampleClient.ExampleV1alpha1().Foos(namespace)
+	foo, err := foos.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	rollout := foo.Spec.Rollout
+	switch {
+	case pause && rollout != nil && rollout.PauseAt != nil && *rollout.PauseAt == 0:
+		return fmt.Errorf("%s/%s is already paused", fooResource, name)
+	case !pause && (rollout == nil || rollout.PauseAt == nil):
+		return fmt.Errorf("%s/%s is not paused", fooResource, name)
+	}
+
+	patch, verb := resumePatch, "resumed"
+	if pause {
+		patch, verb = pausePatch, "paused"
+	}
+	if _, err := foos.Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(streams.Out, "%s/%s %s\n", fooResource, name, verb)
+	return nil
+}
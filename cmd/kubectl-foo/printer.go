@@ -0,0 +1,164 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/duration"
+	"k8s.io/cli-runtime/pkg/printers"
+)
+
+const (
+	// outputWide prints the table with the additional columns.
+	outputWide = "wide"
+	// outputYAML prints the Foos as YAML.
+	outputYAML = "yaml"
+	// outputJSON prints the Foos as JSON.
+	outputJSON = "json"
+)
+
+// validateOutput returns an error if the output format of -o is unknown.
+func validateOutput(output string) error {
+	switch output {
+	case "", outputWide, outputYAML, outputJSON:
+		return nil
+	}
+	return fmt.Errorf("unknown output format %q: must be one of wide, yaml or json", output)
+}
+
+// printFoos prints the Foos in the output format. A single Foo is printed as
+// is in YAML and JSON, and the others as a FooList.
+func printFoos(w io.Writer, foos []samplev1alpha1.Foo, output string, single, withNamespace bool) error {
+	switch output {
+	case outputYAML, outputJSON:
+		var obj runtime.Object
+		if single && len(foos) == 1 {
+			foo := foos[0].DeepCopy()
+			foo.SetGroupVersionKind(samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))
+			obj = foo
+		} else {
+			list := &samplev1alpha1.FooList{Items: make([]samplev1alpha1.Foo, len(foos))}
+			list.SetGroupVersionKind(samplev1alpha1.SchemeGroupVersion.WithKind("FooList"))
+			for i := range foos {
+				foos[i].DeepCopyInto(&list.Items[i])
+				list.Items[i].SetGroupVersionKind(samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))
+			}
+			obj = list
+		}
+		var printer printers.ResourcePrinter = &printers.JSONPrinter{}
+		if output == outputYAML {
+			printer = &printers.YAMLPrinter{}
+		}
+		return printer.PrintObj(obj, w)
+	default:
+		printer := printers.NewTablePrinter(printers.PrintOptions{
+			Wide:          output == outputWide,
+			WithNamespace: withNamespace,
+		})
+		return printer.PrintObj(fooTable(foos), w)
+	}
+}
+
+// fooTable returns the table of the Foos. The columns with a priority are
+// only printed with -o wide.
+func fooTable(foos []samplev1alpha1.Foo) *metav1.Table {
+	table := &metav1.Table{
+		ColumnDefinitions: []metav1.TableColumnDefinition{
+			{Name: "Name", Type: "string", Format: "name"},
+			{Name: "Ready", Type: "string", Description: "Available and desired replicas of the Deployment."},
+			{Name: "Up-to-date", Type: "integer", Description: "Replicas running the pod template of the current rollout."},
+			{Name: "Status", Type: "string", Description: "Summary of the conditions of the Foo."},
+			{Name: "Age", Type: "string"},
+			{Name: "Deployment", Type: "string", Priority: 1},
+			{Name: "Canary", Type: "string", Priority: 1, Description: "Available and desired replicas of the canary Deployment."},
+			{Name: "Restarts", Type: "integer", Priority: 1},
+			{Name: "Message", Type: "string", Priority: 1, Description: "Message of the condition summarized in Status."},
+		},
+	}
+	for i := range foos {
+		foo := &foos[i]
+		status, message := fooStatus(foo)
+		canary := "<none>"
+		if foo.Status.Canary != nil {
+			canary = fmt.Sprintf("%d/%d", foo.Status.Canary.AvailableReplicas, foo.Status.Canary.Replicas)
+		}
+		var restarts int32
+		if foo.Status.Health != nil {
+			restarts = foo.Status.Health.Restarts
+		}
+		table.Rows = append(table.Rows, metav1.TableRow{
+			Cells: []interface{}{
+				foo.Name,
+				fmt.Sprintf("%d/%d", foo.Status.AvailableReplicas, desiredReplicas(foo)),
+				int64(foo.Status.UpdatedReplicas),
+				status,
+				age(foo.CreationTimestamp),
+				foo.Spec.DeploymentName,
+				canary,
+				int64(restarts),
+				message,
+			},
+			Object: runtime.RawExtension{Object: foo},
+		})
+	}
+	return table
+}
+
+// desiredReplicas returns the desired replicas of the Deployment of the Foo,
+// which are the ones set by the HorizontalPodAutoscaler while autoscaling is
+// specified.
+func desiredReplicas(foo *samplev1alpha1.Foo) int32 {
+	switch {
+	case foo.Spec.Autoscaling != nil && foo.Status.Stable != nil:
+		return foo.Status.Stable.Replicas
+	case foo.Spec.Replicas != nil:
+		return *foo.Spec.Replicas
+	default:
+		return 1
+	}
+}
+
+// fooStatus summarizes the conditions of the Foo in a word, with the message
+// of the condition it's derived from.
+func fooStatus(foo *samplev1alpha1.Foo) (string, string) {
+	conditions := foo.Status.Conditions
+	if c := meta.FindStatusCondition(conditions, controller.ConditionTypeReconcileFailed); c != nil && c.Status == metav1.ConditionTrue {
+		return controller.ConditionTypeReconcileFailed, c.Message
+	}
+	if foo.Status.ObservedGeneration < foo.Generation || len(conditions) == 0 {
+		return "Pending", "the latest spec is not observed yet"
+	}
+	if c := meta.FindStatusCondition(conditions, controller.ConditionTypeDegraded); c != nil && c.Status == metav1.ConditionTrue {
+		return controller.ConditionTypeDegraded, c.Message
+	}
+	if c := meta.FindStatusCondition(conditions, controller.ConditionTypeProgressing); c != nil {
+		switch c.Status {
+		case metav1.ConditionUnknown:
+			return "Paused", c.Message
+		case metav1.ConditionTrue:
+			return controller.ConditionTypeProgressing, c.Message
+		}
+	}
+	if c := meta.FindStatusCondition(conditions, controller.ConditionTypeReady); c != nil {
+		if c.Status == metav1.ConditionTrue {
+			return controller.ConditionTypeReady, c.Message
+		}
+		return "NotReady", c.Message
+	}
+	return "Unknown", ""
+}
+
+// age returns the time since the timestamp in the format of kubectl.
+func age(timestamp metav1.Time) string {
+	if timestamp.IsZero() {
+		return "<unknown>"
+	}
+	return duration.HumanDuration(time.Since(timestamp.Time))
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+	samplescheme "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/scheme"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/cli-runtime/pkg/printers"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/yaml"
+)
+
+type renderOptions struct {
+	filenames []string
+	live      string
+}
+
+func newRenderCommand(streams genericiooptions.IOStreams) *cobra.Command {
+	o := &renderOptions{}
+	cmd := &cobra.Command{
+		Use:   "render [-f FILENAME]... [--live FILENAME]",
+		Short: "Render the objects of Foo manifests without a cluster",
+		Long: `Render the Deployments, PodDisruptionBudgets and HorizontalPodAutoscalers the
+controller creates for the Foos of the manifests, with the defaults of the API
+server, and print them as YAML. The manifests are read from stdin without -f.
+
+With --live, the labels and the spec of the objects in the file, such as a
+Deployment from "kubectl get -o yaml", are compared with the rendered objects
+of the same kind and name, and the changes are printed instead.`,
+		Example: `  kubectl foo render -f config/sample/foo.yaml
+  kustomize build overlays/prod | kubectl foo render
+  kubectl foo render -f foo.yaml --live <(kubectl get deployment foo-sample -o yaml)`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(streams)
+		},
+	}
+	cmd.Flags().StringSliceVarP(&o.filenames, "filename", "f", nil, `Foo manifests to render ("-" for stdin)`)
+	cmd.Flags().StringVar(&o.live, "live", "", "manifest of the live objects to compare with the rendered objects")
+	return cmd
+}
+
+func (o *renderOptions) run(streams genericiooptions.IOStreams) error {
+	filenames := o.filenames
+	if len(filenames) == 0 {
+		filenames = []string{"-"}
+	}
+	var rendered []runtime.Object
+	for _, filename := range filenames {
+		objects, err := readManifest(filename, streams.In, samplescheme.Codecs.UniversalDeserializer())
+		if err != nil {
+			return err
+		}
+		for _, obj := range objects {
+			foo, ok := obj.(*samplev1alpha1.Foo)
+			if !ok {
+				return fmt.Errorf("%s: %s is not a Foo", filename, obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+			objects, err := controller.Render(foo)
+			if err != nil {
+				return fmt.Errorf("%s: %w", filename, err)
+			}
+			rendered = append(rendered, objects...)
+		}
+	}
+
+	if o.live == "" {
+		printer := &printers.YAMLPrinter{}
+		for _, obj := range rendered {
+			if err := printer.PrintObj(obj, streams.Out); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	live, err := readManifest(o.live, streams.In, scheme.Codecs.UniversalDeserializer())
+	if err != nil {
+		return err
+	}
+	for _, obj := range live {
+		if err := printDiff(streams.Out, obj, rendered); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// readManifest decodes the objects of the YAML or JSON documents of the
+// file, or of stdin for "-".
+func readManifest(filename string, stdin io.Reader, decoder runtime.Decoder) ([]runtime.Object, error) {
+	r := stdin
+	if filename != "-" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		r = file
+	}
+	reader := yamlutil.NewYAMLReader(bufio.NewReader(r))
+	var objects []runtime.Object
+	for {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return objects, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		data, err := yaml.YAMLToJSON(doc)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		// The documents with only comments are skipped.
+		if string(data) == "null" {
+			continue
+		}
+		obj, _, err := decoder.Decode(data, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		objects = append(objects, obj)
+	}
+}
+
+// printDiff prints the changes from the live object to the rendered object of
+// the same kind and name.
+func printDiff(w io.Writer, live runtime.Object, rendered []runtime.Object) error {
+	kind := live.GetObjectKind().GroupVersionKind().Kind
+	accessor, err := meta.Accessor(live)
+	if err != nil {
+		return err
+	}
+	name := accessor.GetName()
+	var desired runtime.Object
+	for _, obj := range rendered {
+		if obj.GetObjectKind().GroupVersionKind().Kind != kind {
+			continue
+		}
+		if accessor, err := meta.Accessor(obj); err == nil && accessor.GetName() == name {
+			desired = obj
+			break
+		}
+	}
+	if desired == nil {
+		return fmt.Errorf("no rendered %s %q to compare with", kind, name)
+	}
+
+	changes, err := controller.DiffRendered(live, desired)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Fprintf(w, "%s/%s: no changes\n", kind, name)
+		return nil
+	}
+	fmt.Fprintf(w, "%s/%s:\n", kind, name)
+	for _, change := range changes {
+		switch {
+		case change.Old == nil:
+			fmt.Fprintf(w, "  + %s: %s\n", change.Path, formatValue(change.New))
+		case change.New == nil:
+			fmt.Fprintf(w, "  - %s: %s\n", change.Path, formatValue(change.Old))
+		default:
+			fmt.Fprintf(w, "  ~ %s: %s -> %s\n", change.Path, formatValue(change.Old), formatValue(change.New))
+		}
+	}
+	return nil
+}
+
+// formatValue returns the value of a field as JSON.
+func formatValue(value interface{}) string {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return fmt.Sprint(value)
+	}
+	return string(data)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/spf13/cobra"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+)
+
+type scaleOptions struct {
+	replicas int32
+}
+
+func newScaleCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	o := &scaleOptions{}
+	cmd := &cobra.Command{
+		Use:   "scale NAME --replicas=COUNT",
+		Short: "Set the replicas of a Foo",
+		Long: `Set spec.replicas of a Foo, which the controller applies to its Deployment.
+
+A Foo with spec.autoscaling can't be scaled as its replicas are set by the
+HorizontalPodAutoscaler.`,
+		Example: `  kubectl foo scale foo-sample --replicas=3`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !cmd.Flags().Changed("replicas") {
+				return fmt.Errorf("--replicas is required")
+			}
+			return o.run(cmd.Context(), f, streams, args[0])
+		},
+	}
+	cmd.Flags().Int32Var(&o.replicas, "replicas", 0, "number of replicas of the Foo")
+	return cmd
+}
+
+func (o *scaleOptions) run(ctx context.Context, f *factory, streams genericiooptions.IOStreams, name string) error {
+	if o.replicas < 0 {
+		return fmt.Errorf("--replicas must not be negative")
+	}
+	_, exampleClient, err := f.clients()
+	if err != nil {
+		return err
+	}
+	namespace, err := f.namespace()
+	if err != nil {
+		return err
+	}
+	foos := exampleClient.ExampleV1alpha1().Foos(namespace)
+	foo, err := foos.Get(ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if foo.Spec.Autoscaling != nil {
+		return fmt.Errorf("%s/%s is autoscaled between %d and %d replicas by spec.autoscaling", fooResource, name, minReplicas(foo.Spec.Autoscaling.MinReplicas), foo.Spec.Autoscaling.MaxReplicas)
+	}
+	patch := fmt.Sprintf(`{"spec":{"replicas":%d}}`, o.replicas)
+	if _, err := foos.Patch(ctx, name, types.MergePatchType, []byte(patch), metav1.PatchOptions{}); err != nil {
+		return err
+	}
+	fmt.Fprintf(streams.Out, "%s/%s scaled\n", fooResource, name)
+	return nil
+}
+
+// minReplicas returns the lower limit of the replicas of autoscaling, which
+// defaults to 1.
+func minReplicas(replicas *int32) int32 {
+	if replicas == nil {
+		return 1
+	}
+	return *replicas
+}
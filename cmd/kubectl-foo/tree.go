@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"text/tabwriter"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"github.com/spf13/cobra"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/kubernetes"
+)
+
+// revisionAnnotation is the annotation of the revision of a ReplicaSet set by
+// the Deployment controller.
+const revisionAnnotation = "deployment.kubernetes.io/revision"
+
+func newTreeCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	return &cobra.Command{
+		Use:   "tree NAME",
+		Short: "Show the objects owned by a Foo",
+		Long: `Show the Deployments, ReplicaSets, pods, PodDisruptionBudget and
+HorizontalPodAutoscaler owned by a Foo as a tree.`,
+		Example: `  kubectl foo tree foo-sample`,
+		Args:    cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			kubeClient, exampleClient, err := f.clients()
+			if err != nil {
+				return err
+			}
+			namespace, err := f.namespace()
+			if err != nil {
+				return err
+			}
+			foo, err := exampleClient.ExampleV1alpha1().Foos(namespace).Get(cmd.Context(), args[0], metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			root, err := buildTree(cmd.Context(), kubeClient, foo)
+			if err != nil {
+				return err
+			}
+			return printTree(streams.Out, root)
+		},
+	}
+}
+
+// treeNode is an object in the tree of the objects owned by a Foo.
+type treeNode struct {
+	kind     string
+	name     string
+	ready    string
+	status   string
+	age      string
+	children []*treeNode
+}
+
+// buildTree returns the tree of the objects owned by the Foo. The objects are
+// listed in the namespace of the Foo and matched by their controller
+// references.
+func buildTree(ctx context.Context, kubeClient kubernetes.Interface, foo *samplev1alpha1.Foo) (*treeNode, error) {
+	status, _ := fooStatus(foo)
+	root := &treeNode{
+		kind:   "Foo",
+		name:   foo.Name,
+		ready:  fmt.Sprintf("%d/%d", foo.Status.AvailableReplicas, desiredReplicas(foo)),
+		status: status,
+		age:    age(foo.CreationTimestamp),
+	}
+
+	deployments, err := ownedDeployments(ctx, kubeClient, foo)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := kubeClient.AppsV1().ReplicaSets(foo.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	pods, err := kubeClient.CoreV1().Pods(foo.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range deployments {
+		deployment := &deployments[i]
+		node := deploymentNode(deployment)
+		for j := range replicaSets.Items {
+			rs := &replicaSets.Items[j]
+			if !metav1.IsControlledBy(rs, deployment) {
+				continue
+			}
+			rsNode := replicaSetNode(rs)
+			for k := range pods.Items {
+				if pod := &pods.Items[k]; metav1.IsControlledBy(pod, rs) {
+					rsNode.children = append(rsNode.children, podNode(pod))
+				}
+			}
+			node.children = append(node.children, rsNode)
+		}
+		root.children = append(root.children, node)
+	}
+
+	pdbs, err := kubeClient.PolicyV1().PodDisruptionBudgets(foo.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range pdbs.Items {
+		pdb := &pdbs.Items[i]
+		if metav1.IsControlledBy(pdb, foo) {
+			root.children = append(root.children, &treeNode{
+				kind:   "PodDisruptionBudget",
+				name:   pdb.Name,
+				ready:  fmt.Sprintf("%d/%d", pdb.Status.CurrentHealthy, pdb.Status.DesiredHealthy),
+				status: fmt.Sprintf("%d disruptions allowed", pdb.Status.DisruptionsAllowed),
+				age:    age(pdb.CreationTimestamp),
+			})
+		}
+	}
+
+	hpas, err := kubeClient.AutoscalingV2().HorizontalPodAutoscalers(foo.Namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	for i := range hpas.Items {
+		hpa := &hpas.Items[i]
+		if metav1.IsControlledBy(hpa, foo) {
+			root.children = append(root.children, &treeNode{
+				kind:   "HorizontalPodAutoscaler",
+				name:   hpa.Name,
+				ready:  fmt.Sprintf("%d/%d", hpa.Status.CurrentReplicas, hpa.Status.DesiredReplicas),
+				status: fmt.Sprintf("%d to %d replicas", minReplicas(hpa.Spec.MinReplicas), hpa.Spec.MaxReplicas),
+				age:    age(hpa.CreationTimestamp),
+			})
+		}
+	}
+	return root, nil
+}
+
+func deploymentNode(deployment *appsv1.Deployment) *treeNode {
+	replicas := int32(1)
+	if deployment.Spec.Replicas != nil {
+		replicas = *deployment.Spec.Replicas
+	}
+	status := "Available"
+	switch {
+	case deployment.Spec.Paused:
+		status = "Paused"
+	case deployment.Status.UpdatedReplicas < replicas || deployment.Status.Replicas > replicas:
+		status = "Progressing"
+	case deployment.Status.AvailableReplicas < replicas:
+		status = "Unavailable"
+	}
+	return &treeNode{
+		kind:   "Deployment",
+		name:   deployment.Name,
+		ready:  fmt.Sprintf("%d/%d", deployment.Status.AvailableReplicas, replicas),
+		status: status,
+		age:    age(deployment.CreationTimestamp),
+	}
+}
+
+func replicaSetNode(rs *appsv1.ReplicaSet) *treeNode {
+	replicas := int32(1)
+	if rs.Spec.Replicas != nil {
+		replicas = *rs.Spec.Replicas
+	}
+	var status string
+	if revision, ok := rs.Annotations[revisionAnnotation]; ok {
+		status = "revision " + revision
+	}
+	return &treeNode{
+		kind:   "ReplicaSet",
+		name:   rs.Name,
+		ready:  fmt.Sprintf("%d/%d", rs.Status.ReadyReplicas, replicas),
+		status: status,
+		age:    age(rs.CreationTimestamp),
+	}
+}
+
+func podNode(pod *corev1.Pod) *treeNode {
+	ready := 0
+	status := string(pod.Status.Phase)
+	for _, container := range pod.Status.ContainerStatuses {
+		if container.Ready {
+			ready++
+		}
+		if container.State.Waiting != nil && container.State.Waiting.Reason != "" {
+			status = container.State.Waiting.Reason
+		}
+	}
+	if pod.DeletionTimestamp != nil {
+		status = "Terminating"
+	}
+	return &treeNode{
+		kind:   "Pod",
+		name:   pod.Name,
+		ready:  fmt.Sprintf("%d/%d", ready, len(pod.Spec.Containers)),
+		status: status,
+		age:    age(pod.CreationTimestamp),
+	}
+}
+
+// printTree prints the tree with the columns of the objects aligned.
+func printTree(out io.Writer, root *treeNode) error {
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tREADY\tSTATUS\tAGE")
+	printTreeNode(w, root, "", "")
+	return w.Flush()
+}
+
+// printTreeNode prints the node after the prefix of its branch, and its
+// children sorted by kind and name after the prefix of the node's children.
+func printTreeNode(w io.Writer, node *treeNode, prefix, childPrefix string) {
+	fmt.Fprintf(w, "%s%s/%s\t%s\t%s\t%s\n", prefix, node.kind, node.name, node.ready, node.status, node.age)
+	sort.SliceStable(node.children, func(i, j int) bool {
+		if node.children[i].kind != node.children[j].kind {
+			return node.children[i].kind < node.children[j].kind
+		}
+		return node.children[i].name < node.children[j].name
+	})
+	for i, child := range node.children {
+		if i == len(node.children)-1 {
+			printTreeNode(w, child, childPrefix+"└─", childPrefix+"  ")
+		} else {
+			printTreeNode(w, child, childPrefix+"├─", childPrefix+"│ ")
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+
+	"github.com/spf13/cobra"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/cli-runtime/pkg/genericiooptions"
+	"k8s.io/client-go/tools/cache"
+	watchtools "k8s.io/client-go/tools/watch"
+)
+
+type waitOptions struct {
+	forCondition string
+	timeout      time.Duration
+}
+
+func newWaitCommand(f *factory, streams genericiooptions.IOStreams) *cobra.Command {
+	o := &waitOptions{}
+	cmd := &cobra.Command{
+		Use:   "wait NAME... --for=condition=TYPE[=STATUS]",
+		Short: "Wait for a condition of Foos",
+		Long: `Wait for a condition of Foos observing their latest spec, or for their deletion
+with --for=delete. The status defaults to True.
+
+The conditions of a Foo are Ready, Progressing, Degraded and ReconcileFailed.`,
+		Example: `  kubectl foo wait foo-sample --for=condition=Ready --timeout=2m
+  kubectl foo wait foo-sample --for=condition=Degraded=False
+  kubectl foo wait foo-sample --for=delete`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return o.run(cmd.Context(), f, streams, args)
+		},
+	}
+	cmd.Flags().StringVar(&o.forCondition, "for", "condition=Ready", "condition to wait for: condition=TYPE[=STATUS] or delete")
+	cmd.Flags().DurationVar(&o.timeout, "timeout", 30*time.Second, "time to wait for all the Foos")
+	return cmd
+}
+
+// waitCondition is the condition to wait for parsed from --for.
+type waitCondition struct {
+	// delete waits for the deletion of the Foo.
+	delete bool
+	// conditionType and status are the condition of the Foo waited for.
+	conditionType string
+	status        metav1.ConditionStatus
+}
+
+// parseWaitCondition parses "delete" or "condition=TYPE[=STATUS]".
+func parseWaitCondition(s string) (waitCondition, error) {
+	if strings.ToLower(s) == "delete" {
+		return waitCondition{delete: true}, nil
+	}
+	condition, ok := strings.CutPrefix(s, "condition=")
+	if !ok || condition == "" {
+		return waitCondition{}, fmt.Errorf("unknown --for %q: must be condition=TYPE[=STATUS] or delete", s)
+	}
+	conditionType, status, ok := strings.Cut(condition, "=")
+	if !ok {
+		status = string(metav1.ConditionTrue)
+	}
+	for _, s := range []metav1.ConditionStatus{metav1.ConditionTrue, metav1.ConditionFalse, metav1.ConditionUnknown} {
+		if strings.EqualFold(status, string(s)) {
+			return waitCondition{conditionType: conditionType, status: s}, nil
+		}
+	}
+	return waitCondition{}, fmt.Errorf("unknown condition status %q: must be True, False or Unknown", status)
+}
+
+// met returns true if the Foo has the condition for its latest generation.
+func (c waitCondition) met(foo *samplev1alpha1.Foo) bool {
+	if foo.Status.ObservedGeneration < foo.Generation {
+		return false
+	}
+	condition := meta.FindStatusCondition(foo.Status.Conditions, c.conditionType)
+	return condition != nil && condition.Status == c.status && condition.ObservedGeneration >= foo.Generation
+}
+
+func (o *waitOptions) run(ctx context.Context, f *factory, streams genericiooptions.IOStreams, names []string) error {
+	condition, err := parseWaitCondition(o.forCondition)
+	if err != nil {
+		return err
+	}
+	_, exampleClient, err := f.clients()
+	if err != nil {
+		return err
+	}
+	namespace, err := f.namespace()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeout)
+	defer cancel()
+	for _, name := range names {
+		if err := waitForFoo(ctx, exampleClient, namespace, name, condition); err != nil {
+			if ctx.Err() != nil {
+				return fmt.Errorf("timed out waiting for %s/%s", fooResource, name)
+			}
+			return err
+		}
+		if condition.delete {
+			fmt.Fprintf(streams.Out, "%s/%s deleted\n", fooResource, name)
+		} else {
+			fmt.Fprintf(streams.Out, "%s/%s condition met\n", fooResource, name)
+		}
+	}
+	return nil
+}
+
+// waitForFoo watches the Foo until the condition is met. It fails if the Foo
+// doesn't exist or is deleted while waiting for a condition.
+func waitForFoo(ctx context.Context, exampleClient clientset.Interface, namespace, name string, condition waitCondition) error {
+	foos := exampleClient.ExampleV1alpha1().Foos(namespace)
+	selector := fields.OneTermEqualSelector("metadata.name", name).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = selector
+			return foos.List(ctx, options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = selector
+			return foos.Watch(ctx, options)
+		},
+	}
+	precondition := func(store cache.Store) (bool, error) {
+		_, exists, err := store.GetByKey(namespace + "/" + name)
+		if err != nil {
+			return false, err
+		}
+		switch {
+		case !exists && condition.delete:
+			return true, nil
+		case !exists:
+			return false, errors.NewNotFound(samplev1alpha1.Resource("foos"), name)
+		}
+		return false, nil
+	}
+	_, err := watchtools.UntilWithSync(ctx, lw, &samplev1alpha1.Foo{}, precondition, func(event watch.Event) (bool, error) {
+		foo, ok := event.Object.(*samplev1alpha1.Foo)
+		// The field selector isn't supported by every server, e.g. the fake
+		// clientsets, so the name is checked again.
+		if !ok || foo.Name != name {
+			return false, nil
+		}
+		switch {
+		case event.Type == watch.Deleted && condition.delete:
+			return true, nil
+		case event.Type == watch.Deleted:
+			return false, fmt.Errorf("%s/%s is deleted", fooResource, name)
+		case condition.delete:
+			return false, nil
+		}
+		return condition.met(foo), nil
+	})
+	return err
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/klog/v2"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
+)
+
+// addConfigFlags registers the flags of the fields of the configuration,
+// whose defaults are the values of the fields.
+func addConfigFlags(fs *flag.FlagSet, cfg *configv1alpha1.ControllerConfiguration) {
+	int32Var(fs, &cfg.Workers, "workers", "number of Foos reconciled in parallel")
+	fs.Var((*namespacesValue)(&cfg.Namespaces), "namespaces", "comma-separated namespaces to watch (all the namespaces if empty)")
+	fs.StringVar(&cfg.FooSelector, "foo-selector", cfg.FooSelector, "label selector of the Foos to watch")
+	fs.StringVar(&cfg.DeploymentSelector, "deployment-selector", cfg.DeploymentSelector, "label selector of the Deployments to watch")
+	fs.DurationVar(&cfg.ResyncPeriod.Duration, "resync-period", cfg.ResyncPeriod.Duration, "period of the resync of the informers, which re-reconciles every Foo even if nothing has changed (0 to disable)")
+	fs.StringVar(&cfg.DryRun, "dry-run", cfg.DryRun, "none to make the changes, server to send the writes with DryRun: All or client to skip them, and report the changes at /pending-changes of --metrics-bind-address, which must not be 0")
+	fs.BoolVar(&cfg.Features.ManagedDeploymentsOnly, "managed-deployments-only", cfg.Features.ManagedDeploymentsOnly, "only watch the Deployments with the label set by the controller")
+	fs.BoolVar(&cfg.Features.Sharding, "enable-sharding", cfg.Features.Sharding, "shard the Foos across the replicas coordinated through Leases")
+
+	fs.BoolVar(&cfg.LeaderElection.LeaderElect, "leader-elect", cfg.LeaderElection.LeaderElect, "run the controller only in the replica elected as the leader through a Lease")
+	fs.DurationVar(&cfg.LeaderElection.LeaseDuration.Duration, "leader-elect-lease-duration", cfg.LeaderElection.LeaseDuration.Duration, "duration the standby replicas wait after the last renewal of the Lease before taking it over")
+	fs.DurationVar(&cfg.LeaderElection.RenewDeadline.Duration, "leader-elect-renew-deadline", cfg.LeaderElection.RenewDeadline.Duration, "duration the leader retries to renew the Lease before it stops leading")
+	fs.DurationVar(&cfg.LeaderElection.RetryPeriod.Duration, "leader-elect-retry-period", cfg.LeaderElection.RetryPeriod.Duration, "interval to try to acquire or renew the Lease")
+	fs.StringVar(&cfg.LeaderElection.ResourceName, "leader-elect-resource-name", cfg.LeaderElection.ResourceName, "name of the Lease of the leader election")
+	fs.StringVar(&cfg.LeaderElection.ResourceNamespace, "leader-elect-resource-namespace", cfg.LeaderElection.ResourceNamespace, "namespace of the Lease of the leader election")
+
+	fs.Float64Var(&cfg.ClientConnection.QPS, "kube-api-qps", cfg.ClientConnection.QPS, "rate of the requests to the API server")
+	int32Var(fs, &cfg.ClientConnection.Burst, "kube-api-burst", "number of the requests to the API server sent at once above --kube-api-qps")
+
+	fs.DurationVar(&cfg.Queue.BaseDelay.Duration, "queue-base-delay", cfg.Queue.BaseDelay.Duration, "delay of the first retry of a Foo, which is doubled on every failure")
+	fs.DurationVar(&cfg.Queue.MaxDelay.Duration, "queue-max-delay", cfg.Queue.MaxDelay.Duration, "upper limit of the delay of the retries of a Foo")
+	fs.Float64Var(&cfg.Queue.QPS, "queue-qps", cfg.Queue.QPS, "overall rate of retries of all the Foos")
+	int32Var(fs, &cfg.Queue.Burst, "queue-burst", "bucket size of the overall rate of retries")
+	int32Var(fs, &cfg.Queue.MaxRetries, "max-retries", "number of retries of a failing Foo before it's dropped from the workqueue (0 means unlimited)")
+
+	fs.StringVar(&cfg.Sharding.Name, "shard-name", cfg.Sharding.Name, "unique name of this replica among the shards (the hostname if empty)")
+	fs.StringVar(&cfg.Sharding.LeaseNamespace, "shard-lease-namespace", cfg.Sharding.LeaseNamespace, "namespace of the Leases of the shards")
+	fs.DurationVar(&cfg.Sharding.LeaseDuration.Duration, "shard-lease-duration", cfg.Sharding.LeaseDuration.Duration, "duration after which a shard is considered gone if it doesn't renew its Lease")
+	fs.DurationVar(&cfg.Sharding.RenewInterval.Duration, "shard-renew-interval", cfg.Sharding.RenewInterval.Duration, "interval to renew the Lease and observe the shards")
+	fs.DurationVar(&cfg.Sharding.HandoffDelay.Duration, "shard-handoff-delay", cfg.Sharding.HandoffDelay.Duration, "delay before processing the Foos moved from another shard")
+
+	fs.StringVar(&cfg.Metrics.BindAddress, "metrics-bind-address", cfg.Metrics.BindAddress, "address to serve the metrics on (0 to disable)")
+
+	fs.StringVar(&cfg.Logging.Format, "log-format", cfg.Logging.Format, "format of the logs (text or json)")
+	fs.Float64Var(&cfg.Logging.SampleQPS, "log-sample-qps", cfg.Logging.SampleQPS, "rate of the reconciles of each Foo that are logged after --log-sample-burst reconciles (0 to log all)")
+	int32Var(fs, &cfg.Logging.SampleBurst, "log-sample-burst", "number of the reconciles of each Foo that are logged at once")
+
+	fs.Float64Var(cfg.Events.QPS, "event-qps", *cfg.Events.QPS, "rate of the Events of each Foo after --event-burst Events (0 for no limit)")
+	int32Var(fs, &cfg.Events.Burst, "event-burst", "number of the Events of each Foo that are emitted at once")
+	fs.DurationVar(&cfg.Events.AggregationWindow.Duration, "event-aggregation-window", cfg.Events.AggregationWindow.Duration, "window in which the repeats of a warning Event of a Foo are counted instead of emitted (0 to emit all)")
+
+	fs.StringVar(&cfg.Tracing.Exporter, "tracing-exporter", cfg.Tracing.Exporter, "exporter of the spans of the reconciles and API calls (none, stdout, file or otlp)")
+	fs.StringVar(&cfg.Tracing.File, "tracing-file", cfg.Tracing.File, "path of the file to write the spans to with --tracing-exporter=file")
+	fs.StringVar(&cfg.Tracing.OTLPEndpoint, "tracing-otlp-endpoint", cfg.Tracing.OTLPEndpoint, "host:port of the OTLP/HTTP endpoint with --tracing-exporter=otlp (OTEL_EXPORTER_OTLP_ENDPOINT if empty)")
+	fs.BoolVar(&cfg.Tracing.OTLPInsecure, "tracing-otlp-insecure", cfg.Tracing.OTLPInsecure, "disable TLS to the OTLP/HTTP endpoint")
+	fs.Float64Var(cfg.Tracing.SampleRatio, "tracing-sample-ratio", *cfg.Tracing.SampleRatio, "ratio of the reconciles that are traced")
+}
+
+// int32Value is a flag.Value of an int32.
+type int32Value int32
+
+func int32Var(fs *flag.FlagSet, p *int32, name, usage string) {
+	fs.Var((*int32Value)(p), name, usage)
+}
+
+func (v *int32Value) String() string { return strconv.FormatInt(int64(*v), 10) }
+
+func (v *int32Value) Set(s string) error {
+	i, err := strconv.ParseInt(s, 0, 32)
+	if err != nil {
+		return err
+	}
+	*v = int32Value(i)
+	return nil
+}
+
+// namespacesValue is a flag.Value of a comma-separated list of namespaces.
+type namespacesValue []string
+
+func (v *namespacesValue) String() string { return strings.Join(*v, ",") }
+
+func (v *namespacesValue) Set(s string) error {
+	*v = splitNamespaces(s)
+	return nil
+}
+
+// splitNamespaces returns the namespaces in the comma-separated list without
+// empty or duplicated ones.
+func splitNamespaces(list string) []string {
+	var namespaces []string
+	seen := map[string]bool{}
+	for _, namespace := range strings.Split(list, ",") {
+		namespace = strings.TrimSpace(namespace)
+		if namespace == "" || seen[namespace] {
+			continue
+		}
+		seen[namespace] = true
+		namespaces = append(namespaces, namespace)
+	}
+	return namespaces
+}
+
+// configOverrides returns the values of the configuration flags set on the
+// command line by name.
+func configOverrides(fs *flag.FlagSet) map[string]string {
+	configFlags := flag.NewFlagSet("", flag.ContinueOnError)
+	addConfigFlags(configFlags, configv1alpha1.NewDefaultControllerConfiguration())
+	overrides := map[string]string{}
+	fs.Visit(func(f *flag.Flag) {
+		if configFlags.Lookup(f.Name) != nil {
+			overrides[f.Name] = f.Value.String()
+		}
+	})
+	return overrides
+}
+
+// loadConfig returns the configuration of the file, or the defaults without
+// a file, overridden by the flags and validated.
+func loadConfig(path string, overrides map[string]string) (*configv1alpha1.ControllerConfiguration, error) {
+	cfg := configv1alpha1.NewDefaultControllerConfiguration()
+	if path != "" {
+		var err error
+		if cfg, err = configv1alpha1.Load(path); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	addConfigFlags(fs, cfg)
+	for name, value := range overrides {
+		if err := fs.Set(name, value); err != nil {
+			return nil, fmt.Errorf("--%s: %w", name, err)
+		}
+	}
+	if cfg.Sharding.Name == "" {
+		cfg.Sharding.Name, _ = os.Hostname()
+	}
+	if errs := configv1alpha1.Validate(cfg); len(errs) > 0 {
+		return nil, errs.ToAggregate()
+	}
+	return cfg, nil
+}
+
+// controllerOptions returns the options of the controller in the
+// configuration. The dependencies, such as the rate limiter, are left for
+// the caller.
+func controllerOptions(cfg *configv1alpha1.ControllerConfiguration) controller.ControllerOptions {
+	return controller.ControllerOptions{
+		Workers:        int(cfg.Workers),
+		MaxRetries:     int(cfg.Queue.MaxRetries),
+		LogSampleQPS:   cfg.Logging.SampleQPS,
+		LogSampleBurst: int(cfg.Logging.SampleBurst),
+		Events: controller.EventPolicyOptions{
+			QPS:               *cfg.Events.QPS,
+			Burst:             int(cfg.Events.Burst),
+			AggregationWindow: cfg.Events.AggregationWindow.Duration,
+		},
+		DryRun: cfg.DryRun,
+	}
+}
+
+// withReloadableFields returns a copy of the configuration with the fields
+// applied by Controller.Reload taken from the other configuration.
+func withReloadableFields(cfg, from *configv1alpha1.ControllerConfiguration) *configv1alpha1.ControllerConfiguration {
+	cfg = cfg.DeepCopy()
+	cfg.Queue.MaxRetries = from.Queue.MaxRetries
+	cfg.Logging.SampleQPS = from.Logging.SampleQPS
+	cfg.Logging.SampleBurst = from.Logging.SampleBurst
+	from.Events.DeepCopyInto(&cfg.Events)
+	return cfg
+}
+
+// reloadConfigOnSignal reloads the configuration file on SIGHUP until the
+// context is cancelled. The retries, the log sampling and the Events are
+// applied to the controller, and the other fields, which are used to build
+// the informers, the clients and the servers, need a restart. An invalid file
+// is ignored.
+func reloadConfigOnSignal(ctx context.Context, path string, overrides map[string]string, cfg *configv1alpha1.ControllerConfiguration, fooController *controller.Controller) {
+	logger := klog.FromContext(ctx)
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+		}
+		if path == "" {
+			logger.Info("Ignoring SIGHUP without --config")
+			continue
+		}
+		reloaded, err := loadConfig(path, overrides)
+		if err != nil {
+			logger.Error(err, "Error reloading the configuration, keeping the current one")
+			continue
+		}
+		fooController.Reload(controllerOptions(reloaded))
+		cfg = withReloadableFields(cfg, reloaded)
+		if !equality.Semantic.DeepEqual(cfg, reloaded) {
+			logger.Info("Some changes of the configuration take effect on a restart", "path", path)
+		}
+		logger.Info("Reloaded the configuration", "path", path)
+	}
+}
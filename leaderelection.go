@@ -0,0 +1,78 @@
+package main
+
+import (
+	"context"
+	"os"
+
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/leaderelection"
+	"k8s.io/client-go/tools/leaderelection/resourcelock"
+	"k8s.io/klog/v2"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+)
+
+// runWithLeaderElection waits until this replica is elected as the leader
+// through the Lease of the configuration, and calls run until the context is
+// cancelled. The Lease is released after run returns, so a standby replica
+// takes over without waiting for it to expire. The process exits if the Lease
+// is lost, as the informers and the workqueue of the controller can't be
+// started again.
+func runWithLeaderElection(ctx context.Context, client kubernetes.Interface, cfg configv1alpha1.LeaderElectionConfiguration, run func(context.Context)) error {
+	logger := klog.FromContext(ctx)
+	hostname, err := os.Hostname()
+	if err != nil {
+		return err
+	}
+	// The UID distinguishes the replicas restarted on the same host.
+	identity := hostname + "_" + string(uuid.NewUUID())
+	lock, err := resourcelock.New(resourcelock.LeasesResourceLock, cfg.ResourceNamespace, cfg.ResourceName, client.CoreV1(), client.CoordinationV1(), resourcelock.ResourceLockConfig{Identity: identity})
+	if err != nil {
+		return err
+	}
+
+	// The elector runs with its own context, which is cancelled after run
+	// returns to release the Lease.
+	electorCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	defer cancel()
+	elected := make(chan struct{})
+	elector, err := leaderelection.NewLeaderElector(leaderelection.LeaderElectionConfig{
+		Lock:            lock,
+		LeaseDuration:   cfg.LeaseDuration.Duration,
+		RenewDeadline:   cfg.RenewDeadline.Duration,
+		RetryPeriod:     cfg.RetryPeriod.Duration,
+		ReleaseOnCancel: true,
+		Name:            cfg.ResourceName,
+		Callbacks: leaderelection.LeaderCallbacks{
+			OnStartedLeading: func(context.Context) {
+				close(elected)
+			},
+			OnStoppedLeading: func() {
+				if electorCtx.Err() == nil {
+					logger.Error(nil, "Lost the leader election Lease", "identity", identity)
+					klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+				}
+			},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	logger.Info("Waiting to be elected as the leader", "lease", klog.KRef(cfg.ResourceNamespace, cfg.ResourceName), "identity", identity)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		elector.Run(electorCtx)
+	}()
+	select {
+	case <-elected:
+		logger.Info("Elected as the leader", "identity", identity)
+		run(ctx)
+	case <-ctx.Done():
+	}
+	cancel()
+	<-done
+	return nil
+}
@@ -1,58 +1,159 @@
 package main
 
 import (
+	"context"
 	"flag"
-	"path/filepath"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 
-	kubeinformers "k8s.io/client-go/informers"
 	"k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/tools/clientcmd"
-	"k8s.io/client-go/util/homedir"
 	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
 
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/controller"
 	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
-	informers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions"
 )
 
 func main() {
 	klog.InitFlags(nil)
 
-	var kubeconfig *string
-	if home := homedir.HomeDir(); home != "" {
-		kubeconfig = flag.String("kubeconfig", filepath.Join(home, ".kube", "config"), "(optional)")
-	} else {
-		kubeconfig = flag.String("kubeconfig", "", "absolute path to kubeconfig file")
-	}
+	kubeconfig := flag.String("kubeconfig", "", "path to the kubeconfig file ($KUBECONFIG or ~/.kube/config if empty, and the in-cluster config if none exists)")
+	master := flag.String("master", "", "address of the API server, which overrides the server of the kubeconfig or the in-cluster config")
+	kubeContext := flag.String("context", "", "context of the kubeconfig to use (the current context if empty)")
+	configFile := flag.String("config", "", "path of the ControllerConfiguration file, which is overridden by the flags set on the command line and reloaded on SIGHUP")
+	addConfigFlags(flag.CommandLine, configv1alpha1.NewDefaultControllerConfiguration())
 	flag.Parse()
 
-	config, err := clientcmd.BuildConfigFromFlags("", *kubeconfig)
+	overrides := configOverrides(flag.CommandLine)
+	cfg, err := loadConfig(*configFile, overrides)
+	if err != nil {
+		klog.Fatalf("Error loading the configuration: %s", err.Error())
+	}
+	if err := controller.SetupLogging(cfg.Logging.Format); err != nil {
+		klog.Fatalf("Error setting up logging: %s", err.Error())
+	}
+	// The context is cancelled on SIGINT or SIGTERM to shut down gracefully.
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	logger := klog.FromContext(ctx)
+
+	config, err := buildRestConfig(*kubeconfig, *master, *kubeContext, cfg.ClientConnection)
+	if err != nil {
+		logger.Error(err, "Error building kubeconfig")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+
+	tracerProvider, shutdownTracing, err := controller.NewTracerProvider(ctx, controller.TracingOptions{
+		Exporter:     cfg.Tracing.Exporter,
+		File:         cfg.Tracing.File,
+		OTLPEndpoint: cfg.Tracing.OTLPEndpoint,
+		OTLPInsecure: cfg.Tracing.OTLPInsecure,
+		SampleRatio:  *cfg.Tracing.SampleRatio,
+	})
 	if err != nil {
-		klog.Fatalf("Error building kubeconfig: %s", err.Error())
+		logger.Error(err, "Error setting up tracing")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
+	defer func() {
+		// The context is already cancelled, so the spans are flushed with
+		// a new one.
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error(err, "Error shutting down tracing")
+		}
+	}()
+	controller.WrapTransport(config, tracerProvider)
 
 	kubeClient, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		klog.Errorf("getting kubernetes client set %s", err.Error())
+		logger.Error(err, "Error building kubernetes clientset")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
 	exampleClient, err := clientset.NewForConfig(config)
 	if err != nil {
-		klog.Fatalf("Error building example clientset: %s", err.Error())
+		logger.Error(err, "Error building example clientset")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
 	}
 
-	kubeInformerFactory := kubeinformers.NewSharedInformerFactory(kubeClient, time.Second*30)
-	exampleInformerFactory := informers.NewSharedInformerFactory(exampleClient, time.Second*30)
-	stopCh := make(chan struct{})
-	controller := NewController(
+	deploymentSelector := cfg.DeploymentSelector
+	if cfg.Features.ManagedDeploymentsOnly {
+		selector := controller.ManagedDeploymentSelector
+		if deploymentSelector != "" {
+			selector += "," + deploymentSelector
+		}
+		deploymentSelector = selector
+	}
+
+	informerSets, factories, err := controller.NewInformers(kubeClient, exampleClient, cfg.Namespaces, cfg.FooSelector, deploymentSelector, cfg.ResyncPeriod.Duration)
+	if err != nil {
+		logger.Error(err, "Error building informers")
+		klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+	}
+	controllerOpts := controllerOptions(cfg)
+	controllerOpts.RateLimiter = controller.NewRateLimiter(controller.RateLimiterOptions{
+		BaseDelay: cfg.Queue.BaseDelay.Duration,
+		MaxDelay:  cfg.Queue.MaxDelay.Duration,
+		QPS:       cfg.Queue.QPS,
+		Burst:     int(cfg.Queue.Burst),
+	}, clock.RealClock{})
+	controllerOpts.TracerProvider = tracerProvider
+	var shards *controller.ShardManager
+	if cfg.Features.Sharding {
+		shards = controller.NewShardManager(kubeClient, controller.ShardOptions{
+			Name:          cfg.Sharding.Name,
+			Namespace:     cfg.Sharding.LeaseNamespace,
+			LeaseDuration: cfg.Sharding.LeaseDuration.Duration,
+			RenewInterval: cfg.Sharding.RenewInterval.Duration,
+			HandoffDelay:  cfg.Sharding.HandoffDelay.Duration,
+		}, clock.RealClock{})
+		controllerOpts.Sharder = shards
+	}
+	fooController := controller.NewController(
+		ctx,
 		kubeClient,
 		exampleClient,
-		kubeInformerFactory.Apps().V1().Deployments(),
-		exampleInformerFactory.Example().V1alpha1().Foos(),
+		informerSets,
+		controllerOpts,
 	)
-	kubeInformerFactory.Start(stopCh)
-	exampleInformerFactory.Start(stopCh)
-	if err = controller.Run(stopCh); err != nil {
-		klog.Fatalf("error occurred when running controller %s", err.Error())
+	handlers := map[string]http.Handler{}
+	if handler := fooController.PendingChangesHandler(); handler != nil {
+		handlers["/pending-changes"] = handler
+	}
+	controller.ServeMetrics(ctx, cfg.Metrics.BindAddress, handlers)
+	go reloadConfigOnSignal(ctx, *configFile, overrides, cfg, fooController)
+	// The shard manager deletes its Lease on shutdown, which is waited for.
+	var wg sync.WaitGroup
+	if shards != nil {
+		shards.OnRebalance(fooController.EnqueueShard)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			shards.Run(ctx)
+		}()
+	}
+	run := func(ctx context.Context) {
+		for _, factory := range factories {
+			factory.Start(ctx.Done())
+		}
+		if err := fooController.Run(ctx); err != nil {
+			logger.Error(err, "Error running controller")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+	if cfg.LeaderElection.LeaderElect {
+		if err := runWithLeaderElection(ctx, kubeClient, cfg.LeaderElection, run); err != nil {
+			logger.Error(err, "Error running leader election")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	} else {
+		run(ctx)
 	}
+	wg.Wait()
 }
@@ -0,0 +1,254 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// TestLoadConfigOverrides checks the flags set on the command line override
+// the file, and the others don't.
+func TestLoadConfigOverrides(t *testing.T) {
+	path := writeConfig(t, `apiVersion: config.example.com/v1alpha1
+kind: ControllerConfiguration
+workers: 4
+namespaces: [team-a]
+queue:
+  maxRetries: 3
+events:
+  burst: 20
+`)
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	fs.String("kubeconfig", "", "")
+	cfg, err := loadConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addConfigFlags(fs, cfg)
+	if err := fs.Parse([]string{"--kubeconfig=config", "--namespaces=team-b,team-c", "--max-retries=5"}); err != nil {
+		t.Fatal(err)
+	}
+	overrides := configOverrides(fs)
+	if len(overrides) != 2 {
+		t.Fatalf("Expected the overrides of --namespaces and --max-retries, got %v", overrides)
+	}
+
+	cfg, err = loadConfig(path, overrides)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Workers != 4 || cfg.Events.Burst != 20 {
+		t.Errorf("Expected the workers and the Event burst of the file, got %d and %d", cfg.Workers, cfg.Events.Burst)
+	}
+	if len(cfg.Namespaces) != 2 || cfg.Namespaces[0] != "team-b" || cfg.Queue.MaxRetries != 5 {
+		t.Errorf("Expected the namespaces and the max retries of the flags, got %v and %d", cfg.Namespaces, cfg.Queue.MaxRetries)
+	}
+	if cfg.Sharding.Name == "" {
+		t.Error("Expected the shard name to default to the hostname")
+	}
+
+	if _, err := loadConfig(writeConfig(t, "apiVersion: config.example.com/v1alpha1\nkind: ControllerConfiguration\nworkers: -1\n"), overrides); err == nil {
+		t.Error("Expected an error loading an invalid configuration")
+	}
+}
+
+func TestWithReloadableFields(t *testing.T) {
+	current, err := loadConfig("", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reloaded := current.DeepCopy()
+	reloaded.Queue.MaxRetries = 3
+	reloaded.Events.AggregationWindow.Duration = time.Minute
+	if cfg := withReloadableFields(current, reloaded); !equality.Semantic.DeepEqual(cfg, reloaded) {
+		t.Errorf("Expected the changes of the retries and the Events to be reloadable, got %+v", cfg)
+	}
+
+	reloaded.Workers = 8
+	if cfg := withReloadableFields(current, reloaded); cfg.Workers != current.Workers || equality.Semantic.DeepEqual(cfg, reloaded) {
+		t.Errorf("Expected the change of the workers to need a restart, got %+v", cfg)
+	}
+}
+
+// TestRunWithLeaderElection checks a standby replica doesn't run while the
+// leader renews the Lease, and runs once the leader stops and releases it.
+func TestRunWithLeaderElection(t *testing.T) {
+	client := k8sfake.NewSimpleClientset()
+	cfg := configv1alpha1.LeaderElectionConfiguration{
+		LeaderElect:       true,
+		LeaseDuration:     metav1.Duration{Duration: time.Second},
+		RenewDeadline:     metav1.Duration{Duration: 500 * time.Millisecond},
+		RetryPeriod:       metav1.Duration{Duration: 100 * time.Millisecond},
+		ResourceName:      "sample-controller",
+		ResourceNamespace: metav1.NamespaceDefault,
+	}
+	running := make(chan string, 2)
+	start := func(name string) (context.CancelFunc, <-chan error) {
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan error, 1)
+		go func() {
+			done <- runWithLeaderElection(ctx, client, cfg, func(ctx context.Context) {
+				running <- name
+				<-ctx.Done()
+			})
+		}()
+		return cancel, done
+	}
+
+	stopLeader, leaderDone := start("leader")
+	select {
+	case name := <-running:
+		if name != "leader" {
+			t.Fatalf("Expected the leader to run, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the leader to be elected")
+	}
+	stopStandby, standbyDone := start("standby")
+	select {
+	case name := <-running:
+		t.Fatalf("Expected %s not to run while the leader renews the Lease", name)
+	case <-time.After(3 * cfg.LeaseDuration.Duration / 2):
+	}
+
+	stopLeader()
+	if err := <-leaderDone; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case name := <-running:
+		if name != "standby" {
+			t.Fatalf("Expected the standby to run, got %s", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the standby to take over the released Lease")
+	}
+	stopStandby()
+	if err := <-standbyDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+const testKubeconfig = `apiVersion: v1
+kind: Config
+clusters:
+- name: a
+  cluster:
+    server: https://a.example.com
+- name: b
+  cluster:
+    server: https://b.example.com
+contexts:
+- name: a
+  context:
+    cluster: a
+- name: b
+  context:
+    cluster: b
+current-context: a
+`
+
+func TestBuildRestConfig(t *testing.T) {
+	kubeconfig := filepath.Join(t.TempDir(), "kubeconfig")
+	if err := os.WriteFile(kubeconfig, []byte(testKubeconfig), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := loadConfig("", map[string]string{"kube-api-qps": "50", "kube-api-burst": "100"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name    string
+		master  string
+		context string
+		host    string
+	}{
+		{name: "current context", host: "https://a.example.com"},
+		{name: "context", context: "b", host: "https://b.example.com"},
+		{name: "master", master: "https://c.example.com", context: "b", host: "https://c.example.com"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			config, err := buildRestConfig(kubeconfig, tc.master, tc.context, cfg.ClientConnection)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Host != tc.host {
+				t.Errorf("Expected the host %s, got %s", tc.host, config.Host)
+			}
+			if config.QPS != 50 || config.Burst != 100 {
+				t.Errorf("Expected the QPS and the burst of the flags, got %v and %d", config.QPS, config.Burst)
+			}
+			if !strings.HasPrefix(config.UserAgent, "sample-controller/") {
+				t.Errorf("Expected the user agent of the controller, got %q", config.UserAgent)
+			}
+		})
+	}
+
+	// Without a kubeconfig file, the in-cluster config is used, which fails
+	// outside a pod.
+	t.Setenv("KUBECONFIG", filepath.Join(t.TempDir(), "missing"))
+	t.Setenv("HOME", t.TempDir())
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	if _, err := buildRestConfig("", "", "", cfg.ClientConnection); err == nil {
+		t.Error("Expected an error without a kubeconfig file outside a pod")
+	}
+	if _, err := buildRestConfig("", "https://c.example.com", "", cfg.ClientConnection); err == nil {
+		t.Error("Expected an error with --master without a kubeconfig file outside a pod")
+	}
+
+	// In a pod, --master overrides the server of the in-cluster config,
+	// whose credentials are kept.
+	inClusterConfig = func() (*rest.Config, error) {
+		return &rest.Config{
+			Host:            "https://10.96.0.1:443",
+			BearerTokenFile: "/var/run/secrets/kubernetes.io/serviceaccount/token",
+			TLSClientConfig: rest.TLSClientConfig{CAFile: "/var/run/secrets/kubernetes.io/serviceaccount/ca.crt"},
+		}, nil
+	}
+	t.Cleanup(func() { inClusterConfig = rest.InClusterConfig })
+	config, err := buildRestConfig("", "https://c.example.com", "", cfg.ClientConnection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.Host != "https://c.example.com" {
+		t.Errorf("Expected the host of --master, got %s", config.Host)
+	}
+	if config.BearerTokenFile == "" || config.TLSClientConfig.CAFile == "" {
+		t.Errorf("Expected the credentials of the in-cluster config, got %+v", config)
+	}
+	if config.QPS != 50 || !strings.HasPrefix(config.UserAgent, "sample-controller/") {
+		t.Errorf("Expected the QPS and the user agent of the controller, got %v and %q", config.QPS, config.UserAgent)
+	}
+}
+
+func TestControllerVersion(t *testing.T) {
+	defer func(v string) { version = v }(version)
+	version = "v0.1.0"
+	if got := controllerVersion(); got != "v0.1.0" {
+		t.Errorf("Expected the version set with -ldflags, got %s", got)
+	}
+	version = ""
+	if got := controllerVersion(); got == "" {
+		t.Error("Expected the version of the build info")
+	}
+}
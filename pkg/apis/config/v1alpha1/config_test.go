@@ -0,0 +1,79 @@
+package v1alpha1
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/utils/pointer"
+)
+
+func TestDecode(t *testing.T) {
+	cfg, err := Decode([]byte(`apiVersion: config.example.com/v1alpha1
+kind: ControllerConfiguration
+workers: 4
+resyncPeriod: 10m
+namespaces: [team-a, team-b]
+queue:
+  maxRetries: 3
+events:
+  qps: 0
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := NewDefaultControllerConfiguration()
+	want.TypeMeta = metav1.TypeMeta{APIVersion: SchemeGroupVersion.String(), Kind: "ControllerConfiguration"}
+	want.Workers = 4
+	want.ResyncPeriod = &metav1.Duration{Duration: 10 * time.Minute}
+	want.Namespaces = []string{"team-a", "team-b"}
+	want.Queue.MaxRetries = 3
+	want.Events.QPS = pointer.Float64(0)
+	if diff := cmp.Diff(want, cfg); diff != "" {
+		t.Errorf("unexpected configuration (-want +got):\n%s", diff)
+	}
+	if errs := Validate(cfg); len(errs) > 0 {
+		t.Errorf("Expected a valid configuration, got %v", errs)
+	}
+}
+
+func TestDecodeError(t *testing.T) {
+	for name, data := range map[string]string{
+		"unknown field": "apiVersion: config.example.com/v1alpha1\nkind: ControllerConfiguration\nworkerz: 2\n",
+		"unknown kind":  "apiVersion: config.example.com/v1alpha1\nkind: FooConfiguration\n",
+		"missing kind":  "workers: 2\n",
+	} {
+		if _, err := Decode([]byte(data)); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cfg := NewDefaultControllerConfiguration()
+	cfg.Namespaces = []string{"Team_A"}
+	cfg.FooSelector = "app in (a"
+	cfg.DryRun = "client"
+	cfg.Metrics.BindAddress = "0"
+	cfg.ClientConnection.Burst = -1
+	cfg.LeaderElection.LeaderElect = true
+	cfg.LeaderElection.RenewDeadline = cfg.LeaderElection.LeaseDuration
+	cfg.Queue.MaxDelay = metav1.Duration{Duration: time.Millisecond}
+	cfg.Features.Sharding = true
+	cfg.Sharding.Name = "replica-0"
+	cfg.Sharding.RenewInterval = cfg.Sharding.LeaseDuration
+	cfg.Sharding.HandoffDelay = metav1.Duration{Duration: cfg.Sharding.RenewInterval.Duration - time.Second}
+	cfg.Tracing.Exporter = "file"
+	cfg.Tracing.SampleRatio = pointer.Float64(2)
+
+	var fields []string
+	for _, err := range Validate(cfg) {
+		fields = append(fields, err.Field)
+	}
+	want := []string{"namespaces[0]", "fooSelector", "dryRun", "clientConnection.burst", "leaderElection.leaderElect", "leaderElection.leaseDuration", "queue.maxDelay", "sharding.renewInterval", "sharding.handoffDelay", "tracing.file", "tracing.sampleRatio"}
+	if strings.Join(fields, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected errors of %v, got %v", want, fields)
+	}
+}
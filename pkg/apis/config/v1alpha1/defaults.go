@@ -0,0 +1,154 @@
+package v1alpha1
+
+import (
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// The defaults of the fields without a default in the options of the
+// controller.
+const (
+	defaultWorkers = 1
+	// The resync re-reconciles every Foo, which is only needed to recover
+	// from the errors that aren't retried, such as ErrResourceExists.
+	defaultResyncPeriod       = 0
+	defaultMetricsBindAddress = ":8080"
+	defaultLogSampleBurst     = 5
+	// The rate of the requests to the API server is higher than the
+	// default of client-go, as for kube-controller-manager.
+	defaultKubeAPIQPS   = 20
+	defaultKubeAPIBurst = 30
+	// The defaults of the leader election are the same as the Kubernetes
+	// components.
+	defaultLeaderElectionLeaseDuration     = 15 * time.Second
+	defaultLeaderElectionRenewDeadline     = 10 * time.Second
+	defaultLeaderElectionRetryPeriod       = 2 * time.Second
+	defaultLeaderElectionResourceName      = "sample-controller"
+	defaultLeaderElectionResourceNamespace = metav1.NamespaceDefault
+)
+
+// The defaults of the fields which are also the defaults of the options of
+// the controller.
+const (
+	// The defaults of the rate limiter are the same as
+	// workqueue.DefaultControllerRateLimiter.
+	DefaultQueueBaseDelay = 5 * time.Millisecond
+	DefaultQueueMaxDelay  = 1000 * time.Second
+	DefaultQueueQPS       = 10
+	DefaultQueueBurst     = 100
+
+	DefaultShardLeaseNamespace = metav1.NamespaceDefault
+	DefaultShardLeaseDuration  = 15 * time.Second
+	DefaultShardRenewInterval  = 5 * time.Second
+	DefaultShardHandoffDelay   = 15 * time.Second
+
+	DefaultEventQPS               = 0.1
+	DefaultEventBurst             = 10
+	DefaultEventAggregationWindow = 10 * time.Minute
+
+	DefaultTracingExporter    = TracingExporterNone
+	DefaultTracingSampleRatio = 1.0
+)
+
+func addDefaultingFuncs(scheme *runtime.Scheme) error {
+	scheme.AddTypeDefaultingFunc(&ControllerConfiguration{}, func(obj interface{}) {
+		SetDefaults_ControllerConfiguration(obj.(*ControllerConfiguration))
+	})
+	return nil
+}
+
+// SetDefaults_ControllerConfiguration sets the defaults of the fields left
+// empty, which are the defaults of the options of the controller. The name
+// of the shard is left empty for the caller to default it to the hostname.
+func SetDefaults_ControllerConfiguration(obj *ControllerConfiguration) {
+	if obj.Workers == 0 {
+		obj.Workers = defaultWorkers
+	}
+	if obj.ResyncPeriod == nil {
+		obj.ResyncPeriod = &metav1.Duration{Duration: defaultResyncPeriod}
+	}
+	if obj.DryRun == "" {
+		obj.DryRun = DryRunNone
+	}
+
+	if obj.ClientConnection.QPS == 0 {
+		obj.ClientConnection.QPS = defaultKubeAPIQPS
+	}
+	if obj.ClientConnection.Burst == 0 {
+		obj.ClientConnection.Burst = defaultKubeAPIBurst
+	}
+
+	if obj.LeaderElection.LeaseDuration.Duration == 0 {
+		obj.LeaderElection.LeaseDuration.Duration = defaultLeaderElectionLeaseDuration
+	}
+	if obj.LeaderElection.RenewDeadline.Duration == 0 {
+		obj.LeaderElection.RenewDeadline.Duration = defaultLeaderElectionRenewDeadline
+	}
+	if obj.LeaderElection.RetryPeriod.Duration == 0 {
+		obj.LeaderElection.RetryPeriod.Duration = defaultLeaderElectionRetryPeriod
+	}
+	if obj.LeaderElection.ResourceName == "" {
+		obj.LeaderElection.ResourceName = defaultLeaderElectionResourceName
+	}
+	if obj.LeaderElection.ResourceNamespace == "" {
+		obj.LeaderElection.ResourceNamespace = defaultLeaderElectionResourceNamespace
+	}
+
+	if obj.Queue.BaseDelay.Duration == 0 {
+		obj.Queue.BaseDelay.Duration = DefaultQueueBaseDelay
+	}
+	if obj.Queue.MaxDelay.Duration == 0 {
+		obj.Queue.MaxDelay.Duration = DefaultQueueMaxDelay
+	}
+	if obj.Queue.QPS == 0 {
+		obj.Queue.QPS = DefaultQueueQPS
+	}
+	if obj.Queue.Burst == 0 {
+		obj.Queue.Burst = DefaultQueueBurst
+	}
+
+	if obj.Sharding.LeaseNamespace == "" {
+		obj.Sharding.LeaseNamespace = DefaultShardLeaseNamespace
+	}
+	if obj.Sharding.LeaseDuration.Duration == 0 {
+		obj.Sharding.LeaseDuration.Duration = DefaultShardLeaseDuration
+	}
+	if obj.Sharding.RenewInterval.Duration == 0 {
+		obj.Sharding.RenewInterval.Duration = DefaultShardRenewInterval
+	}
+	if obj.Sharding.HandoffDelay.Duration == 0 {
+		obj.Sharding.HandoffDelay.Duration = DefaultShardHandoffDelay
+	}
+
+	if obj.Metrics.BindAddress == "" {
+		obj.Metrics.BindAddress = defaultMetricsBindAddress
+	}
+
+	if obj.Logging.Format == "" {
+		obj.Logging.Format = LogFormatText
+	}
+	if obj.Logging.SampleBurst == 0 {
+		obj.Logging.SampleBurst = defaultLogSampleBurst
+	}
+
+	if obj.Events.QPS == nil {
+		qps := DefaultEventQPS
+		obj.Events.QPS = &qps
+	}
+	if obj.Events.Burst == 0 {
+		obj.Events.Burst = DefaultEventBurst
+	}
+	if obj.Events.AggregationWindow == nil {
+		obj.Events.AggregationWindow = &metav1.Duration{Duration: DefaultEventAggregationWindow}
+	}
+
+	if obj.Tracing.Exporter == "" {
+		obj.Tracing.Exporter = DefaultTracingExporter
+	}
+	if obj.Tracing.SampleRatio == nil {
+		ratio := DefaultTracingSampleRatio
+		obj.Tracing.SampleRatio = &ratio
+	}
+}
@@ -0,0 +1,6 @@
+// +k8s:deepcopy-gen=package
+// +groupName=config.example.com
+
+// Package v1alpha1 is the v1alpha1 version of the configuration file of the
+// controller.
+package v1alpha1
@@ -0,0 +1,55 @@
+package v1alpha1
+
+import (
+	"fmt"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+)
+
+var (
+	// scheme has only the configuration types, so a file of another kind
+	// fails to load.
+	scheme = runtime.NewScheme()
+	// codecs decode strictly, so the unknown and duplicated fields fail to
+	// load rather than being ignored.
+	codecs = serializer.NewCodecFactory(scheme, serializer.EnableStrict)
+)
+
+func init() {
+	// The known types always register.
+	_ = AddToScheme(scheme)
+}
+
+// Load reads the ControllerConfiguration from the YAML or JSON file and sets
+// its defaults.
+func Load(path string) (*ControllerConfiguration, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(data)
+}
+
+// Decode decodes the ControllerConfiguration from YAML or JSON and sets its
+// defaults.
+func Decode(data []byte) (*ControllerConfiguration, error) {
+	obj, gvk, err := codecs.UniversalDecoder(SchemeGroupVersion).Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	config, ok := obj.(*ControllerConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("unexpected kind %s: must be ControllerConfiguration", gvk.Kind)
+	}
+	return config, nil
+}
+
+// NewDefaultControllerConfiguration returns the ControllerConfiguration with
+// the defaults.
+func NewDefaultControllerConfiguration() *ControllerConfiguration {
+	config := &ControllerConfiguration{}
+	scheme.Default(config)
+	return config
+}
@@ -0,0 +1,270 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// The values of ControllerConfiguration.DryRun.
+const (
+	// DryRunNone makes the writes of the controller.
+	DryRunNone = "none"
+	// DryRunServer sends the writes of the controller with DryRun: All, so
+	// the API server validates and defaults them without persisting them.
+	DryRunServer = "server"
+	// DryRunClient skips the writes of the controller. Creates of existing
+	// objects and updates of stale objects fail as they would on the API
+	// server.
+	DryRunClient = "client"
+)
+
+// The values of LoggingConfiguration.Format.
+const (
+	// LogFormatText is the default text format of klog.
+	LogFormatText = "text"
+	// LogFormatJSON is the JSON format with a log entry per line.
+	LogFormatJSON = "json"
+)
+
+// The values of TracingConfiguration.Exporter.
+const (
+	// TracingExporterNone disables tracing.
+	TracingExporterNone = "none"
+	// TracingExporterStdout writes the spans to stdout.
+	TracingExporterStdout = "stdout"
+	// TracingExporterFile writes the spans to TracingConfiguration.File.
+	TracingExporterFile = "file"
+	// TracingExporterOTLP sends the spans to an OTLP/HTTP endpoint.
+	TracingExporterOTLP = "otlp"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// ControllerConfiguration is the configuration of the controller read from
+// the file of --config. The flags set on the command line override it.
+type ControllerConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	// Workers is the number of Foos reconciled in parallel. Defaults to 1.
+	// +optional
+	Workers int32 `json:"workers,omitempty"`
+	// Namespaces are the namespaces to watch. All the namespaces are
+	// watched if it's empty.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+	// FooSelector is the label selector of the Foos to watch.
+	// +optional
+	FooSelector string `json:"fooSelector,omitempty"`
+	// DeploymentSelector is the label selector of the Deployments to watch.
+	// +optional
+	DeploymentSelector string `json:"deploymentSelector,omitempty"`
+	// ResyncPeriod is the period to reconcile all the Foos again, which
+	// re-reconciles every Foo even if nothing has changed. The resync is
+	// disabled if it's 0. Defaults to 0.
+	// +optional
+	ResyncPeriod *metav1.Duration `json:"resyncPeriod,omitempty"`
+	// DryRun is "none" to make the changes, "server" to send the writes with
+	// DryRun: All or "client" to skip them. The changes are reported on the
+	// metrics endpoint, which must be enabled for a dry run. Defaults to
+	// "none".
+	// +optional
+	DryRun string `json:"dryRun,omitempty"`
+
+	// ClientConnection configures the connection to the API server.
+	// +optional
+	ClientConnection ClientConnectionConfiguration `json:"clientConnection"`
+	// Features are the feature toggles.
+	// +optional
+	Features FeaturesConfiguration `json:"features"`
+	// LeaderElection configures the election of the replica running the
+	// controller.
+	// +optional
+	LeaderElection LeaderElectionConfiguration `json:"leaderElection"`
+	// Queue configures the retries of the failing Foos.
+	// +optional
+	Queue QueueConfiguration `json:"queue"`
+	// Sharding configures the membership of the replicas when the Foos are
+	// sharded across them.
+	// +optional
+	Sharding ShardingConfiguration `json:"sharding"`
+	// Metrics configures the metrics endpoint.
+	// +optional
+	Metrics MetricsConfiguration `json:"metrics"`
+	// Logging configures the logs.
+	// +optional
+	Logging LoggingConfiguration `json:"logging"`
+	// Events configures the Events emitted for each Foo.
+	// +optional
+	Events EventsConfiguration `json:"events"`
+	// Tracing configures the tracing of the reconciles and API calls.
+	// +optional
+	Tracing TracingConfiguration `json:"tracing"`
+}
+
+// ClientConnectionConfiguration configures the connection to the API
+// server.
+type ClientConnectionConfiguration struct {
+	// QPS is the rate of the requests to the API server. Defaults to 20.
+	// +optional
+	QPS float64 `json:"qps,omitempty"`
+	// Burst is the number of the requests to the API server sent at once
+	// above the rate. Defaults to 30.
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+}
+
+// FeaturesConfiguration toggles the optional features of the controller.
+type FeaturesConfiguration struct {
+	// ManagedDeploymentsOnly only watches the Deployments with the label
+	// set by the controller.
+	// +optional
+	ManagedDeploymentsOnly bool `json:"managedDeploymentsOnly,omitempty"`
+	// Sharding shards the Foos across the replicas coordinated through
+	// Leases.
+	// +optional
+	Sharding bool `json:"sharding,omitempty"`
+}
+
+// LeaderElectionConfiguration configures the election of the replica running
+// the controller through a Lease, as the leader election of the Kubernetes
+// components.
+type LeaderElectionConfiguration struct {
+	// LeaderElect runs the controller only in the replica holding the
+	// Lease, while the other replicas stand by to take over. It can't be
+	// enabled with sharding.
+	// +optional
+	LeaderElect bool `json:"leaderElect,omitempty"`
+	// LeaseDuration is the duration the standby replicas wait after the
+	// last renewal of the Lease before taking it over. Defaults to 15s.
+	// +optional
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewDeadline is the duration the leader retries to renew the Lease
+	// before it stops leading, which must be shorter than LeaseDuration.
+	// Defaults to 10s.
+	// +optional
+	RenewDeadline metav1.Duration `json:"renewDeadline,omitempty"`
+	// RetryPeriod is the interval to try to acquire or renew the Lease.
+	// Defaults to 2s.
+	// +optional
+	RetryPeriod metav1.Duration `json:"retryPeriod,omitempty"`
+	// ResourceName is the name of the Lease. Defaults to
+	// "sample-controller".
+	// +optional
+	ResourceName string `json:"resourceName,omitempty"`
+	// ResourceNamespace is the namespace of the Lease. Defaults to
+	// "default".
+	// +optional
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+}
+
+// QueueConfiguration configures the rate limiter of the workqueue, which is
+// the max of a per-Foo exponential backoff and an overall token bucket.
+type QueueConfiguration struct {
+	// BaseDelay is the delay of the first retry of a Foo, which is doubled
+	// on every failure. Defaults to 5ms.
+	// +optional
+	BaseDelay metav1.Duration `json:"baseDelay,omitempty"`
+	// MaxDelay is the upper limit of the delay of the retries of a Foo.
+	// Defaults to 1000s.
+	// +optional
+	MaxDelay metav1.Duration `json:"maxDelay,omitempty"`
+	// QPS is the overall rate of retries of all the Foos. Defaults to 10.
+	// +optional
+	QPS float64 `json:"qps,omitempty"`
+	// Burst is the bucket size of the overall rate of retries. Defaults to
+	// 100.
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+	// MaxRetries is the number of retries of a failing Foo before it's
+	// dropped from the workqueue. The Foo is retried forever if it's 0.
+	// +optional
+	MaxRetries int32 `json:"maxRetries,omitempty"`
+}
+
+// ShardingConfiguration configures the membership of the shards.
+type ShardingConfiguration struct {
+	// Name is the unique name of this replica among the shards. Defaults to
+	// the hostname.
+	// +optional
+	Name string `json:"name,omitempty"`
+	// LeaseNamespace is the namespace of the Leases of the shards. Defaults
+	// to "default".
+	// +optional
+	LeaseNamespace string `json:"leaseNamespace,omitempty"`
+	// LeaseDuration is the duration after which a shard is considered gone
+	// if it doesn't renew its Lease. Defaults to 15s.
+	// +optional
+	LeaseDuration metav1.Duration `json:"leaseDuration,omitempty"`
+	// RenewInterval is the interval to renew the Lease and observe the
+	// shards, which must be shorter than LeaseDuration. Defaults to 5s.
+	// +optional
+	RenewInterval metav1.Duration `json:"renewInterval,omitempty"`
+	// HandoffDelay is the delay before processing the Foos moved from
+	// another shard. Defaults to 15s.
+	// +optional
+	HandoffDelay metav1.Duration `json:"handoffDelay,omitempty"`
+}
+
+// MetricsConfiguration configures the metrics endpoint.
+type MetricsConfiguration struct {
+	// BindAddress is the address to serve the metrics on. The metrics are
+	// disabled if it's "0". Defaults to ":8080".
+	// +optional
+	BindAddress string `json:"bindAddress,omitempty"`
+}
+
+// LoggingConfiguration configures the logs.
+type LoggingConfiguration struct {
+	// Format is "text" or "json". Defaults to "text".
+	// +optional
+	Format string `json:"format,omitempty"`
+	// SampleQPS is the rate of the reconciles of each Foo that are logged
+	// after SampleBurst reconciles. All the reconciles are logged if it's
+	// 0.
+	// +optional
+	SampleQPS float64 `json:"sampleQPS,omitempty"`
+	// SampleBurst is the number of the reconciles of each Foo that are
+	// logged at once. Defaults to 5.
+	// +optional
+	SampleBurst int32 `json:"sampleBurst,omitempty"`
+}
+
+// EventsConfiguration configures the Events emitted for each Foo.
+type EventsConfiguration struct {
+	// QPS is the rate of the Events of each Foo after Burst Events. The
+	// rate isn't limited if it's 0. Defaults to 0.1.
+	// +optional
+	QPS *float64 `json:"qps,omitempty"`
+	// Burst is the number of the Events of each Foo that are emitted at
+	// once. Defaults to 10.
+	// +optional
+	Burst int32 `json:"burst,omitempty"`
+	// AggregationWindow is the window in which the repeats of a warning
+	// Event of a Foo are counted instead of emitted. All the Events are
+	// emitted if it's 0. Defaults to 10m.
+	// +optional
+	AggregationWindow *metav1.Duration `json:"aggregationWindow,omitempty"`
+}
+
+// TracingConfiguration configures the tracing of the reconciles and API
+// calls.
+type TracingConfiguration struct {
+	// Exporter is "none", "stdout", "file" or "otlp". Defaults to "none".
+	// +optional
+	Exporter string `json:"exporter,omitempty"`
+	// File is the path of the file to write the spans to with the "file"
+	// exporter.
+	// +optional
+	File string `json:"file,omitempty"`
+	// OTLPEndpoint is the host:port of the OTLP/HTTP endpoint with the
+	// "otlp" exporter. The OTEL_EXPORTER_OTLP_* environment variables are
+	// used if it's empty.
+	// +optional
+	OTLPEndpoint string `json:"otlpEndpoint,omitempty"`
+	// OTLPInsecure disables TLS to the OTLP/HTTP endpoint.
+	// +optional
+	OTLPInsecure bool `json:"otlpInsecure,omitempty"`
+	// SampleRatio is the ratio of the reconciles that are traced. Defaults
+	// to 1.
+	// +optional
+	SampleRatio *float64 `json:"sampleRatio,omitempty"`
+}
@@ -0,0 +1,155 @@
+package v1alpha1
+
+import (
+	"fmt"
+
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// leaderElectionJitterFactor is leaderelection.JitterFactor of client-go,
+// which isn't imported to keep the clients out of the configuration.
+const leaderElectionJitterFactor = 1.2
+
+var (
+	dryRunModes      = sets.New(DryRunNone, DryRunServer, DryRunClient)
+	logFormats       = sets.New(LogFormatText, LogFormatJSON)
+	tracingExporters = sets.New(TracingExporterNone, TracingExporterStdout, TracingExporterFile, TracingExporterOTLP)
+)
+
+// Validate returns the errors of the defaulted configuration.
+func Validate(obj *ControllerConfiguration) field.ErrorList {
+	var errs field.ErrorList
+	if obj.Workers < 1 {
+		errs = append(errs, field.Invalid(field.NewPath("workers"), obj.Workers, "must be at least 1"))
+	}
+	for i, namespace := range obj.Namespaces {
+		for _, msg := range validation.IsDNS1123Label(namespace) {
+			errs = append(errs, field.Invalid(field.NewPath("namespaces").Index(i), namespace, msg))
+		}
+	}
+	for _, selector := range []struct {
+		path  *field.Path
+		value string
+	}{
+		{path: field.NewPath("fooSelector"), value: obj.FooSelector},
+		{path: field.NewPath("deploymentSelector"), value: obj.DeploymentSelector},
+	} {
+		if _, err := labels.Parse(selector.value); err != nil {
+			errs = append(errs, field.Invalid(selector.path, selector.value, err.Error()))
+		}
+	}
+	if obj.ResyncPeriod != nil && obj.ResyncPeriod.Duration < 0 {
+		errs = append(errs, field.Invalid(field.NewPath("resyncPeriod"), obj.ResyncPeriod.Duration.String(), "must not be negative"))
+	}
+	if !dryRunModes.Has(obj.DryRun) {
+		errs = append(errs, field.NotSupported(field.NewPath("dryRun"), obj.DryRun, sets.List(dryRunModes)))
+	}
+	if obj.DryRun != DryRunNone && obj.Metrics.BindAddress == "0" {
+		errs = append(errs, field.Invalid(field.NewPath("dryRun"), obj.DryRun, "requires metrics.bindAddress to serve the pending changes"))
+	}
+
+	clientConnection := field.NewPath("clientConnection")
+	if obj.ClientConnection.QPS <= 0 {
+		errs = append(errs, field.Invalid(clientConnection.Child("qps"), obj.ClientConnection.QPS, "must be positive"))
+	}
+	if obj.ClientConnection.Burst < 1 {
+		errs = append(errs, field.Invalid(clientConnection.Child("burst"), obj.ClientConnection.Burst, "must be at least 1"))
+	}
+
+	if obj.LeaderElection.LeaderElect {
+		leaderElection := field.NewPath("leaderElection")
+		if obj.Features.Sharding {
+			errs = append(errs, field.Invalid(leaderElection.Child("leaderElect"), true, "can't be enabled with features.sharding"))
+		}
+		if obj.LeaderElection.RetryPeriod.Duration <= 0 {
+			errs = append(errs, field.Invalid(leaderElection.Child("retryPeriod"), obj.LeaderElection.RetryPeriod.Duration.String(), "must be positive"))
+		}
+		// The leader retries to renew the Lease with a jitter of the retry
+		// period before the deadline.
+		if float64(obj.LeaderElection.RenewDeadline.Duration) <= leaderElectionJitterFactor*float64(obj.LeaderElection.RetryPeriod.Duration) {
+			errs = append(errs, field.Invalid(leaderElection.Child("renewDeadline"), obj.LeaderElection.RenewDeadline.Duration.String(), fmt.Sprintf("must be longer than %g times retryPeriod", leaderElectionJitterFactor)))
+		}
+		if obj.LeaderElection.LeaseDuration.Duration <= obj.LeaderElection.RenewDeadline.Duration {
+			errs = append(errs, field.Invalid(leaderElection.Child("leaseDuration"), obj.LeaderElection.LeaseDuration.Duration.String(), "must be longer than renewDeadline"))
+		}
+		for _, msg := range validation.IsDNS1123Subdomain(obj.LeaderElection.ResourceName) {
+			errs = append(errs, field.Invalid(leaderElection.Child("resourceName"), obj.LeaderElection.ResourceName, msg))
+		}
+		for _, msg := range validation.IsDNS1123Label(obj.LeaderElection.ResourceNamespace) {
+			errs = append(errs, field.Invalid(leaderElection.Child("resourceNamespace"), obj.LeaderElection.ResourceNamespace, msg))
+		}
+	}
+
+	queue := field.NewPath("queue")
+	if obj.Queue.BaseDelay.Duration <= 0 {
+		errs = append(errs, field.Invalid(queue.Child("baseDelay"), obj.Queue.BaseDelay.Duration.String(), "must be positive"))
+	}
+	if obj.Queue.MaxDelay.Duration < obj.Queue.BaseDelay.Duration {
+		errs = append(errs, field.Invalid(queue.Child("maxDelay"), obj.Queue.MaxDelay.Duration.String(), "must not be shorter than baseDelay"))
+	}
+	if obj.Queue.QPS <= 0 {
+		errs = append(errs, field.Invalid(queue.Child("qps"), obj.Queue.QPS, "must be positive"))
+	}
+	if obj.Queue.Burst < 1 {
+		errs = append(errs, field.Invalid(queue.Child("burst"), obj.Queue.Burst, "must be at least 1"))
+	}
+	if obj.Queue.MaxRetries < 0 {
+		errs = append(errs, field.Invalid(queue.Child("maxRetries"), obj.Queue.MaxRetries, "must not be negative"))
+	}
+
+	if obj.Features.Sharding {
+		sharding := field.NewPath("sharding")
+		if obj.Sharding.Name == "" {
+			errs = append(errs, field.Required(sharding.Child("name"), "must be specified to enable sharding"))
+		}
+		for _, msg := range validation.IsDNS1123Label(obj.Sharding.LeaseNamespace) {
+			errs = append(errs, field.Invalid(sharding.Child("leaseNamespace"), obj.Sharding.LeaseNamespace, msg))
+		}
+		if obj.Sharding.RenewInterval.Duration <= 0 {
+			errs = append(errs, field.Invalid(sharding.Child("renewInterval"), obj.Sharding.RenewInterval.Duration.String(), "must be positive"))
+		}
+		if obj.Sharding.RenewInterval.Duration >= obj.Sharding.LeaseDuration.Duration {
+			errs = append(errs, field.Invalid(sharding.Child("renewInterval"), obj.Sharding.RenewInterval.Duration.String(), "must be shorter than leaseDuration"))
+		}
+		if obj.Sharding.HandoffDelay.Duration < obj.Sharding.RenewInterval.Duration {
+			errs = append(errs, field.Invalid(sharding.Child("handoffDelay"), obj.Sharding.HandoffDelay.Duration.String(), "must not be shorter than renewInterval"))
+		}
+	}
+
+	logging := field.NewPath("logging")
+	if !logFormats.Has(obj.Logging.Format) {
+		errs = append(errs, field.NotSupported(logging.Child("format"), obj.Logging.Format, sets.List(logFormats)))
+	}
+	if obj.Logging.SampleQPS < 0 {
+		errs = append(errs, field.Invalid(logging.Child("sampleQPS"), obj.Logging.SampleQPS, "must not be negative"))
+	}
+	if obj.Logging.SampleBurst < 1 {
+		errs = append(errs, field.Invalid(logging.Child("sampleBurst"), obj.Logging.SampleBurst, "must be at least 1"))
+	}
+
+	events := field.NewPath("events")
+	if obj.Events.QPS != nil && *obj.Events.QPS < 0 {
+		errs = append(errs, field.Invalid(events.Child("qps"), *obj.Events.QPS, "must not be negative"))
+	}
+	if obj.Events.Burst < 1 {
+		errs = append(errs, field.Invalid(events.Child("burst"), obj.Events.Burst, "must be at least 1"))
+	}
+	if obj.Events.AggregationWindow != nil && obj.Events.AggregationWindow.Duration < 0 {
+		errs = append(errs, field.Invalid(events.Child("aggregationWindow"), obj.Events.AggregationWindow.Duration.String(), "must not be negative"))
+	}
+
+	tracing := field.NewPath("tracing")
+	if !tracingExporters.Has(obj.Tracing.Exporter) {
+		errs = append(errs, field.NotSupported(tracing.Child("exporter"), obj.Tracing.Exporter, sets.List(tracingExporters)))
+	}
+	if obj.Tracing.Exporter == TracingExporterFile && obj.Tracing.File == "" {
+		errs = append(errs, field.Required(tracing.Child("file"), "must be specified with the file exporter"))
+	}
+	if ratio := obj.Tracing.SampleRatio; ratio != nil && (*ratio < 0 || *ratio > 1) {
+		errs = append(errs, field.Invalid(tracing.Child("sampleRatio"), *ratio, "must be between 0 and 1"))
+	}
+	return errs
+}
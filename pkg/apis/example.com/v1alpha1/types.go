@@ -1,6 +1,11 @@
 package v1alpha1
 
-import metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+)
 
 // +genclient
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -18,11 +23,192 @@ type Foo struct {
 type FooSpec struct {
 	DeploymentName string `json:"deploymentName"`
 	Replicas       *int32 `json:"replicas"`
+
+	// DisruptionBudget makes the controller manage a PodDisruptionBudget
+	// for the pods of the Deployment. No PodDisruptionBudget is created
+	// when it's not specified.
+	// +optional
+	DisruptionBudget *FooDisruptionBudget `json:"disruptionBudget,omitempty"`
+
+	// Autoscaling makes the controller manage a HorizontalPodAutoscaler for
+	// the Deployment. Replicas is ignored while autoscaling is specified.
+	// The resources must request the CPU or memory the utilization of
+	// which is targeted.
+	// +optional
+	Autoscaling *FooAutoscaling `json:"autoscaling,omitempty"`
+
+	// Resources is the compute resources of the container of the pods.
+	// The resources of the Deployment are left unchanged when it's not
+	// specified.
+	// +optional
+	Resources *corev1.ResourceRequirements `json:"resources,omitempty"`
+
+	// Strategy is the strategy used to replace the pods of the Deployment.
+	// The default of Deployment is used when it's not specified.
+	// +optional
+	Strategy *appsv1.DeploymentStrategy `json:"strategy,omitempty"`
+
+	// Rollout controls the progress of rollouts of the Deployment.
+	// +optional
+	Rollout *FooRollout `json:"rollout,omitempty"`
+
+	// Canary makes the controller run a canary Deployment alongside the
+	// stable one. The canary Deployment is deleted when it's removed.
+	// +optional
+	Canary *FooCanary `json:"canary,omitempty"`
+
+	// Health is the thresholds of the health of the pods, over which the Foo
+	// is Degraded.
+	// +optional
+	Health *FooHealth `json:"health,omitempty"`
+}
+
+// FooDisruptionBudget is the disruption budget for the pods of a Foo.
+// Only one of MinAvailable and MaxUnavailable can be specified.
+type FooDisruptionBudget struct {
+	// MinAvailable is the number or percentage of pods that must still be
+	// available after an eviction.
+	// +optional
+	MinAvailable *intstr.IntOrString `json:"minAvailable,omitempty"`
+	// MaxUnavailable is the number or percentage of pods that can be
+	// unavailable after an eviction.
+	// +optional
+	MaxUnavailable *intstr.IntOrString `json:"maxUnavailable,omitempty"`
+}
+
+// FooAutoscaling is the autoscaling policy of a Foo.
+type FooAutoscaling struct {
+	// MinReplicas is the lower limit for the number of replicas. Defaults to 1.
+	// +optional
+	MinReplicas *int32 `json:"minReplicas,omitempty"`
+	// MaxReplicas is the upper limit for the number of replicas.
+	MaxReplicas int32 `json:"maxReplicas"`
+	// TargetCPUUtilizationPercentage is the target average CPU utilization
+	// over all the pods. Defaults to 80 if no target is specified.
+	// +optional
+	TargetCPUUtilizationPercentage *int32 `json:"targetCPUUtilizationPercentage,omitempty"`
+	// TargetMemoryUtilizationPercentage is the target average memory
+	// utilization over all the pods.
+	// +optional
+	TargetMemoryUtilizationPercentage *int32 `json:"targetMemoryUtilizationPercentage,omitempty"`
+}
+
+// FooRollout controls the progress of rollouts of a Foo.
+type FooRollout struct {
+	// PauseAt is the percentage of updated replicas at which a rollout is
+	// paused. The rollout resumes when it's raised or removed.
+	// +optional
+	PauseAt *int32 `json:"pauseAt,omitempty"`
+}
+
+// FooCanary is the canary of a Foo, which runs the pod template of the
+// stable Deployment with the overrides in a separate Deployment. Only one of
+// Replicas and Weight can be specified.
+type FooCanary struct {
+	// Template is the overrides of the pod template for the canary.
+	Template FooCanaryTemplate `json:"template"`
+	// Replicas is the number of replicas of the canary Deployment.
+	// +optional
+	Replicas *int32 `json:"replicas,omitempty"`
+	// Weight is the number of replicas of the canary Deployment as a
+	// percentage of the replicas of the stable Deployment, rounded up.
+	// +optional
+	Weight *int32 `json:"weight,omitempty"`
+}
+
+// FooCanaryTemplate is the overrides applied to the first container of the
+// pod template of the stable Deployment.
+type FooCanaryTemplate struct {
+	// Image overrides the image of the container.
+	// +optional
+	Image string `json:"image,omitempty"`
+	// Env is added to the environment variables of the container, replacing
+	// the ones with the same name.
+	// +optional
+	Env []corev1.EnvVar `json:"env,omitempty"`
+}
+
+// FooHealth is the thresholds of the health of the pods of a Foo.
+type FooHealth struct {
+	// MaxRestarts is the total number of container restarts of the pods
+	// tolerated. Restarts are not taken into account when it's not specified.
+	// +optional
+	MaxRestarts *int32 `json:"maxRestarts,omitempty"`
+	// MaxUnhealthyPods is the number of pods in CrashLoopBackOff or failing
+	// to pull images tolerated. Defaults to 0.
+	// +optional
+	MaxUnhealthyPods *int32 `json:"maxUnhealthyPods,omitempty"`
+	// AutoPause pauses the rollout of the Deployment while the Foo is
+	// Degraded.
+	// +optional
+	AutoPause bool `json:"autoPause,omitempty"`
 }
 
 // FooStatus is the status for a Foo resource
 type FooStatus struct {
 	AvailableReplicas int32 `json:"availableReplicas"`
+
+	// UpdatedReplicas is the number of replicas running the pod template of
+	// the current rollout.
+	// +optional
+	UpdatedReplicas int32 `json:"updatedReplicas,omitempty"`
+
+	// ObservedGeneration is the generation of the Foo observed by the
+	// controller.
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// TemplateHash is the hash of the pod template of the current rollout.
+	// +optional
+	TemplateHash string `json:"templateHash,omitempty"`
+
+	// PreviousTemplateHash is the hash of the pod template of the previous
+	// rollout, which is restored by the rollback annotation.
+	// +optional
+	PreviousTemplateHash string `json:"previousTemplateHash,omitempty"`
+
+	// RolledBackGeneration is the generation of the Foo when the Deployment
+	// was last rolled back. The resources of the Foo aren't set on the
+	// restored pod template until the spec of the Foo changes.
+	// +optional
+	RolledBackGeneration int64 `json:"rolledBackGeneration,omitempty"`
+
+	// Conditions are the latest observations of the Foo's state.
+	// +optional
+	Conditions []metav1.Condition `json:"conditions,omitempty"`
+
+	// Stable is the status of the stable Deployment.
+	// +optional
+	Stable *FooTrackStatus `json:"stable,omitempty"`
+
+	// Canary is the status of the canary Deployment.
+	// +optional
+	Canary *FooTrackStatus `json:"canary,omitempty"`
+
+	// Health is the health of the pods of the Foo.
+	// +optional
+	Health *FooHealthStatus `json:"health,omitempty"`
+}
+
+// FooHealthStatus is the health of the pods of a Foo aggregated over the
+// stable and canary Deployments.
+type FooHealthStatus struct {
+	// Restarts is the total number of container restarts of the pods.
+	Restarts int32 `json:"restarts"`
+	// CrashLoopBackOffPods is the number of pods with a container in
+	// CrashLoopBackOff.
+	CrashLoopBackOffPods int32 `json:"crashLoopBackOffPods"`
+	// ImagePullErrorPods is the number of pods with a container failing to
+	// pull its image.
+	ImagePullErrorPods int32 `json:"imagePullErrorPods"`
+}
+
+// FooTrackStatus is the status of one of the Deployments of a Foo.
+type FooTrackStatus struct {
+	DeploymentName    string `json:"deploymentName"`
+	Replicas          int32  `json:"replicas"`
+	UpdatedReplicas   int32  `json:"updatedReplicas"`
+	AvailableReplicas int32  `json:"availableReplicas"`
 }
 
 // +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
@@ -0,0 +1,287 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// CanaryAnnotation is the annotation on a Foo to promote or abort the
+	// canary. The controller removes it once the operation is processed.
+	CanaryAnnotation = "example.com/canary"
+	// CanaryPromote is the value of CanaryAnnotation to apply the overrides
+	// of the canary to the stable Deployment and remove the canary.
+	CanaryPromote = "promote"
+	// CanaryAbort is the value of CanaryAnnotation to remove the canary
+	// without changing the stable Deployment.
+	CanaryAbort = "abort"
+
+	// trackLabel distinguishes the pods of the canary Deployment from the
+	// ones of the stable Deployment, so neither Deployment selects the pods
+	// of the other. The pods of both Deployments have the labels from
+	// deploymentLabels, which Services should select.
+	trackLabel  = "track"
+	trackStable = "stable"
+	trackCanary = "canary"
+
+	// CanaryPromoted is used as part of the Event 'reason' when the canary of
+	// a Foo is promoted
+	CanaryPromoted = "CanaryPromoted"
+	// CanaryAborted is used as part of the Event 'reason' when the canary of
+	// a Foo is aborted
+	CanaryAborted = "CanaryAborted"
+	// CanaryOperationFailed is used as part of the Event 'reason' when the
+	// canary annotation of a Foo can't be processed
+	CanaryOperationFailed = "CanaryOperationFailed"
+	// CanarySkipped is used as part of the Event 'reason' when the canary
+	// Deployment of a Foo is not created because the stable Deployment
+	// would select its pods.
+	CanarySkipped = "CanarySkipped"
+
+	// MessageCanaryPromoted is the message used for an Event fired when the
+	// canary of a Foo is promoted
+	MessageCanaryPromoted = "Canary is promoted to Deployment %q"
+	// MessageCanaryAborted is the message used for an Event fired when the
+	// canary of a Foo is aborted
+	MessageCanaryAborted = "Canary Deployment %q is aborted"
+	// MessageCanaryOperationFailed is the message used for an Event fired
+	// when the canary annotation of a Foo can't be processed
+	MessageCanaryOperationFailed = "Canary operation %q is not processed: %s"
+	// MessageCanarySkipped is the message used for an Event fired when the
+	// canary of a Foo is skipped
+	MessageCanarySkipped = "Canary Deployment %q is skipped as the selector of Deployment %q selects its pods"
+)
+
+// processCanaryOperation promotes or aborts the canary of the Foo as
+// requested by the canary annotation. spec.canary and the annotation are
+// removed from the Foo, so the updated Foo and stable Deployment are
+// returned.
+func (c *Controller) processCanaryOperation(ctx context.Context, foo *samplev1alpha1.Foo, deployment *appsv1.Deployment, operation string) (*samplev1alpha1.Foo, *appsv1.Deployment, error) {
+	ctx, span := c.tracer.Start(ctx, "processCanaryOperation")
+	defer span.End()
+	fooCopy := foo.DeepCopy()
+	delete(fooCopy.Annotations, CanaryAnnotation)
+
+	switch {
+	case operation != CanaryPromote && operation != CanaryAbort:
+		c.eventf(ctx, foo, corev1.EventTypeWarning, CanaryOperationFailed, MessageCanaryOperationFailed, operation, "unknown operation")
+	case foo.Spec.Canary == nil:
+		c.eventf(ctx, foo, corev1.EventTypeWarning, CanaryOperationFailed, MessageCanaryOperationFailed, operation, "no canary is specified")
+	default:
+		if operation == CanaryPromote {
+			deploymentCopy := deployment.DeepCopy()
+			applyCanaryTemplate(&deploymentCopy.Spec.Template, &foo.Spec.Canary.Template)
+			var err error
+			deployment, err = c.kubeclientset.AppsV1().Deployments(foo.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{})
+			if err != nil {
+				return nil, nil, err
+			}
+			c.eventf(ctx, foo, corev1.EventTypeNormal, CanaryPromoted, MessageCanaryPromoted, deployment.Name)
+		} else {
+			c.eventf(ctx, foo, corev1.EventTypeNormal, CanaryAborted, MessageCanaryAborted, canaryDeploymentName(foo))
+		}
+		klog.FromContext(ctx).Info("Processed canary operation", "operation", operation)
+		// The canary Deployment is deleted by syncCanary as spec.canary is
+		// removed.
+		fooCopy.Spec.Canary = nil
+	}
+
+	foo, err := c.sampleclientset.ExampleV1alpha1().Foos(foo.Namespace).Update(ctx, fooCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	return foo, deployment, nil
+}
+
+// syncCanary makes the canary Deployment owned by the Foo match spec.canary
+// and the stable Deployment. The canary Deployment is deleted when the canary
+// is removed from the Foo, or when the selector of the stable Deployment,
+// which is immutable, selects the canary pods as the ones created before the
+// track label. nil is returned when there's no canary.
+func (c *Controller) syncCanary(ctx context.Context, foo *samplev1alpha1.Foo, stable *appsv1.Deployment) (*appsv1.Deployment, error) {
+	ctx, span := c.tracer.Start(ctx, "syncCanary")
+	defer span.End()
+	logger := klog.FromContext(ctx)
+	canary, err := c.deploymentsLister.Deployments(foo.Namespace).Get(canaryDeploymentName(foo))
+	if err != nil && !errors.IsNotFound(err) {
+		return nil, err
+	}
+	if errors.IsNotFound(err) {
+		canary = nil
+	}
+
+	spec := foo.Spec.Canary
+	if spec != nil && selectsCanary(foo, stable) {
+		c.eventf(ctx, foo, corev1.EventTypeWarning, CanarySkipped, MessageCanarySkipped, canaryDeploymentName(foo), stable.Name)
+		logger.Info("Skipping canary Deployment selected by the stable Deployment", "deployment", klog.KRef(foo.Namespace, canaryDeploymentName(foo)))
+		spec = nil
+	}
+
+	if spec == nil {
+		if canary == nil || !metav1.IsControlledBy(canary, foo) {
+			return nil, nil
+		}
+		logger.Info("Deleting canary Deployment", "deployment", klog.KObj(canary))
+		err = c.kubeclientset.AppsV1().Deployments(canary.Namespace).Delete(ctx, canary.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	desired := newCanaryDeployment(foo, stable)
+	if canary == nil {
+		canary, err = c.createDeployment(ctx, foo, desired)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if isOrphanManagedDeployment(canary) {
+		canary, err = c.adoptDeployment(ctx, foo, canary)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// If the canary Deployment is not controlled by this Foo resource, we
+	// should log a warning to the event recorder and return error msg.
+	if !metav1.IsControlledBy(canary, foo) {
+		msg := fmt.Sprintf(MessageResourceExists, canary.Name)
+		c.event(ctx, foo, corev1.EventTypeWarning, ErrResourceExists, msg)
+		logger.Info("Canary Deployment already exists and is not managed by Foo", "deployment", klog.KObj(canary))
+		return nil, fmt.Errorf("%s", msg)
+	}
+
+	if equality.Semantic.DeepEqual(canary.Spec.Replicas, desired.Spec.Replicas) &&
+		equality.Semantic.DeepEqual(canary.Spec.Template, desired.Spec.Template) &&
+		canary.Labels[managedByLabel] == controllerAgentName {
+		return canary, nil
+	}
+	// NEVER modify objects from the store.
+	canaryCopy := canary.DeepCopy()
+	canaryCopy.Labels = managedLabels(canary.Labels)
+	canaryCopy.Spec.Replicas = desired.Spec.Replicas
+	canaryCopy.Spec.Template = desired.Spec.Template
+	canary, err = c.kubeclientset.AppsV1().Deployments(foo.Namespace).Update(ctx, canaryCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	c.recordUpdate(ctx, foo, "Deployment", canary.Name)
+	return canary, nil
+}
+
+func canaryDeploymentName(foo *samplev1alpha1.Foo) string {
+	return foo.Spec.DeploymentName + "-canary"
+}
+
+// stableLabels returns the labels of the pods of the stable Deployment,
+// which are also used as its selector.
+func stableLabels(foo *samplev1alpha1.Foo) map[string]string {
+	labels := deploymentLabels(foo)
+	labels[trackLabel] = trackStable
+	return labels
+}
+
+// canaryLabels returns the labels of the pods of the canary Deployment,
+// which are also used as its selector.
+func canaryLabels(foo *samplev1alpha1.Foo) map[string]string {
+	labels := deploymentLabels(foo)
+	labels[trackLabel] = trackCanary
+	return labels
+}
+
+// selectsCanary returns true if the selector of the stable Deployment selects
+// the pods of the canary Deployment of the Foo.
+func selectsCanary(foo *samplev1alpha1.Foo, stable *appsv1.Deployment) bool {
+	selector, err := metav1.LabelSelectorAsSelector(stable.Spec.Selector)
+	if err != nil {
+		return true
+	}
+	return selector.Matches(labels.Set(canaryLabels(foo)))
+}
+
+// getCanaryReplicas returns the number of replicas of the canary Deployment
+// from spec.canary of the Foo and the replicas of the stable Deployment.
+func getCanaryReplicas(canary *samplev1alpha1.FooCanary, stable *appsv1.Deployment) int32 {
+	switch {
+	case canary.Replicas != nil:
+		return *canary.Replicas
+	case canary.Weight != nil:
+		return (getDeploymentReplicas(stable)**canary.Weight + 99) / 100
+	default:
+		return 1
+	}
+}
+
+// newCanaryDeployment returns the canary Deployment, which runs the pod
+// template of the stable Deployment with the overrides in spec.canary.
+func newCanaryDeployment(foo *samplev1alpha1.Foo, stable *appsv1.Deployment) *appsv1.Deployment {
+	labels := canaryLabels(foo)
+	replicas := getCanaryReplicas(foo.Spec.Canary, stable)
+
+	template := stable.Spec.Template.DeepCopy()
+	template.Labels = labels
+	applyCanaryTemplate(template, &foo.Spec.Canary.Template)
+
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            canaryDeploymentName(foo),
+			Namespace:       foo.Namespace,
+			Labels:          managedLabels(labels),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: *template,
+		},
+	}
+}
+
+// applyCanaryTemplate applies the overrides of the canary to the first
+// container of the pod template.
+func applyCanaryTemplate(template *corev1.PodTemplateSpec, overrides *samplev1alpha1.FooCanaryTemplate) {
+	if len(template.Spec.Containers) == 0 {
+		return
+	}
+	container := &template.Spec.Containers[0]
+	if overrides.Image != "" {
+		container.Image = overrides.Image
+	}
+	for _, env := range overrides.Env {
+		replaced := false
+		for i := range container.Env {
+			if container.Env[i].Name == env.Name {
+				container.Env[i] = env
+				replaced = true
+				break
+			}
+		}
+		if !replaced {
+			container.Env = append(container.Env, env)
+		}
+	}
+}
+
+// newTrackStatus returns the status of the Deployment for the status of the
+// Foo.
+func newTrackStatus(deployment *appsv1.Deployment) *samplev1alpha1.FooTrackStatus {
+	return &samplev1alpha1.FooTrackStatus{
+		DeploymentName:    deployment.Name,
+		Replicas:          deployment.Status.Replicas,
+		UpdatedReplicas:   deployment.Status.UpdatedReplicas,
+		AvailableReplicas: deployment.Status.AvailableReplicas,
+	}
+}
@@ -0,0 +1,945 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync/atomic"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/scheme"
+	listers "github.com/nakamasato/sample-controller/pkg/generated/listers/example.com/v1alpha1"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/uuid"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	appslisters "k8s.io/client-go/listers/apps/v1"
+	autoscalinglisters "k8s.io/client-go/listers/autoscaling/v2"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	policylisters "k8s.io/client-go/listers/policy/v1"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const controllerAgentName = "sample-controller"
+
+// UserAgent returns the user agent of the requests of the controller of the
+// version, such as "sample-controller/v0.1.0 (linux/amd64)", which identifies
+// the controller in the audit logs of the API server.
+func UserAgent(version string) string {
+	return fmt.Sprintf("%s/%s (%s/%s)", controllerAgentName, version, runtime.GOOS, runtime.GOARCH)
+}
+
+// controllerLabel is the label set on the pods of a Foo with the name of the
+// Foo.
+const controllerLabel = "controller"
+
+// managedByLabel is the label set on the Deployments created by the
+// controller, so the Deployment informer can only cache them.
+const managedByLabel = "app.kubernetes.io/managed-by"
+
+// ManagedDeploymentSelector is the label selector of the Deployments created
+// by the controller.
+const ManagedDeploymentSelector = managedByLabel + "=" + controllerAgentName
+
+const (
+	// ConditionTypeReconcileFailed is the condition type of a Foo which is set
+	// when the Foo is dropped from the workqueue, and removed once the Foo is
+	// synced successfully.
+	ConditionTypeReconcileFailed = "ReconcileFailed"
+
+	// ConditionTypeReady is the condition type of a Foo which is true when
+	// the rollout of the Deployment is complete, all the replicas are
+	// available and the Foo isn't Degraded.
+	ConditionTypeReady = "Ready"
+
+	// Reasons of the Ready condition.
+	reasonReplicasAvailable   = "ReplicasAvailable"
+	reasonReplicasUnavailable = "ReplicasUnavailable"
+	reasonDegraded            = "Degraded"
+)
+
+// ControllerOptions configures the Controller.
+type ControllerOptions struct {
+	// RateLimiter is the rate limiter of the workqueue. Defaults to
+	// workqueue.DefaultControllerRateLimiter().
+	RateLimiter workqueue.RateLimiter
+	// MaxRetries is the number of retries of a failing item before it's
+	// dropped from the workqueue. The item is retried forever if it's 0.
+	MaxRetries int
+	// Workers is the number of Foos reconciled in parallel. Defaults to 1.
+	Workers int
+	// Sharder tells which Foos belong to this replica when the Foos are
+	// sharded across replicas. All the Foos are processed if it's nil.
+	Sharder Sharder
+	// LogSampleQPS is the rate of the reconciles of each Foo that are
+	// logged after LogSampleBurst reconciles. The errors are always logged.
+	// All the reconciles are logged if it's 0.
+	LogSampleQPS   float64
+	LogSampleBurst int
+	// TracerProvider provides the tracer of the reconciles. Tracing is
+	// disabled if it's nil.
+	TracerProvider trace.TracerProvider
+	// Events configures the policy of the Events of each Foo. The zero
+	// value only drops the Normal Events identical to the last one.
+	Events EventPolicyOptions
+	// Recorder records the Events. The Events are recorded to the API
+	// server through kubeclientset if it's nil.
+	Recorder record.EventRecorder
+	// Clock is the clock of the workqueue and the event policy. Defaults to
+	// the real clock.
+	Clock clock.WithTicker
+	// DryRun is DryRunServer to send the writes with DryRun: All, or
+	// DryRunClient to skip them. The changes of the writes are logged and
+	// reported by PendingChanges instead of made, and the Events are
+	// logged instead of emitted. The writes are made if it's empty or
+	// DryRunNone.
+	DryRun string
+}
+
+type Controller struct {
+	// kubeclientset is a standard kubernetes clientset
+	kubeclientset kubernetes.Interface
+	// sampleclientset is a clientset for our own API group
+	sampleclientset clientset.Interface
+
+	deploymentsLister appslisters.DeploymentLister
+	deploymentsSynced cache.InformerSynced
+
+	pdbsLister policylisters.PodDisruptionBudgetLister
+	pdbsSynced cache.InformerSynced
+
+	hpasLister autoscalinglisters.HorizontalPodAutoscalerLister
+	hpasSynced cache.InformerSynced
+
+	// podsLister only caches the pods with the controller label.
+	podsLister corelisters.PodLister
+	podsSynced cache.InformerSynced
+
+	foosLister listers.FooLister
+	foosSynced cache.InformerSynced // cache is synced for foo
+
+	// queue
+	workqueue *fooQueue
+	// workers is the number of workers processing the workqueue.
+	workers int
+	// maxRetries is the retry budget of an item of the workqueue. It's
+	// replaced by Reload.
+	maxRetries atomic.Int64
+	// sharder tells which Foos belong to this replica. nil means all.
+	sharder Sharder
+
+	// logger is the logger of the event handlers, which have no context.
+	logger klog.Logger
+	// logSampler limits the logs of the reconciles of each Foo.
+	logSampler *logSampler
+	// tracer creates the spans of the enqueues and reconciles.
+	tracer trace.Tracer
+
+	// events records Event resources to the Kubernetes API through the
+	// event policy.
+	events *eventPolicy
+	// plan records the changes of the reconciles instead of making them in
+	// a dry run. nil means the changes are made.
+	plan *dryRunPlan
+}
+
+// NewController returns a new Controller watching the informers, which are
+// either for all the namespaces or for one namespace each. The logger of the
+// context is used for the logs of the Controller.
+func NewController(
+	ctx context.Context,
+	kubeclientset kubernetes.Interface,
+	sampleclientset clientset.Interface,
+	informerSets []Informers,
+	opts ControllerOptions) *Controller {
+
+	var deploymentInformers, pdbInformers, hpaInformers, podInformers, fooInformers []cache.SharedIndexInformer
+	for _, informerSet := range informerSets {
+		deploymentInformers = append(deploymentInformers, informerSet.Deployments.Informer())
+		pdbInformers = append(pdbInformers, informerSet.PodDisruptionBudgets.Informer())
+		hpaInformers = append(hpaInformers, informerSet.HorizontalPodAutoscalers.Informer())
+		podInformers = append(podInformers, informerSet.Pods.Informer())
+		fooInformers = append(fooInformers, informerSet.Foos.Informer())
+	}
+
+	logger := klog.FromContext(ctx)
+	recorder := opts.Recorder
+	if recorder == nil {
+		eventBroadcaster := record.NewBroadcaster()
+		eventBroadcaster.StartStructuredLogging(logLevelDebug)
+		eventBroadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: kubeclientset.CoreV1().Events("")})
+		recorder = eventBroadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: controllerAgentName})
+	}
+	rateLimiter := opts.RateLimiter
+	if rateLimiter == nil {
+		rateLimiter = workqueue.DefaultControllerRateLimiter()
+	}
+	clk := opts.Clock
+	if clk == nil {
+		clk = clock.RealClock{}
+	}
+	tracerProvider := opts.TracerProvider
+	if tracerProvider == nil {
+		tracerProvider = noop.NewTracerProvider()
+	}
+	// In a dry run, the clientsets record the writes of the Foos and their
+	// resources instead of making them. The other requests pass through.
+	var plan *dryRunPlan
+	if opts.DryRun != "" && opts.DryRun != DryRunNone {
+		plan = newDryRunPlan(opts.DryRun, clk)
+		kubeclientset = &dryRunKubeClient{Interface: kubeclientset, plan: plan}
+		sampleclientset = &dryRunSampleClient{Interface: sampleclientset, plan: plan}
+	}
+	controller := &Controller{
+		kubeclientset:     kubeclientset,
+		sampleclientset:   sampleclientset,
+		deploymentsLister: appslisters.NewDeploymentLister(mergeIndexers(deploymentInformers)),
+		deploymentsSynced: mergeHasSynced(deploymentInformers),
+		pdbsLister:        policylisters.NewPodDisruptionBudgetLister(mergeIndexers(pdbInformers)),
+		pdbsSynced:        mergeHasSynced(pdbInformers),
+		hpasLister:        autoscalinglisters.NewHorizontalPodAutoscalerLister(mergeIndexers(hpaInformers)),
+		hpasSynced:        mergeHasSynced(hpaInformers),
+		podsLister:        corelisters.NewPodLister(mergeIndexers(podInformers)),
+		podsSynced:        mergeHasSynced(podInformers),
+		foosLister:        listers.NewFooLister(mergeIndexers(fooInformers)),
+		foosSynced:        mergeHasSynced(fooInformers),
+		workqueue:         newFooQueue(rateLimiter, clk),
+		workers:           opts.Workers,
+		sharder:           opts.Sharder,
+		logger:            logger,
+		logSampler:        newLogSampler(opts.LogSampleQPS, opts.LogSampleBurst),
+		tracer:            tracerProvider.Tracer(tracerName),
+		events:            newEventPolicy(recorder, opts.Events, clk),
+		plan:              plan,
+	}
+	controller.maxRetries.Store(int64(opts.MaxRetries))
+	if controller.workers < 1 {
+		controller.workers = 1
+	}
+
+	for _, informer := range fooInformers {
+		_, err := informer.AddEventHandler(controller.fooEventHandler())
+		if err != nil {
+			logger.Error(err, "Error adding event handler to the Foo informer")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+	// Set up an event handler for when Deployment resources change. This
+	// handler will lookup the owner of the given Deployment, and if it is
+	// owned by a Foo resource then the handler will enqueue that Foo resource for
+	// processing. This way, we don't need to implement custom logic for
+	// handling Deployment resources. More info on this pattern:
+	// https://github.com/kubernetes/community/blob/8cafef897a22026d42f5e5bb3f104febe7e29830/contributors/devel/controllers.md
+	// PodDisruptionBudgets and HorizontalPodAutoscalers owned by a Foo are
+	// handled in the same way as Deployments.
+	ownedInformers := append(append(append([]cache.SharedIndexInformer{}, deploymentInformers...), pdbInformers...), hpaInformers...)
+	for _, informer := range ownedInformers {
+		_, err := informer.AddEventHandler(controller.ownedObjectEventHandler())
+		if err != nil {
+			logger.Error(err, "Error adding event handler")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+
+	// Set up an event handler for when Pods of a Foo change, so the health
+	// of the pods is reported without waiting for the Deployment to change.
+	for _, informer := range podInformers {
+		_, err := informer.AddEventHandler(controller.podEventHandler())
+		if err != nil {
+			logger.Error(err, "Error adding event handler")
+			klog.FlushAndExit(klog.ExitFlushTimeout, 1)
+		}
+	}
+
+	return controller
+}
+
+// Run waits for the caches to sync and processes the Foos until the context
+// is cancelled.
+func (c *Controller) Run(ctx context.Context) error {
+	defer c.workqueue.ShutDown()
+	logger := klog.FromContext(ctx)
+
+	logger.Info("Waiting for informer caches to sync")
+	if ok := cache.WaitForCacheSync(ctx.Done(), c.foosSynced, c.deploymentsSynced, c.pdbsSynced, c.hpasSynced, c.podsSynced); !ok {
+		return fmt.Errorf("failed to wait for caches to sync")
+	}
+
+	for i := 0; i < c.workers; i++ {
+		go wait.UntilWithContext(ctx, c.runWorker, time.Second)
+	}
+	logger.Info("Started workers", "count", c.workers)
+	go wait.Until(c.events.Flush, eventFlushPeriod, ctx.Done())
+
+	<-ctx.Done()
+	logger.Info("Shutting down workers")
+	return nil
+}
+
+// Reload applies the options which can change while the controller runs,
+// which are MaxRetries, LogSampleQPS, LogSampleBurst and Events. The other
+// options only take effect on a restart.
+func (c *Controller) Reload(opts ControllerOptions) {
+	c.maxRetries.Store(int64(opts.MaxRetries))
+	c.logSampler.SetRate(opts.LogSampleQPS, opts.LogSampleBurst)
+	c.events.SetOptions(opts.Events)
+}
+
+func (c *Controller) runWorker(ctx context.Context) {
+	for c.processNextWorkItem(ctx) {
+	}
+}
+
+func (c *Controller) processNextWorkItem(ctx context.Context) bool {
+	key, shutdown := c.workqueue.Get()
+	if shutdown {
+		return false
+	}
+	c.processWorkItem(ctx, key)
+	return true
+}
+
+// processWorkItem reconciles the key got from the workqueue, and forgets it or
+// puts it back on the workqueue by the result.
+func (c *Controller) processWorkItem(ctx context.Context, key types.NamespacedName) {
+	// call Done to tell workqueue that the item was finished processing
+	defer c.workqueue.Done(key)
+	triggers := c.workqueue.PopTriggers(key)
+	reconcileID := uuid.NewUUID()
+	// The span of the reconcile is linked to the spans of the enqueues
+	// which triggered it.
+	ctx, span := c.tracer.Start(ctx, "Reconcile",
+		trace.WithSpanKind(trace.SpanKindConsumer),
+		trace.WithLinks(c.workqueue.PopLinks(key)...),
+		trace.WithAttributes(fooAttributes(key.Namespace, key.Name)...),
+		trace.WithAttributes(
+			attribute.String("reconcileID", string(reconcileID)),
+			attribute.StringSlice("trigger", triggerStrings(triggers)),
+		),
+	)
+	defer span.End()
+	// Every log of the reconcile has the key-values of the Foo and the
+	// reconcile.
+	logger := klog.LoggerWithValues(klog.FromContext(ctx),
+		"foo", key.Name,
+		"namespace", key.Namespace,
+		"reconcileID", reconcileID,
+		"trigger", triggers,
+	)
+	if span.SpanContext().IsSampled() {
+		logger = klog.LoggerWithValues(logger, "traceID", span.SpanContext().TraceID().String())
+	}
+	logger = c.logSampler.Sample(key, logger)
+	ctx = klog.NewContext(ctx, logger)
+	if !c.owns(key) {
+		// The Foo has moved to another shard since it was enqueued, so
+		// the new owner processes it instead.
+		c.workqueue.Forget(key)
+		logger.V(logLevelDebug).Info("Skipping Foo outside of the local shard")
+		return
+	}
+	logger.V(logLevelDebug).Info("Syncing Foo")
+	if c.plan != nil {
+		ctx = withDryRunKey(ctx, key)
+		defer c.plan.end(key)
+	}
+
+	result, err := c.syncHandler(ctx, key)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	c.handleResult(ctx, key, result, err)
+}
+
+// handleResult forgets the key or puts it back on the workqueue by the result
+// and the error of its reconcile.
+func (c *Controller) handleResult(ctx context.Context, key types.NamespacedName, result Result, err error) {
+	logger := klog.FromContext(ctx)
+	maxRetries := int(c.maxRetries.Load())
+	switch {
+	case err != nil && isTerminalError(err):
+		// Retrying can't fix a terminal error, so we drop the item until
+		// the Foo or its Deployment changes.
+		c.workqueue.Forget(key)
+		reconcileTotal.WithLabelValues(reconcileResultTerminalError).Inc()
+		logger.Error(err, "Error syncing Foo, not requeuing")
+		return
+	case err != nil && maxRetries > 0 && c.workqueue.NumRequeues(key) >= maxRetries:
+		// The retry budget is exhausted, so we drop the item until
+		// the Foo or its Deployment changes.
+		c.workqueue.Forget(key)
+		c.reconcileFailed(ctx, key, maxRetries, err)
+		reconcileTotal.WithLabelValues(reconcileResultDropped).Inc()
+		logger.Error(err, "Error syncing Foo, dropping", "retries", maxRetries)
+		return
+	case err != nil:
+		// Put the item back on the workqueue to handle any transient errors.
+		c.workqueue.AddRateLimited(key)
+		reconcileTotal.WithLabelValues(reconcileResultError).Inc()
+		logger.Error(err, "Error syncing Foo, requeuing", "retries", c.workqueue.NumRequeues(key))
+		return
+	case result.RequeueAfter > 0:
+		// Forget the rate limiting history as the item is successfully
+		// processed, and put it back on the workqueue after the delay.
+		c.workqueue.Forget(key)
+		c.workqueue.AddAfter(key, result.RequeueAfter)
+		reconcileTotal.WithLabelValues(reconcileResultRequeueAfter).Inc()
+	case result.Requeue:
+		c.workqueue.AddRateLimited(key)
+		reconcileTotal.WithLabelValues(reconcileResultRequeue).Inc()
+	default:
+		// Forget the queue item as it's successfully processed and
+		// the item will not be requeued.
+		c.workqueue.Forget(key)
+		reconcileTotal.WithLabelValues(reconcileResultSuccess).Inc()
+	}
+	logger.V(logLevelVerbose).Info("Successfully synced Foo", "requeue", result.Requeue, "requeueAfter", result.RequeueAfter)
+}
+
+// reconcileFailed records that the Foo is dropped from the workqueue after
+// the retries with a warning Event and the ReconcileFailed condition.
+func (c *Controller) reconcileFailed(ctx context.Context, key types.NamespacedName, retries int, syncErr error) {
+	foo, err := c.foosLister.Foos(key.Namespace).Get(key.Name)
+	if err != nil {
+		return
+	}
+	c.eventf(ctx, foo, corev1.EventTypeWarning, ReconcileFailed, MessageReconcileFailed, retries+1, syncErr.Error())
+
+	// NEVER modify objects from the store.
+	fooCopy := foo.DeepCopy()
+	meta.SetStatusCondition(&fooCopy.Status.Conditions, metav1.Condition{
+		Type:               ConditionTypeReconcileFailed,
+		Status:             metav1.ConditionTrue,
+		ObservedGeneration: foo.Generation,
+		Reason:             ReconcileFailed,
+		Message:            syncErr.Error(),
+	})
+	if _, err = c.sampleclientset.ExampleV1alpha1().Foos(foo.Namespace).UpdateStatus(ctx, fooCopy, metav1.UpdateOptions{}); err != nil {
+		klog.FromContext(ctx).Error(err, "Failed to update Foo status")
+	}
+}
+
+// enqueueFoo takes a Foo resource and converts it into a namespace/name
+// key which is then put onto the work queue with the trigger. This method
+// should *not* be passed resources of any type other than Foo.
+func (c *Controller) enqueueFoo(obj interface{}, trigger Trigger) {
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		c.logger.Error(err, "Failed to get key from the object")
+		return
+	}
+	c.enqueue(types.NamespacedName{Namespace: object.GetNamespace(), Name: object.GetName()}, trigger)
+}
+
+// handleFooDelete enqueues a deleted Foo, which may be a tombstone if the
+// deletion was missed, so the bookkeeping of the Foo is cleaned up.
+func (c *Controller) handleFooDelete(obj interface{}) {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		c.logger.V(logLevelDebug).Info("Recovered deleted Foo from tombstone", "foo", tombstone.Key)
+		obj = tombstone.Obj
+	}
+	c.enqueueFoo(obj, TriggerFooDelete)
+}
+
+// cleanupFoo cleans up the bookkeeping of a deleted Foo, which is the
+// rate limiting history of the workqueue and the log sampling.
+func (c *Controller) cleanupFoo(ctx context.Context, key types.NamespacedName) {
+	klog.FromContext(ctx).Info("Foo is deleted")
+	c.workqueue.Forget(key)
+	c.logSampler.Forget(key)
+	c.events.Forget(key)
+}
+
+// enqueue puts the key of a Foo onto the work queue with the trigger. The
+// key is skipped if it's outside of the local shard. A span is recorded for
+// the enqueue, which the span of the reconcile is linked to.
+func (c *Controller) enqueue(key types.NamespacedName, trigger Trigger) {
+	if !c.owns(key) {
+		return
+	}
+	_, span := c.tracer.Start(context.Background(), "Enqueue",
+		trace.WithSpanKind(trace.SpanKindProducer),
+		trace.WithAttributes(fooAttributes(key.Namespace, key.Name)...),
+		trace.WithAttributes(attribute.String("trigger", string(trigger))),
+	)
+	defer span.End()
+	c.workqueue.Enqueue(key, trigger, span.SpanContext())
+}
+
+// owns returns true if the Foo of the key belongs to the local shard.
+func (c *Controller) owns(key types.NamespacedName) bool {
+	return c.sharder == nil || c.sharder.Owns(key)
+}
+
+// EnqueueShard enqueues all the Foos in the local shard, which is called when
+// the shard acquires Foos from other replicas.
+func (c *Controller) EnqueueShard() {
+	foos, err := c.foosLister.List(labels.Everything())
+	if err != nil {
+		c.logger.Error(err, "Failed to list Foos")
+		return
+	}
+	for _, foo := range foos {
+		c.enqueue(types.NamespacedName{Namespace: foo.Namespace, Name: foo.Name}, TriggerShardRebalance)
+	}
+}
+
+// syncHandler compares the actual state with the desired, and attempts to
+// converge the two. The Result tells whether and when the Foo should be
+// processed again, and a terminal error is not retried.
+func (c *Controller) syncHandler(ctx context.Context, key types.NamespacedName) (Result, error) {
+	logger := klog.FromContext(ctx)
+	name := key.Name
+	foo, err := c.foosLister.Foos(key.Namespace).Get(name)
+	if err != nil {
+		if errors.IsNotFound(err) {
+			// The owned objects are deleted by the garbage collector, so
+			// only the bookkeeping of the Foo is left.
+			c.cleanupFoo(ctx, key)
+			return Result{}, nil
+		}
+		return Result{}, err
+	}
+
+	deploymentName := foo.Spec.DeploymentName
+	if deploymentName == "" {
+		logger.Error(nil, "deploymentName must be specified")
+		return Result{}, nil
+	}
+	deployment, err := c.deploymentsLister.Deployments(foo.Namespace).Get(deploymentName)
+	if errors.IsNotFound(err) {
+		logger.Info("Creating Deployment", "deployment", klog.KRef(foo.Namespace, deploymentName))
+		deployment, err = c.createDeployment(ctx, foo, newDeployment(foo))
+	}
+
+	if err != nil {
+		return Result{}, err
+	}
+
+	// A Deployment with the managed label and no owner, such as the one
+	// orphaned by deleting its Foo with --cascade=orphan, is adopted.
+	if isOrphanManagedDeployment(deployment) {
+		deployment, err = c.adoptDeployment(ctx, foo, deployment)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	// If the Deployment is not controlled by this Foo resource, we should log
+	// a warning to the event recorder and return error msg.
+	if !metav1.IsControlledBy(deployment, foo) {
+		msg := fmt.Sprintf(MessageResourceExists, deployment.Name)
+		c.event(ctx, foo, corev1.EventTypeWarning, ErrResourceExists, msg)
+		logger.Info("Deployment already exists and is not managed by Foo", "deployment", klog.KObj(deployment))
+		return Result{}, newTerminalError(fmt.Errorf("%s", msg))
+	}
+
+	// If the rollback annotation is set on the Foo, we restore the pod
+	// template of the previous rollout.
+	if _, ok := foo.Annotations[RollbackAnnotation]; ok {
+		foo, deployment, err = c.rollback(ctx, foo, deployment)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	// If the canary annotation is set on the Foo, we promote or abort the
+	// canary.
+	if operation, ok := foo.Annotations[CanaryAnnotation]; ok {
+		foo, deployment, err = c.processCanaryOperation(ctx, foo, deployment, operation)
+		if err != nil {
+			return Result{}, err
+		}
+	}
+
+	health, err := c.getPodHealth(ctx, foo)
+	if err != nil {
+		return Result{}, err
+	}
+	degraded := newDegradedCondition(foo, health)
+
+	// NEVER modify objects from the store.
+	deploymentCopy := deployment.DeepCopy()
+
+	// If this number of the replicas on the Foo resource is specified, and the
+	// number does not equal the current desired replicas on the Deployment, we
+	// should update the Deployment resource. The replicas are left to the
+	// HorizontalPodAutoscaler while autoscaling is specified, and reset once
+	// it's removed.
+	replicas, err := c.getDesiredReplicas(foo)
+	if err != nil {
+		return Result{}, err
+	}
+	scaled := false
+	if replicas != nil && *replicas != getDeploymentReplicas(deployment) {
+		logger.Info("Scaling Deployment", "deployment", klog.KObj(deployment), "from", getDeploymentReplicas(deployment), "to", *replicas)
+		deploymentCopy.Spec.Replicas = replicas
+		scaled = true
+	}
+	if foo.Spec.Strategy != nil {
+		deploymentCopy.Spec.Strategy = defaultDeploymentStrategy(*foo.Spec.Strategy)
+	}
+	// The resources are not set on the pod template restored by a rollback
+	// until the Foo changes, so the rollback is not reverted.
+	if foo.Spec.Resources != nil && !isRolledBack(foo) && len(deploymentCopy.Spec.Template.Spec.Containers) > 0 {
+		deploymentCopy.Spec.Template.Spec.Containers[0].Resources = *foo.Spec.Resources.DeepCopy()
+	}
+	setRolloutPaused(deploymentCopy, shouldPauseRollout(foo, deployment) || shouldAutoPauseRollout(foo, deployment, degraded))
+	// The Deployments created before the managed label was introduced get
+	// the label here.
+	deploymentCopy.Labels = managedLabels(deployment.Labels)
+
+	if !equality.Semantic.DeepEqual(deployment.Spec, deploymentCopy.Spec) ||
+		!equality.Semantic.DeepEqual(deployment.Labels, deploymentCopy.Labels) ||
+		!equality.Semantic.DeepEqual(deployment.Annotations, deploymentCopy.Annotations) {
+		logger.V(logLevelVerbose).Info("Updating Deployment", "deployment", klog.KObj(deployment))
+		previous := deployment
+		deployment, err = c.kubeclientset.AppsV1().Deployments(foo.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{})
+		// If an error occurs during Update, we'll requeue the item so we
+		// can attempt processing again later. This could have been caused
+		// by a temporary network failure, or any other transient reason.
+		if err != nil {
+			return Result{}, err
+		}
+		switch {
+		case scaled && isDrift(foo):
+			c.eventf(ctx, foo, corev1.EventTypeNormal, DriftCorrected, MessageDriftCorrected, "Deployment", deployment.Name)
+		case scaled:
+			c.eventf(ctx, foo, corev1.EventTypeNormal, Scaled, MessageScaled, deployment.Name, getDeploymentReplicas(previous), *deployment.Spec.Replicas)
+		case !equality.Semantic.DeepEqual(previous.Spec.Strategy, deployment.Spec.Strategy) ||
+			!equality.Semantic.DeepEqual(previous.Spec.Template, deployment.Spec.Template):
+			c.recordUpdate(ctx, foo, "Deployment", deployment.Name)
+		}
+	}
+
+	canary, err := c.syncCanary(ctx, foo, deployment)
+	if err != nil {
+		return Result{}, err
+	}
+
+	if err = c.syncPodDisruptionBudget(ctx, foo); err != nil {
+		return Result{}, err
+	}
+
+	if err = c.syncHorizontalPodAutoscaler(ctx, foo); err != nil {
+		return Result{}, err
+	}
+
+	// Finally, we update the status block of the Foo resource to reflect the
+	// current state of the world
+	err = c.updateFooStatus(ctx, foo, deployment, canary, health, degraded)
+	if err != nil {
+		return Result{}, err
+	}
+
+	c.event(ctx, foo, corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	// The progress of the rollout is checked periodically as the Deployment
+	// doesn't change while the pods are starting.
+	if newProgressingCondition(foo, deployment).Status == metav1.ConditionTrue {
+		return Result{RequeueAfter: rolloutRequeueInterval}, nil
+	}
+	return Result{}, nil
+}
+
+// deploymentLabels returns the labels of all the pods managed by the Foo,
+// which are selected by the PodDisruptionBudget. The pods of the stable and
+// canary Deployments have the track label additionally.
+func deploymentLabels(foo *samplev1alpha1.Foo) map[string]string {
+	return map[string]string{
+		"app":           "nginx",
+		controllerLabel: foo.Name,
+	}
+}
+
+// createDeployment creates the Deployment of the Foo. If the Deployment
+// already exists without being cached, which is the case of the Deployments
+// without the managed label when only the managed Deployments are cached, it's
+// read from the API server so the caller can check the owner and set the
+// label.
+func (c *Controller) createDeployment(ctx context.Context, foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) (*appsv1.Deployment, error) {
+	created, err := c.kubeclientset.AppsV1().Deployments(deployment.Namespace).Create(ctx, deployment, metav1.CreateOptions{})
+	if errors.IsAlreadyExists(err) {
+		return c.kubeclientset.AppsV1().Deployments(deployment.Namespace).Get(ctx, deployment.Name, metav1.GetOptions{})
+	}
+	if err != nil {
+		return nil, err
+	}
+	c.eventf(ctx, foo, corev1.EventTypeNormal, Created, MessageCreated, "Deployment", created.Name)
+	return created, nil
+}
+
+// isOrphanManagedDeployment returns true if the Deployment has the managed
+// label and no controller.
+func isOrphanManagedDeployment(deployment *appsv1.Deployment) bool {
+	return deployment.Labels[managedByLabel] == controllerAgentName && metav1.GetControllerOf(deployment) == nil
+}
+
+// adoptDeployment makes the Foo the controller of the orphan Deployment. The
+// Deployment is read from the API server as the spec of the Deployments not
+// controlled by a Foo is stripped from the cache.
+func (c *Controller) adoptDeployment(ctx context.Context, foo *samplev1alpha1.Foo, orphan *appsv1.Deployment) (*appsv1.Deployment, error) {
+	deployment, err := c.kubeclientset.AppsV1().Deployments(orphan.Namespace).Get(ctx, orphan.Name, metav1.GetOptions{})
+	if err != nil {
+		return nil, err
+	}
+	if !isOrphanManagedDeployment(deployment) {
+		return deployment, nil
+	}
+	klog.FromContext(ctx).Info("Adopting Deployment", "deployment", klog.KObj(deployment))
+	deployment.OwnerReferences = append(deployment.OwnerReferences, *metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo")))
+	deployment, err = c.kubeclientset.AppsV1().Deployments(deployment.Namespace).Update(ctx, deployment, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, err
+	}
+	c.eventf(ctx, foo, corev1.EventTypeNormal, Adopted, MessageAdopted, deployment.Name, managedByLabel, controllerAgentName)
+	return deployment, nil
+}
+
+// recordUpdate emits an Event for the update of a resource of the Foo, which
+// is DriftCorrected if the Foo has not changed since it was last synced, as
+// the resource must have been changed by someone else.
+func (c *Controller) recordUpdate(ctx context.Context, foo *samplev1alpha1.Foo, kind, name string) {
+	if isDrift(foo) {
+		c.eventf(ctx, foo, corev1.EventTypeNormal, DriftCorrected, MessageDriftCorrected, kind, name)
+		return
+	}
+	c.eventf(ctx, foo, corev1.EventTypeNormal, Updated, MessageUpdated, kind, name)
+}
+
+// isDrift returns true if the spec of the Foo has been synced, so a
+// difference of its resources from the desired state is a drift.
+func isDrift(foo *samplev1alpha1.Foo) bool {
+	return foo.Status.ObservedGeneration == foo.Generation
+}
+
+// managedLabels returns a copy of the labels with the managed label.
+func managedLabels(labels map[string]string) map[string]string {
+	managed := map[string]string{managedByLabel: controllerAgentName}
+	for k, v := range labels {
+		if k != managedByLabel {
+			managed[k] = v
+		}
+	}
+	return managed
+}
+
+func newDeployment(foo *samplev1alpha1.Foo) *appsv1.Deployment {
+	labels := stableLabels(foo)
+	replicas := foo.Spec.Replicas
+	if foo.Spec.Autoscaling != nil {
+		minReplicas := getMinReplicas(foo.Spec.Autoscaling)
+		replicas = &minReplicas
+	}
+	var strategy appsv1.DeploymentStrategy
+	if foo.Spec.Strategy != nil {
+		strategy = *foo.Spec.Strategy
+	}
+	var resources corev1.ResourceRequirements
+	if foo.Spec.Resources != nil {
+		resources = *foo.Spec.Resources.DeepCopy()
+	}
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            foo.Spec.DeploymentName,
+			Namespace:       foo.Namespace,
+			Labels:          managedLabels(labels),
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))},
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: replicas,
+			Strategy: strategy,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: labels,
+			},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:      "nginx",
+							Image:     "nginx:latest",
+							Resources: resources,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+// newReadyCondition returns the Ready condition of the Foo from the Deployment
+// and the Degraded condition.
+func newReadyCondition(foo *samplev1alpha1.Foo, deployment *appsv1.Deployment, degraded metav1.Condition) metav1.Condition {
+	replicas := getDeploymentReplicas(deployment)
+	condition := metav1.Condition{
+		Type:               ConditionTypeReady,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: foo.Generation,
+	}
+	switch progressing := newProgressingCondition(foo, deployment); {
+	case degraded.Status == metav1.ConditionTrue:
+		condition.Reason = reasonDegraded
+		condition.Message = degraded.Message
+	case progressing.Status != metav1.ConditionFalse:
+		condition.Reason = progressing.Reason
+		condition.Message = progressing.Message
+	case deployment.Status.AvailableReplicas < replicas:
+		condition.Reason = reasonReplicasUnavailable
+		condition.Message = fmt.Sprintf("%d of %d replicas available", deployment.Status.AvailableReplicas, replicas)
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonReplicasAvailable
+		condition.Message = fmt.Sprintf("%d of %d replicas available", deployment.Status.AvailableReplicas, replicas)
+	}
+	return condition
+}
+
+func (c *Controller) updateFooStatus(ctx context.Context, foo *samplev1alpha1.Foo, deployment, canary *appsv1.Deployment, health *samplev1alpha1.FooHealthStatus, degraded metav1.Condition) error {
+	ctx, span := c.tracer.Start(ctx, "updateFooStatus")
+	defer span.End()
+	// NEVER modify objects from the store. It's a read-only, local cache.
+	// You can use DeepCopy() to make a deep copy of original object and modify this copy
+	// Or create a copy manually for better performance
+	fooCopy := foo.DeepCopy()
+	fooCopy.Status.AvailableReplicas = deployment.Status.AvailableReplicas
+	fooCopy.Status.ObservedGeneration = foo.Generation
+	setRolloutStatus(&fooCopy.Status, foo, deployment)
+	fooCopy.Status.Stable = newTrackStatus(deployment)
+	fooCopy.Status.Canary = nil
+	if canary != nil {
+		fooCopy.Status.Canary = newTrackStatus(canary)
+	}
+	fooCopy.Status.Health = health
+	meta.SetStatusCondition(&fooCopy.Status.Conditions, degraded)
+	meta.SetStatusCondition(&fooCopy.Status.Conditions, newReadyCondition(foo, deployment, degraded))
+	meta.RemoveStatusCondition(&fooCopy.Status.Conditions, ConditionTypeReconcileFailed)
+	// If the CustomResourceSubresources feature gate is not enabled,
+	// we must use Update instead of UpdateStatus to update the Status block of the Foo resource.
+	// UpdateStatus will not allow changes to the Spec of the resource,
+	// which is ideal for ensuring nothing other than resource status has been updated.
+	_, err := c.sampleclientset.ExampleV1alpha1().Foos(foo.Namespace).UpdateStatus(ctx, fooCopy, metav1.UpdateOptions{})
+	return err
+}
+
+// fooEventHandler returns the event handler of the Foo informer, which
+// enqueues the Foo with the trigger of the event.
+func (c *Controller) fooEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			c.enqueueFoo(obj, TriggerFooAdd)
+		},
+		UpdateFunc: func(old, new interface{}) {
+			newFoo := new.(*samplev1alpha1.Foo)
+			oldFoo := old.(*samplev1alpha1.Foo)
+			if newFoo.ResourceVersion == oldFoo.ResourceVersion {
+				// Periodic resync will send update events for all known
+				// Foos every resync period, so they are reconciled even if
+				// an event is missed.
+				c.enqueueFoo(new, TriggerResync)
+				return
+			}
+			// The updates of the status only, including the ones by the
+			// controller itself, are ignored.
+			if !anyPredicate(fooUpdatePredicates, oldFoo, newFoo) {
+				return
+			}
+			c.enqueueFoo(new, TriggerFooUpdate)
+		},
+		DeleteFunc: c.handleFooDelete,
+	}
+}
+
+// ownedObjectEventHandler returns the event handler for the objects owned by
+// a Foo, which enqueues the owner Foo with handleObject. Periodic resync will
+// send update events for all known objects, which are ignored as two
+// different versions of the same object will always have different RVs.
+func (c *Controller) ownedObjectEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handleObject,
+		UpdateFunc: func(old, new interface{}) {
+			newObj := new.(metav1.Object)
+			oldObj := old.(metav1.Object)
+			if newObj.GetResourceVersion() == oldObj.GetResourceVersion() {
+				return
+			}
+			c.handleObject(new)
+		},
+		DeleteFunc: c.handleObject,
+	}
+}
+
+// handleObject will take any resource implementing metav1.Object and attempt
+// to find the Foo resource that 'owns' it. It does this by looking at the
+// objects metadata.ownerReferences field for an appropriate OwnerReference.
+// It then enqueues that Foo resource to be processed. If the object does not
+// have an appropriate OwnerReference, it will simply be skipped.
+func (c *Controller) handleObject(obj interface{}) {
+	var object metav1.Object
+	var ok bool
+	if object, ok = obj.(metav1.Object); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		object, ok = tombstone.Obj.(metav1.Object)
+		if !ok {
+			return
+		}
+		c.logger.V(logLevelDebug).Info("Recovered deleted object from tombstone", "object", klog.KObj(object))
+	}
+	c.logger.V(logLevelDebug).Info("Processing object", "object", klog.KObj(object))
+	if ownerRef := metav1.GetControllerOf(object); ownerRef != nil {
+		// If this object is not owned by a Foo, we should not do anything more
+		// with it.
+		if ownerRef.Kind != "Foo" {
+			return
+		}
+
+		foo, err := c.foosLister.Foos(object.GetNamespace()).Get(ownerRef.Name)
+		if err != nil {
+			c.logger.V(logLevelDebug).Info("Ignoring orphaned object", "object", klog.KObj(object), "foo", klog.KRef(object.GetNamespace(), ownerRef.Name))
+			return
+		}
+
+		c.enqueueFoo(foo, triggerForObject(object))
+		return
+	}
+}
+
+// triggerForObject returns the trigger for a change of the object owned by a
+// Foo.
+func triggerForObject(object metav1.Object) Trigger {
+	switch object.(type) {
+	case *policyv1.PodDisruptionBudget:
+		return TriggerPodDisruptionBudgetChange
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		return TriggerHorizontalPodAutoscalerChange
+	default:
+		return TriggerDeploymentChange
+	}
+}
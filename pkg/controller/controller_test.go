@@ -0,0 +1,1855 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/faultinjection"
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/fake"
+	informers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions"
+
+	"github.com/go-logr/logr"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+	"k8s.io/klog/v2/ktesting"
+	"k8s.io/utils/clock"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+)
+
+var (
+	alwaysReady        = func() bool { return true }
+	noResyncPeriodFunc = func() time.Duration { return 0 }
+)
+
+// fixture builds a Controller on the fake clientsets with the objects in the
+// informer indexers, runs syncHandler for a key and checks the API actions and
+// Events.
+type fixture struct {
+	t testing.TB
+
+	client     *fake.Clientset
+	kubeclient *k8sfake.Clientset
+	recorder   *record.FakeRecorder
+
+	// Objects to put in the store.
+	fooLister        []*samplev1alpha1.Foo
+	deploymentLister []*appsv1.Deployment
+	pdbLister        []*policyv1.PodDisruptionBudget
+	hpaLister        []*autoscalingv2.HorizontalPodAutoscaler
+	podLister        []*corev1.Pod
+
+	// Actions expected to happen on the client.
+	kubeactions []core.Action
+	actions     []core.Action
+	// Events expected to be recorded, in the format of record.FakeRecorder.
+	events []string
+
+	// Objects from here preloaded into NewSimpleClientset.
+	kubeobjects []runtime.Object
+	objects     []runtime.Object
+
+	// opts are the options of the Controller, whose Recorder is replaced.
+	opts ControllerOptions
+}
+
+func newFixture(t testing.TB) *fixture {
+	f := &fixture{}
+	f.t = t
+	f.objects = []runtime.Object{}
+	f.kubeobjects = []runtime.Object{}
+	return f
+}
+
+func newFoo(name string, replicas *int32) *samplev1alpha1.Foo {
+	return &samplev1alpha1.Foo{
+		TypeMeta: metav1.TypeMeta{APIVersion: samplev1alpha1.SchemeGroupVersion.String(), Kind: "Foo"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:       name,
+			Namespace:  metav1.NamespaceDefault,
+			Generation: 1,
+		},
+		Spec: samplev1alpha1.FooSpec{
+			DeploymentName: fmt.Sprintf("%s-deployment", name),
+			Replicas:       replicas,
+		},
+	}
+}
+
+func (f *fixture) newController() (*Controller, informers.SharedInformerFactory, kubeinformers.SharedInformerFactory) {
+	_, ctx := ktesting.NewTestContext(f.t)
+	f.client = fake.NewSimpleClientset(f.objects...)
+	f.kubeclient = k8sfake.NewSimpleClientset(f.kubeobjects...)
+	f.recorder = record.NewFakeRecorder(100)
+
+	i := informers.NewSharedInformerFactory(f.client, noResyncPeriodFunc())
+	k8sI := kubeinformers.NewSharedInformerFactory(f.kubeclient, noResyncPeriodFunc())
+	informerSet := Informers{
+		Deployments:              k8sI.Apps().V1().Deployments(),
+		PodDisruptionBudgets:     k8sI.Policy().V1().PodDisruptionBudgets(),
+		HorizontalPodAutoscalers: k8sI.Autoscaling().V2().HorizontalPodAutoscalers(),
+		Pods:                     k8sI.Core().V1().Pods(),
+		Foos:                     i.Example().V1alpha1().Foos(),
+	}
+
+	opts := f.opts
+	opts.Recorder = f.recorder
+	c := NewController(ctx, f.kubeclient, f.client, []Informers{informerSet}, opts)
+	c.foosSynced = alwaysReady
+	c.deploymentsSynced = alwaysReady
+	c.pdbsSynced = alwaysReady
+	c.hpasSynced = alwaysReady
+	c.podsSynced = alwaysReady
+
+	for _, foo := range f.fooLister {
+		if err := i.Example().V1alpha1().Foos().Informer().GetIndexer().Add(foo); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	for _, d := range f.deploymentLister {
+		if err := k8sI.Apps().V1().Deployments().Informer().GetIndexer().Add(d); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	for _, pdb := range f.pdbLister {
+		if err := k8sI.Policy().V1().PodDisruptionBudgets().Informer().GetIndexer().Add(pdb); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	for _, hpa := range f.hpaLister {
+		if err := k8sI.Autoscaling().V2().HorizontalPodAutoscalers().Informer().GetIndexer().Add(hpa); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	for _, pod := range f.podLister {
+		if err := k8sI.Core().V1().Pods().Informer().GetIndexer().Add(pod); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+	return c, i, k8sI
+}
+
+func (f *fixture) run(fooRef types.NamespacedName) {
+	f.runController(fooRef, false)
+}
+
+func (f *fixture) runExpectError(fooRef types.NamespacedName) error {
+	return f.runController(fooRef, true)
+}
+
+func (f *fixture) runController(fooRef types.NamespacedName, expectError bool) error {
+	_, ctx := ktesting.NewTestContext(f.t)
+	c, _, _ := f.newController()
+
+	_, err := c.syncHandler(ctx, fooRef)
+	if !expectError && err != nil {
+		f.t.Errorf("error syncing foo: %v", err)
+	} else if expectError && err == nil {
+		f.t.Error("expected error syncing foo, got nil")
+	}
+
+	f.checkActions()
+	f.checkEvents()
+	return err
+}
+
+// checkActions checks that the expected actions and no other actions are
+// made on the clients.
+func (f *fixture) checkActions() {
+	actions := filterInformerActions(f.client.Actions())
+	for i, action := range actions {
+		if len(f.actions) < i+1 {
+			f.t.Errorf("%d unexpected actions: %+v", len(actions)-len(f.actions), actions[i:])
+			break
+		}
+		checkAction(f.actions[i], action, f.t)
+	}
+	if len(f.actions) > len(actions) {
+		f.t.Errorf("%d additional expected actions:%+v", len(f.actions)-len(actions), f.actions[len(actions):])
+	}
+
+	k8sActions := filterInformerActions(f.kubeclient.Actions())
+	for i, action := range k8sActions {
+		if len(f.kubeactions) < i+1 {
+			f.t.Errorf("%d unexpected actions: %+v", len(k8sActions)-len(f.kubeactions), k8sActions[i:])
+			break
+		}
+		checkAction(f.kubeactions[i], action, f.t)
+	}
+	if len(f.kubeactions) > len(k8sActions) {
+		f.t.Errorf("%d additional expected actions:%+v", len(f.kubeactions)-len(k8sActions), f.kubeactions[len(k8sActions):])
+	}
+}
+
+// checkEvents checks that the expected Events and no other Events are
+// recorded.
+func (f *fixture) checkEvents() {
+	var events []string
+	for len(f.recorder.Events) > 0 {
+		events = append(events, <-f.recorder.Events)
+	}
+	if diff := cmp.Diff(f.events, events); diff != "" {
+		f.t.Errorf("unexpected events (-want +got):\n%s", diff)
+	}
+}
+
+// checkAction verifies that expected and actual actions are equal and both
+// have the same attached resources, or the same name for deletions. Only the
+// resource of lists is checked. The LastTransitionTime of the conditions
+// is ignored as it's set to the current time.
+func checkAction(expected, actual core.Action, t testing.TB) {
+	if !(expected.Matches(actual.GetVerb(), actual.GetResource().Resource) && actual.GetSubresource() == expected.GetSubresource()) {
+		t.Errorf("expected\n\t%#v\ngot\n\t%#v", expected, actual)
+		return
+	}
+
+	if reflect.TypeOf(actual) != reflect.TypeOf(expected) {
+		t.Errorf("action has wrong type. Expected: %t. Got: %t", expected, actual)
+		return
+	}
+
+	var expObject, object runtime.Object
+	switch a := actual.(type) {
+	case core.CreateActionImpl:
+		expObject = expected.(core.CreateActionImpl).GetObject()
+		object = a.GetObject()
+	case core.UpdateActionImpl:
+		expObject = expected.(core.UpdateActionImpl).GetObject()
+		object = a.GetObject()
+	case core.ListActionImpl:
+		return
+	case core.DeleteActionImpl:
+		if e := expected.(core.DeleteActionImpl); e.GetNamespace() != a.GetNamespace() || e.GetName() != a.GetName() {
+			t.Errorf("action %s %s has wrong name. Expected: %s/%s. Got: %s/%s",
+				actual.GetVerb(), actual.GetResource().Resource, e.GetNamespace(), e.GetName(), a.GetNamespace(), a.GetName())
+		}
+		return
+	default:
+		t.Errorf("uncaptured action %s %s, you should explicitly add a case to capture it",
+			actual.GetVerb(), actual.GetResource().Resource)
+		return
+	}
+	if diff := cmp.Diff(expObject, object, cmpopts.IgnoreFields(metav1.Condition{}, "LastTransitionTime")); diff != "" {
+		t.Errorf("action %s %s has wrong object (-want +got):\n%s",
+			actual.GetVerb(), actual.GetResource().Resource, diff)
+	}
+}
+
+// filterInformerActions filters list and watch actions for testing resources.
+// Since list and watch don't change resource state we can filter it to lower
+// noise level in our tests.
+func filterInformerActions(actions []core.Action) []core.Action {
+	ret := []core.Action{}
+	for _, action := range actions {
+		if action.Matches("list", "foos") ||
+			action.Matches("watch", "foos") ||
+			action.Matches("list", "deployments") ||
+			action.Matches("watch", "deployments") {
+			continue
+		}
+		ret = append(ret, action)
+	}
+	return ret
+}
+
+func (f *fixture) expectCreateDeploymentAction(d *appsv1.Deployment) {
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
+}
+
+func (f *fixture) expectUpdateDeploymentAction(d *appsv1.Deployment) {
+	f.kubeactions = append(f.kubeactions, core.NewUpdateAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d))
+}
+
+func (f *fixture) expectListReplicaSetsAction(namespace string) {
+	f.kubeactions = append(f.kubeactions, core.NewListAction(schema.GroupVersionResource{Resource: "replicasets"}, schema.GroupVersionKind{Kind: "ReplicaSet"}, namespace, metav1.ListOptions{}))
+}
+
+func (f *fixture) expectUpdateFooAction(foo *samplev1alpha1.Foo) {
+	f.actions = append(f.actions, core.NewUpdateAction(schema.GroupVersionResource{Resource: "foos"}, foo.Namespace, foo))
+}
+
+func (f *fixture) expectCreatePodDisruptionBudgetAction(pdb *policyv1.PodDisruptionBudget) {
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "poddisruptionbudgets"}, pdb.Namespace, pdb))
+}
+
+func (f *fixture) expectDeletePodDisruptionBudgetAction(pdb *policyv1.PodDisruptionBudget) {
+	f.kubeactions = append(f.kubeactions, core.NewDeleteAction(schema.GroupVersionResource{Resource: "poddisruptionbudgets"}, pdb.Namespace, pdb.Name))
+}
+
+func (f *fixture) expectCreateHorizontalPodAutoscalerAction(hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	f.kubeactions = append(f.kubeactions, core.NewCreateAction(schema.GroupVersionResource{Resource: "horizontalpodautoscalers"}, hpa.Namespace, hpa))
+}
+
+func (f *fixture) expectDeleteHorizontalPodAutoscalerAction(hpa *autoscalingv2.HorizontalPodAutoscaler) {
+	f.kubeactions = append(f.kubeactions, core.NewDeleteAction(schema.GroupVersionResource{Resource: "horizontalpodautoscalers"}, hpa.Namespace, hpa.Name))
+}
+
+// expectUpdateFooStatusAction expects the status of the Foo to be updated to
+// the status of the synced Deployment with healthy pods.
+func (f *fixture) expectUpdateFooStatusAction(foo *samplev1alpha1.Foo, d *appsv1.Deployment) {
+	degraded := metav1.Condition{
+		Type:               ConditionTypeDegraded,
+		Status:             metav1.ConditionFalse,
+		ObservedGeneration: foo.Generation,
+		Reason:             reasonHealthy,
+		Message:            "pods are healthy",
+	}
+	fooCopy := foo.DeepCopy()
+	fooCopy.Status = samplev1alpha1.FooStatus{
+		AvailableReplicas:  d.Status.AvailableReplicas,
+		UpdatedReplicas:    d.Status.UpdatedReplicas,
+		ObservedGeneration: foo.Generation,
+		TemplateHash:       computeTemplateHash(&d.Spec.Template),
+		Conditions: []metav1.Condition{
+			newProgressingCondition(foo, d),
+			degraded,
+			newReadyCondition(foo, d, degraded),
+		},
+		Stable: newTrackStatus(d),
+		Health: &samplev1alpha1.FooHealthStatus{},
+	}
+	action := core.NewUpdateSubresourceAction(schema.GroupVersionResource{Resource: "foos"}, "status", foo.Namespace, fooCopy)
+	f.actions = append(f.actions, action)
+}
+
+// lastExpectedFooStatus returns the status of the Foo of the last expected
+// action, so a test can adjust the status built by
+// expectUpdateFooStatusAction.
+func (f *fixture) lastExpectedFooStatus() *samplev1alpha1.FooStatus {
+	return &f.actions[len(f.actions)-1].(core.UpdateActionImpl).GetObject().(*samplev1alpha1.Foo).Status
+}
+
+func (f *fixture) expectDeleteDeploymentAction(d *appsv1.Deployment) {
+	f.kubeactions = append(f.kubeactions, core.NewDeleteAction(schema.GroupVersionResource{Resource: "deployments"}, d.Namespace, d.Name))
+}
+
+func (f *fixture) expectEvent(eventtype, reason, messageFmt string, args ...interface{}) {
+	f.events = append(f.events, fmt.Sprintf("%s %s %s", eventtype, reason, fmt.Sprintf(messageFmt, args...)))
+}
+
+func getRef(foo *samplev1alpha1.Foo) types.NamespacedName {
+	return types.NamespacedName{Namespace: foo.Namespace, Name: foo.Name}
+}
+
+func TestCreatesDeployment(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+
+	expDeployment := newDeployment(foo)
+	f.expectCreateDeploymentAction(expDeployment)
+	f.expectUpdateFooStatusAction(foo, expDeployment)
+	f.expectEvent(corev1.EventTypeNormal, Created, MessageCreated, "Deployment", expDeployment.Name)
+	f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	f.run(getRef(foo))
+}
+
+func TestDoNothing(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+	d := newDeployment(foo)
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+
+	f.expectUpdateFooStatusAction(foo, d)
+	f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	f.run(getRef(foo))
+}
+
+func TestUpdateDeployment(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+	d := newDeployment(foo)
+
+	// Update replicas
+	foo.Generation = 2
+	foo.Status.ObservedGeneration = 1
+	foo.Spec.Replicas = pointer.Int32(2)
+	expDeployment := newDeployment(foo)
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+
+	f.expectUpdateDeploymentAction(expDeployment)
+	f.expectUpdateFooStatusAction(foo, expDeployment)
+	f.expectEvent(corev1.EventTypeNormal, Scaled, MessageScaled, d.Name, 1, 2)
+	f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	f.run(getRef(foo))
+}
+
+func TestNotControlledByUs(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+	d := newDeployment(foo)
+
+	d.ObjectMeta.OwnerReferences = []metav1.OwnerReference{}
+	delete(d.Labels, managedByLabel)
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d)
+
+	f.expectEvent(corev1.EventTypeWarning, ErrResourceExists, MessageResourceExists, d.Name)
+
+	err := f.runExpectError(getRef(foo))
+	if !isTerminalError(err) {
+		t.Errorf("expected a terminal error, got %v", err)
+	}
+}
+
+func TestSyncPodDisruptionBudget(t *testing.T) {
+	minAvailable := intstr.FromInt(1)
+	for _, tc := range []struct {
+		name     string
+		replicas int32
+		budget   *samplev1alpha1.FooDisruptionBudget
+		// existing is true if the PodDisruptionBudget of the Foo exists.
+		existing bool
+		// expect sets the expected actions and Events of the
+		// PodDisruptionBudget.
+		expect func(f *fixture, foo *samplev1alpha1.Foo, pdb *policyv1.PodDisruptionBudget)
+	}{
+		{
+			name:     "create",
+			replicas: 2,
+			budget:   &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable},
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, pdb *policyv1.PodDisruptionBudget) {
+				f.expectCreatePodDisruptionBudgetAction(pdb)
+				f.expectEvent(corev1.EventTypeNormal, Created, MessageCreated, "PodDisruptionBudget", pdb.Name)
+			},
+		},
+		{
+			name:     "in sync",
+			replicas: 2,
+			budget:   &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable},
+			existing: true,
+			expect:   func(*fixture, *samplev1alpha1.Foo, *policyv1.PodDisruptionBudget) {},
+		},
+		{
+			name:     "skip blocking the only replica",
+			replicas: 1,
+			budget:   &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable},
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, pdb *policyv1.PodDisruptionBudget) {
+				f.expectEvent(corev1.EventTypeWarning, DisruptionBudgetSkipped, MessageDisruptionBudgetSkipped, pdb.Name)
+			},
+		},
+		{
+			name:     "delete blocking the only replica",
+			replicas: 1,
+			budget:   &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable},
+			existing: true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, pdb *policyv1.PodDisruptionBudget) {
+				f.expectEvent(corev1.EventTypeWarning, DisruptionBudgetSkipped, MessageDisruptionBudgetSkipped, pdb.Name)
+				f.expectDeletePodDisruptionBudgetAction(pdb)
+			},
+		},
+		{
+			name:     "delete removed budget",
+			replicas: 2,
+			existing: true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, pdb *policyv1.PodDisruptionBudget) {
+				f.expectDeletePodDisruptionBudgetAction(pdb)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(tc.replicas))
+			foo.Spec.DisruptionBudget = &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable}
+			d := newDeployment(foo)
+			pdb := newPodDisruptionBudget(foo)
+			foo.Spec.DisruptionBudget = tc.budget
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			if tc.existing {
+				f.pdbLister = append(f.pdbLister, pdb)
+				f.kubeobjects = append(f.kubeobjects, pdb)
+			}
+
+			tc.expect(f, foo, pdb)
+			f.expectUpdateFooStatusAction(foo, d)
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+func TestSyncHorizontalPodAutoscaler(t *testing.T) {
+	for _, tc := range []struct {
+		name        string
+		replicas    *int32
+		autoscaling *samplev1alpha1.FooAutoscaling
+		// existing is true if the HorizontalPodAutoscaler of the Foo
+		// exists.
+		existing bool
+		// expect sets the expected actions and Events of the
+		// HorizontalPodAutoscaler.
+		expect func(f *fixture, hpa *autoscalingv2.HorizontalPodAutoscaler)
+	}{
+		{
+			name:        "create",
+			replicas:    pointer.Int32(3),
+			autoscaling: &samplev1alpha1.FooAutoscaling{MaxReplicas: 5},
+			expect: func(f *fixture, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				f.expectCreateHorizontalPodAutoscalerAction(hpa)
+				f.expectEvent(corev1.EventTypeNormal, Created, MessageCreated, "HorizontalPodAutoscaler", hpa.Name)
+			},
+		},
+		{
+			// The replicas of the Deployment set by the
+			// HorizontalPodAutoscaler are not overwritten by the
+			// replicas of the Foo.
+			name:        "keep replicas",
+			replicas:    pointer.Int32(3),
+			autoscaling: &samplev1alpha1.FooAutoscaling{MaxReplicas: 5},
+			existing:    true,
+			expect:      func(*fixture, *autoscalingv2.HorizontalPodAutoscaler) {},
+		},
+		{
+			name:     "delete",
+			replicas: pointer.Int32(3),
+			existing: true,
+			expect: func(f *fixture, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				f.expectDeleteHorizontalPodAutoscalerAction(hpa)
+			},
+		},
+		{
+			// The replicas are reset to the default without
+			// spec.replicas.
+			name:     "delete without replicas",
+			existing: true,
+			expect: func(f *fixture, hpa *autoscalingv2.HorizontalPodAutoscaler) {
+				f.expectDeleteHorizontalPodAutoscalerAction(hpa)
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", tc.replicas)
+			foo.Spec.Resources = &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+			}
+			foo.Spec.Autoscaling = &samplev1alpha1.FooAutoscaling{MaxReplicas: 5}
+			hpa := newHorizontalPodAutoscaler(foo)
+			foo.Spec.Autoscaling = tc.autoscaling
+			d := newDeployment(foo)
+			if tc.existing {
+				// The HorizontalPodAutoscaler has scaled the Deployment.
+				d.Spec.Replicas = pointer.Int32(4)
+				f.hpaLister = append(f.hpaLister, hpa)
+				f.kubeobjects = append(f.kubeobjects, hpa)
+			}
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+
+			if tc.autoscaling == nil && tc.existing {
+				// The replicas of the Foo are restored once autoscaling is
+				// removed.
+				replicas := int32(1)
+				if tc.replicas != nil {
+					replicas = *tc.replicas
+				}
+				expDeployment := d.DeepCopy()
+				expDeployment.Spec.Replicas = &replicas
+				f.expectUpdateDeploymentAction(expDeployment)
+				f.expectEvent(corev1.EventTypeNormal, Scaled, MessageScaled, d.Name, 4, replicas)
+				d = expDeployment
+			}
+			tc.expect(f, hpa)
+			f.expectUpdateFooStatusAction(foo, d)
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+// TestUpdateDeploymentResources checks the resources of the Foo are set on
+// the Deployment, except on the pod template restored by a rollback until
+// the Foo changes.
+func TestUpdateDeploymentResources(t *testing.T) {
+	for _, tc := range []struct {
+		name                 string
+		observedGeneration   int64
+		rolledBackGeneration int64
+		// reason and message are the expected Event of the update, or
+		// empty if the Deployment isn't updated.
+		reason, message string
+	}{
+		{name: "foo changed", observedGeneration: 1, reason: Updated, message: MessageUpdated},
+		{name: "deployment changed", observedGeneration: 2, reason: DriftCorrected, message: MessageDriftCorrected},
+		{name: "rolled back", observedGeneration: 2, rolledBackGeneration: 2},
+		{name: "foo changed after rollback", observedGeneration: 1, rolledBackGeneration: 1, reason: Updated, message: MessageUpdated},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(1))
+			d := newDeployment(foo)
+			foo.Generation = 2
+			foo.Status.ObservedGeneration = tc.observedGeneration
+			foo.Status.RolledBackGeneration = tc.rolledBackGeneration
+			foo.Spec.Resources = &corev1.ResourceRequirements{
+				Requests: corev1.ResourceList{corev1.ResourceCPU: resource.MustParse("100m")},
+				Limits:   corev1.ResourceList{corev1.ResourceMemory: resource.MustParse("128Mi")},
+			}
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+
+			if tc.reason != "" {
+				d = newDeployment(foo)
+				f.expectUpdateDeploymentAction(d)
+				f.expectEvent(corev1.EventTypeNormal, tc.reason, tc.message, "Deployment", d.Name)
+			}
+			f.expectUpdateFooStatusAction(foo, d)
+			f.lastExpectedFooStatus().RolledBackGeneration = tc.rolledBackGeneration
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+// newRollingDeployment returns the Deployment of the Foo with the number of
+// replicas updated out of 4 in a rollout observed by the Deployment
+// controller.
+func newRollingDeployment(foo *samplev1alpha1.Foo, updated int32) *appsv1.Deployment {
+	d := newDeployment(foo)
+	d.Generation = 2
+	d.Spec.Replicas = pointer.Int32(4)
+	d.Status = appsv1.DeploymentStatus{ObservedGeneration: 2, Replicas: 4, UpdatedReplicas: updated}
+	return d
+}
+
+func TestSyncRolloutPaused(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		pauseAt *int32
+		// updated is the number of updated replicas out of 4.
+		updated int32
+		// paused and owned tell whether the Deployment is paused, and
+		// whether the controller owns the pause.
+		paused, owned bool
+		// wantPaused and wantOwned are the expected ones, or nil if the
+		// Deployment isn't updated.
+		wantPaused, wantOwned *bool
+	}{
+		{name: "pause at", pauseAt: pointer.Int32(50), updated: 2, wantPaused: pointer.Bool(true), wantOwned: pointer.Bool(true)},
+		{name: "before pause at", pauseAt: pointer.Int32(50), updated: 1},
+		{name: "keep paused", pauseAt: pointer.Int32(50), updated: 2, paused: true, owned: true},
+		{name: "resume raised pause at", pauseAt: pointer.Int32(75), updated: 2, paused: true, owned: true, wantPaused: pointer.Bool(false), wantOwned: pointer.Bool(false)},
+		{name: "resume removed pause at", updated: 2, paused: true, owned: true, wantPaused: pointer.Bool(false), wantOwned: pointer.Bool(false)},
+		{name: "keep manual pause", updated: 2, paused: true},
+		{name: "keep manual pause at pause at", pauseAt: pointer.Int32(50), updated: 2, paused: true},
+		{name: "forget manual resume", updated: 2, owned: true, wantPaused: pointer.Bool(false), wantOwned: pointer.Bool(false)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(4))
+			foo.Spec.Rollout = &samplev1alpha1.FooRollout{PauseAt: tc.pauseAt}
+			d := newRollingDeployment(foo, tc.updated)
+			d.Spec.Paused = tc.paused
+			if tc.owned {
+				d.Annotations = map[string]string{rolloutPausedAnnotation: "true"}
+			}
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+
+			if tc.wantPaused != nil {
+				d = d.DeepCopy()
+				d.Spec.Paused = *tc.wantPaused
+				d.Annotations = map[string]string{}
+				if *tc.wantOwned {
+					d.Annotations[rolloutPausedAnnotation] = "true"
+				}
+				f.expectUpdateDeploymentAction(d)
+			}
+			f.expectUpdateFooStatusAction(foo, d)
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+// TestRollback checks the rollback annotation restores the pod template of
+// the previous rollout from its ReplicaSet and is removed from the Foo.
+func TestRollback(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+	previous := newDeployment(foo)
+	previous.UID = "deployment-uid"
+	d := previous.DeepCopy()
+	d.Spec.Template.Spec.Containers[0].Image = "nginx:broken"
+	foo.Annotations = map[string]string{RollbackAnnotation: ""}
+	foo.Status.ObservedGeneration = foo.Generation
+	foo.Status.TemplateHash = computeTemplateHash(&d.Spec.Template)
+	foo.Status.PreviousTemplateHash = computeTemplateHash(&previous.Spec.Template)
+
+	// The Deployment controller adds the template hash label to the pod
+	// template of the ReplicaSet.
+	template := previous.Spec.Template.DeepCopy()
+	template.Labels[appsv1.DefaultDeploymentUniqueLabelKey] = "previous"
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            d.Name + "-previous",
+			Namespace:       d.Namespace,
+			Labels:          previous.Spec.Template.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: *template},
+	}
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d, rs)
+
+	f.expectListReplicaSetsAction(d.Namespace)
+	f.expectUpdateDeploymentAction(previous)
+	rolledBack := foo.DeepCopy()
+	rolledBack.Annotations = map[string]string{}
+	f.expectUpdateFooAction(rolledBack)
+	f.expectUpdateFooStatusAction(rolledBack, previous)
+	f.lastExpectedFooStatus().PreviousTemplateHash = foo.Status.TemplateHash
+	f.lastExpectedFooStatus().RolledBackGeneration = foo.Generation
+	f.expectEvent(corev1.EventTypeNormal, RolledBack, MessageRolledBack, d.Name, foo.Status.PreviousTemplateHash)
+	f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+	f.run(getRef(foo))
+}
+
+func TestSyncCanary(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		// operation is the value of the canary annotation, if any.
+		operation string
+		// legacy is true if the selector of the stable Deployment is the
+		// one created before the track label.
+		legacy bool
+		// existing is true if the canary Deployment exists.
+		existing bool
+		// expect sets the expected actions and Events of the canary, and
+		// returns the synced Foo, stable Deployment and canary Deployment.
+		expect func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment)
+	}{
+		{
+			name: "create",
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				f.expectCreateDeploymentAction(canary)
+				f.expectEvent(corev1.EventTypeNormal, Created, MessageCreated, "Deployment", canary.Name)
+				return foo, stable, canary
+			},
+		},
+		{
+			name:     "in sync",
+			existing: true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				return foo, stable, canary
+			},
+		},
+		{
+			name:      "promote",
+			operation: CanaryPromote,
+			existing:  true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				promoted := stable.DeepCopy()
+				promoted.Spec.Template.Spec.Containers[0].Image = foo.Spec.Canary.Template.Image
+				f.expectUpdateDeploymentAction(promoted)
+				f.expectEvent(corev1.EventTypeNormal, CanaryPromoted, MessageCanaryPromoted, stable.Name)
+				foo = foo.DeepCopy()
+				foo.Annotations = map[string]string{}
+				foo.Spec.Canary = nil
+				f.expectUpdateFooAction(foo)
+				f.expectDeleteDeploymentAction(canary)
+				return foo, promoted, nil
+			},
+		},
+		{
+			name:      "abort",
+			operation: CanaryAbort,
+			existing:  true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				f.expectEvent(corev1.EventTypeNormal, CanaryAborted, MessageCanaryAborted, canary.Name)
+				foo = foo.DeepCopy()
+				foo.Annotations = map[string]string{}
+				foo.Spec.Canary = nil
+				f.expectUpdateFooAction(foo)
+				f.expectDeleteDeploymentAction(canary)
+				return foo, stable, nil
+			},
+		},
+		{
+			name:   "skip selected by legacy stable",
+			legacy: true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				f.expectEvent(corev1.EventTypeWarning, CanarySkipped, MessageCanarySkipped, canary.Name, stable.Name)
+				return foo, stable, nil
+			},
+		},
+		{
+			name:     "delete selected by legacy stable",
+			legacy:   true,
+			existing: true,
+			expect: func(f *fixture, foo *samplev1alpha1.Foo, stable, canary *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, *appsv1.Deployment) {
+				f.expectEvent(corev1.EventTypeWarning, CanarySkipped, MessageCanarySkipped, canary.Name, stable.Name)
+				f.expectDeleteDeploymentAction(canary)
+				return foo, stable, nil
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(2))
+			foo.Spec.Canary = &samplev1alpha1.FooCanary{
+				Template: samplev1alpha1.FooCanaryTemplate{Image: "nginx:canary"},
+				Replicas: pointer.Int32(1),
+			}
+			if tc.operation != "" {
+				foo.Annotations = map[string]string{CanaryAnnotation: tc.operation}
+			}
+			stable := newDeployment(foo)
+			if tc.legacy {
+				stable.Spec.Selector.MatchLabels = deploymentLabels(foo)
+				stable.Spec.Template.Labels = deploymentLabels(foo)
+			}
+			canary := newCanaryDeployment(foo, stable)
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, stable)
+			f.kubeobjects = append(f.kubeobjects, stable)
+			if tc.existing {
+				f.deploymentLister = append(f.deploymentLister, canary)
+				f.kubeobjects = append(f.kubeobjects, canary)
+			}
+
+			foo, stable, canary = tc.expect(f, foo, stable, canary)
+			f.expectUpdateFooStatusAction(foo, stable)
+			if canary != nil {
+				f.lastExpectedFooStatus().Canary = newTrackStatus(canary)
+			}
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+// TestCanarySelectors checks the selectors of the stable and canary
+// Deployments don't select the pods of each other.
+func TestCanarySelectors(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(2))
+	foo.Spec.Canary = &samplev1alpha1.FooCanary{Template: samplev1alpha1.FooCanaryTemplate{Image: "nginx:canary"}}
+	stable := newDeployment(foo)
+	canary := newCanaryDeployment(foo, stable)
+	for _, tc := range []struct {
+		name     string
+		selector *metav1.LabelSelector
+		pods     map[string]string
+	}{
+		{name: "stable selects canary", selector: stable.Spec.Selector, pods: canary.Spec.Template.Labels},
+		{name: "canary selects stable", selector: canary.Spec.Selector, pods: stable.Spec.Template.Labels},
+	} {
+		selector, err := metav1.LabelSelectorAsSelector(tc.selector)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if selector.Matches(labels.Set(tc.pods)) {
+			t.Errorf("%s: selector %s matches %v", tc.name, selector, tc.pods)
+		}
+	}
+	if selectsCanary(foo, stable) {
+		t.Error("Expected the stable Deployment not to select the canary pods")
+	}
+}
+
+// newPod returns a pod of the Foo whose container has restarted and is
+// waiting for the reason, if any.
+func newPod(foo *samplev1alpha1.Foo, name string, restarts int32, waitingReason string) *corev1.Pod {
+	status := corev1.ContainerStatus{Name: "nginx", RestartCount: restarts}
+	if waitingReason != "" {
+		status.State.Waiting = &corev1.ContainerStateWaiting{Reason: waitingReason}
+	}
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: foo.Namespace, Labels: stableLabels(foo)},
+		Status:     corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{status}},
+	}
+}
+
+func TestDegraded(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		health *samplev1alpha1.FooHealth
+		pods   func(foo *samplev1alpha1.Foo) []*corev1.Pod
+		// paused and owned tell whether the rollout of the Deployment is
+		// paused, and whether the controller owns the pause.
+		paused, owned bool
+		// wantPaused is the expected pause of the rollout, or nil if the
+		// Deployment isn't updated.
+		wantPaused   *bool
+		wantHealth   samplev1alpha1.FooHealthStatus
+		wantDegraded metav1.Condition
+	}{
+		{
+			name: "healthy",
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 1, ""), newPod(foo, "b", 0, "")}
+			},
+			wantHealth:   samplev1alpha1.FooHealthStatus{Restarts: 1},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionFalse, Reason: reasonHealthy, Message: "pods are healthy"},
+		},
+		{
+			name: "unhealthy pods",
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 3, waitingCrashLoopBackOff), newPod(foo, "b", 0, "ImagePullBackOff")}
+			},
+			wantHealth:   samplev1alpha1.FooHealthStatus{Restarts: 3, CrashLoopBackOffPods: 1, ImagePullErrorPods: 1},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionTrue, Reason: reasonUnhealthyPods, Message: "1 pods in CrashLoopBackOff and 1 pods failing to pull images"},
+		},
+		{
+			name:   "tolerated unhealthy pods",
+			health: &samplev1alpha1.FooHealth{MaxUnhealthyPods: pointer.Int32(1)},
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 0, "ErrImagePull"), newPod(foo, "b", 0, "")}
+			},
+			wantHealth:   samplev1alpha1.FooHealthStatus{ImagePullErrorPods: 1},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionFalse, Reason: reasonHealthy, Message: "pods are healthy"},
+		},
+		{
+			name:   "too many restarts",
+			health: &samplev1alpha1.FooHealth{MaxRestarts: pointer.Int32(2)},
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 2, ""), newPod(foo, "b", 1, "")}
+			},
+			wantHealth:   samplev1alpha1.FooHealthStatus{Restarts: 3},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionTrue, Reason: reasonTooManyRestarts, Message: "3 restarts exceed 2"},
+		},
+		{
+			name:   "auto pause",
+			health: &samplev1alpha1.FooHealth{AutoPause: true},
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 3, waitingCrashLoopBackOff)}
+			},
+			wantPaused:   pointer.Bool(true),
+			wantHealth:   samplev1alpha1.FooHealthStatus{Restarts: 3, CrashLoopBackOffPods: 1},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionTrue, Reason: reasonUnhealthyPods, Message: "1 pods in CrashLoopBackOff and 0 pods failing to pull images"},
+		},
+		{
+			name: "no auto pause",
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 3, waitingCrashLoopBackOff)}
+			},
+			wantHealth:   samplev1alpha1.FooHealthStatus{Restarts: 3, CrashLoopBackOffPods: 1},
+			wantDegraded: metav1.Condition{Status: metav1.ConditionTrue, Reason: reasonUnhealthyPods, Message: "1 pods in CrashLoopBackOff and 0 pods failing to pull images"},
+		},
+		{
+			name:   "auto resume",
+			health: &samplev1alpha1.FooHealth{AutoPause: true},
+			pods: func(foo *samplev1alpha1.Foo) []*corev1.Pod {
+				return []*corev1.Pod{newPod(foo, "a", 0, "")}
+			},
+			paused:       true,
+			owned:        true,
+			wantPaused:   pointer.Bool(false),
+			wantDegraded: metav1.Condition{Status: metav1.ConditionFalse, Reason: reasonHealthy, Message: "pods are healthy"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(4))
+			foo.Spec.Health = tc.health
+			d := newRollingDeployment(foo, 2)
+			d.Spec.Paused = tc.paused
+			if tc.owned {
+				d.Annotations = map[string]string{rolloutPausedAnnotation: "true"}
+			}
+
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			f.podLister = append(f.podLister, tc.pods(foo)...)
+
+			if tc.wantPaused != nil {
+				d = d.DeepCopy()
+				d.Spec.Paused = *tc.wantPaused
+				d.Annotations = map[string]string{}
+				if *tc.wantPaused {
+					d.Annotations[rolloutPausedAnnotation] = "true"
+				}
+				f.expectUpdateDeploymentAction(d)
+			}
+			f.expectUpdateFooStatusAction(foo, d)
+			degraded := tc.wantDegraded
+			degraded.Type = ConditionTypeDegraded
+			degraded.ObservedGeneration = foo.Generation
+			health := tc.wantHealth
+			status := f.lastExpectedFooStatus()
+			status.Health = &health
+			meta.SetStatusCondition(&status.Conditions, degraded)
+			meta.SetStatusCondition(&status.Conditions, newReadyCondition(foo, d, degraded))
+			f.expectEvent(corev1.EventTypeNormal, SuccessSynced, MessageResourceSynced)
+
+			f.run(getRef(foo))
+		})
+	}
+}
+
+// TestRetryBudget checks a failing Foo is put back on the workqueue with the
+// rate limiter until the retry budget is exhausted, and then dropped with the
+// ReconcileFailed Event and condition.
+func TestRetryBudget(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		maxRetries int
+		attempts   int
+		dropped    bool
+	}{
+		{name: "dropped", maxRetries: 2, attempts: 3, dropped: true},
+		{name: "retried forever", maxRetries: 0, attempts: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			f.opts.MaxRetries = tc.maxRetries
+			f.opts.Clock = testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			foo := newFoo("test", pointer.Int32(1))
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			c, _, _ := f.newController()
+			f.kubeclient.PrependReactor("create", "deployments", func(core.Action) (bool, runtime.Object, error) {
+				return true, nil, fmt.Errorf("injected error")
+			})
+
+			key := getRef(foo)
+			for attempt := 1; attempt <= tc.attempts; attempt++ {
+				c.processWorkItem(ctx, key)
+				want := attempt
+				if tc.dropped && attempt == tc.attempts {
+					want = 0
+				}
+				if got := c.workqueue.NumRequeues(key); got != want {
+					t.Errorf("attempt %d: expected %d requeues, got %d", attempt, want, got)
+				}
+			}
+
+			for i := 0; i < tc.attempts; i++ {
+				f.expectCreateDeploymentAction(newDeployment(foo))
+			}
+			if tc.dropped {
+				fooCopy := foo.DeepCopy()
+				fooCopy.Status.Conditions = []metav1.Condition{{
+					Type:               ConditionTypeReconcileFailed,
+					Status:             metav1.ConditionTrue,
+					ObservedGeneration: foo.Generation,
+					Reason:             ReconcileFailed,
+					Message:            "injected error",
+				}}
+				f.actions = append(f.actions, core.NewUpdateSubresourceAction(schema.GroupVersionResource{Resource: "foos"}, "status", foo.Namespace, fooCopy))
+				f.expectEvent(corev1.EventTypeWarning, ReconcileFailed, MessageReconcileFailed, tc.maxRetries+1, "injected error")
+			}
+			f.checkActions()
+			f.checkEvents()
+		})
+	}
+}
+
+// TestHandleResult checks the key is forgotten or put back on the workqueue
+// by the result and the error of its reconcile.
+func TestHandleResult(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		result Result
+		err    error
+		// failures is the number of the failures of the key before.
+		failures     int
+		wantRequeues int
+		// wantAfter is the delay after which the key is put back on the
+		// workqueue, or 0 if it's not.
+		wantAfter  time.Duration
+		wantResult string
+	}{
+		{name: "success", failures: 2, wantResult: reconcileResultSuccess},
+		{name: "requeue", result: Result{Requeue: true}, failures: 2, wantRequeues: 3, wantAfter: 4 * time.Second, wantResult: reconcileResultRequeue},
+		{name: "requeue after", result: Result{RequeueAfter: time.Minute}, failures: 2, wantAfter: time.Minute, wantResult: reconcileResultRequeueAfter},
+		{name: "requeue after over requeue", result: Result{Requeue: true, RequeueAfter: time.Minute}, wantAfter: time.Minute, wantResult: reconcileResultRequeueAfter},
+		{name: "error", err: fmt.Errorf("transient"), wantRequeues: 1, wantAfter: time.Second, wantResult: reconcileResultError},
+		{name: "error over result", result: Result{RequeueAfter: time.Minute}, err: fmt.Errorf("transient"), failures: 1, wantRequeues: 2, wantAfter: 2 * time.Second, wantResult: reconcileResultError},
+		{name: "terminal error", err: newTerminalError(fmt.Errorf("invalid")), failures: 2, wantResult: reconcileResultTerminalError},
+		{name: "wrapped terminal error", err: fmt.Errorf("sync: %w", newTerminalError(fmt.Errorf("invalid"))), wantResult: reconcileResultTerminalError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			clock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			c, _, _ := f.newController()
+			// The delayed items are released synchronously as in the
+			// simulation.
+			delaying := &simDelayingQueue{
+				Interface: workqueue.NewWithConfig(workqueue.QueueConfig{Clock: clock}),
+				clock:     clock,
+			}
+			c.workqueue.ShutDown()
+			c.workqueue = newFooQueueFrom(workqueue.NewRateLimitingQueueWithConfig(
+				NewRateLimiter(RateLimiterOptions{BaseDelay: time.Second, MaxDelay: time.Hour, QPS: 100, Burst: 100}, clock),
+				workqueue.RateLimitingQueueConfig{Clock: clock, DelayingQueue: delaying},
+			))
+			t.Cleanup(c.workqueue.ShutDown)
+
+			key := types.NamespacedName{Namespace: metav1.NamespaceDefault, Name: "test"}
+			for i := 0; i < tc.failures; i++ {
+				c.workqueue.AddRateLimited(key)
+			}
+			delaying.waiting = nil
+			before := counterValue(t, reconcileTotal.WithLabelValues(tc.wantResult))
+
+			c.handleResult(ctx, key, tc.result, tc.err)
+
+			if got := c.workqueue.NumRequeues(key); got != tc.wantRequeues {
+				t.Errorf("Expected %d requeues, got %d", tc.wantRequeues, got)
+			}
+			var after time.Duration
+			if len(delaying.waiting) > 0 {
+				after = delaying.waiting[0].readyAt.Sub(clock.Now())
+			}
+			if after != tc.wantAfter || len(delaying.waiting) > 1 || c.workqueue.Len() != 0 {
+				t.Errorf("Expected the key to be put back after %v, got %+v and %d items", tc.wantAfter, delaying.waiting, c.workqueue.Len())
+			}
+			if got := counterValue(t, reconcileTotal.WithLabelValues(tc.wantResult)) - before; got != 1 {
+				t.Errorf("Expected the %s result to be counted once, got %v", tc.wantResult, got)
+			}
+		})
+	}
+}
+
+// counterValue returns the value of the counter.
+func counterValue(t *testing.T, counter prometheus.Counter) float64 {
+	var m dto.Metric
+	if err := counter.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+// TestSyncHandlerResult checks the Foo is requeued after the interval while
+// the rollout of its Deployment is in progress.
+func TestSyncHandlerResult(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		updated int32
+		want    Result
+	}{
+		{name: "rollout in progress", updated: 2, want: Result{RequeueAfter: rolloutRequeueInterval}},
+		{name: "rollout complete", updated: 4},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(4))
+			d := newRollingDeployment(foo, tc.updated)
+			d.Status.Replicas = 4
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			f.deploymentLister = append(f.deploymentLister, d)
+			f.kubeobjects = append(f.kubeobjects, d)
+			c, _, _ := f.newController()
+
+			result, err := c.syncHandler(ctx, getRef(foo))
+			if err != nil {
+				t.Fatalf("error syncing foo: %v", err)
+			}
+			if result != tc.want {
+				t.Errorf("Expected %+v, got %+v", tc.want, result)
+			}
+		})
+	}
+}
+
+// TestTriggers checks the Foo is enqueued once with the triggers of the event
+// handlers, and the span of the reconcile has the triggers and is linked to
+// the spans of the enqueues.
+func TestTriggers(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	foo.ResourceVersion = "1"
+	updated := foo.DeepCopy()
+	updated.ResourceVersion = "2"
+	updated.Generation = 2
+	ownerRefs := []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))}
+	d := newDeployment(foo)
+	d.ResourceVersion = "1"
+	updatedDeployment := d.DeepCopy()
+	updatedDeployment.ResourceVersion = "2"
+	pdb := &policyv1.PodDisruptionBudget{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace, OwnerReferences: ownerRefs}}
+	hpa := &autoscalingv2.HorizontalPodAutoscaler{ObjectMeta: metav1.ObjectMeta{Name: d.Name, Namespace: d.Namespace, OwnerReferences: ownerRefs}}
+	pod := newPod(foo, "test-pod", 0, "")
+
+	for _, tc := range []struct {
+		name string
+		// enqueue calls the event handlers, and returns the number of the
+		// enqueues.
+		enqueue func(c *Controller) int
+		want    []Trigger
+	}{
+		{name: "foo add", want: []Trigger{TriggerFooAdd}, enqueue: func(c *Controller) int {
+			c.fooEventHandler().OnAdd(foo, false)
+			return 1
+		}},
+		{name: "foo update", want: []Trigger{TriggerFooUpdate}, enqueue: func(c *Controller) int {
+			c.fooEventHandler().OnUpdate(foo, updated)
+			return 1
+		}},
+		{name: "resync", want: []Trigger{TriggerResync}, enqueue: func(c *Controller) int {
+			c.fooEventHandler().OnUpdate(foo, foo)
+			return 1
+		}},
+		{name: "foo delete", want: []Trigger{TriggerFooDelete}, enqueue: func(c *Controller) int {
+			c.fooEventHandler().OnDelete(cache.DeletedFinalStateUnknown{Key: "default/test", Obj: foo})
+			return 1
+		}},
+		{name: "deployment change", want: []Trigger{TriggerDeploymentChange}, enqueue: func(c *Controller) int {
+			c.ownedObjectEventHandler().OnUpdate(d, updatedDeployment)
+			return 1
+		}},
+		{name: "pod disruption budget change", want: []Trigger{TriggerPodDisruptionBudgetChange}, enqueue: func(c *Controller) int {
+			c.ownedObjectEventHandler().OnAdd(pdb, false)
+			return 1
+		}},
+		{name: "horizontal pod autoscaler change", want: []Trigger{TriggerHorizontalPodAutoscalerChange}, enqueue: func(c *Controller) int {
+			c.ownedObjectEventHandler().OnDelete(hpa)
+			return 1
+		}},
+		{name: "pod change", want: []Trigger{TriggerPodChange}, enqueue: func(c *Controller) int {
+			c.podEventHandler().OnAdd(pod, false)
+			return 1
+		}},
+		{name: "shard rebalance", want: []Trigger{TriggerShardRebalance}, enqueue: func(c *Controller) int {
+			c.EnqueueShard()
+			return 1
+		}},
+		{name: "deduplicated", want: []Trigger{TriggerFooAdd, TriggerDeploymentChange, TriggerPodChange}, enqueue: func(c *Controller) int {
+			c.fooEventHandler().OnAdd(foo, false)
+			c.ownedObjectEventHandler().OnUpdate(d, updatedDeployment)
+			c.podEventHandler().OnAdd(pod, false)
+			c.ownedObjectEventHandler().OnDelete(updatedDeployment)
+			return 4
+		}},
+		{name: "requeue", want: []Trigger{TriggerRequeue}, enqueue: func(c *Controller) int {
+			c.workqueue.Add(getRef(foo))
+			return 0
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			recorder := tracetest.NewSpanRecorder()
+			f.opts.TracerProvider = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			c, _, _ := f.newController()
+
+			enqueues := tc.enqueue(c)
+			if got := c.workqueue.Len(); got != 1 {
+				t.Fatalf("Expected the Foo to be enqueued once, got %d items", got)
+			}
+			c.processNextWorkItem(ctx)
+
+			var links []trace.SpanContext
+			var reconcile sdktrace.ReadOnlySpan
+			for _, span := range recorder.Ended() {
+				switch span.Name() {
+				case "Enqueue":
+					links = append(links, span.SpanContext())
+				case "Reconcile":
+					reconcile = span
+				}
+			}
+			if len(links) != enqueues {
+				t.Errorf("Expected %d Enqueue spans, got %d", enqueues, len(links))
+			}
+			if reconcile == nil {
+				t.Fatal("Expected a Reconcile span")
+			}
+			var gotLinks []trace.SpanContext
+			for _, link := range reconcile.Links() {
+				gotLinks = append(gotLinks, link.SpanContext)
+			}
+			if diff := cmp.Diff(links, gotLinks, cmp.Comparer(func(a, b trace.SpanContext) bool { return a.Equal(b) })); diff != "" {
+				t.Errorf("unexpected links (-want +got):\n%s", diff)
+			}
+			var gotTriggers []string
+			for _, attr := range reconcile.Attributes() {
+				if attr.Key == "trigger" {
+					gotTriggers = attr.Value.AsStringSlice()
+				}
+			}
+			if diff := cmp.Diff(triggerStrings(tc.want), gotTriggers); diff != "" {
+				t.Errorf("unexpected triggers (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestFooUpdatePredicates(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	foo.ResourceVersion = "1"
+	foo.Labels = map[string]string{"team": "a"}
+
+	for _, tc := range []struct {
+		name string
+		// update changes the new Foo from the old one, which it may
+		// change too.
+		update func(old, new *samplev1alpha1.Foo)
+		want   bool
+	}{
+		{name: "status", update: func(old, new *samplev1alpha1.Foo) {
+			new.Status.AvailableReplicas = 1
+			new.Status.ObservedGeneration = new.Generation
+		}},
+		{name: "spec", want: true, update: func(old, new *samplev1alpha1.Foo) {
+			new.Spec.Replicas = pointer.Int32(2)
+			new.Generation++
+		}},
+		{name: "labels", want: true, update: func(old, new *samplev1alpha1.Foo) {
+			new.Labels["team"] = "b"
+		}},
+		{name: "empty labels", update: func(old, new *samplev1alpha1.Foo) {
+			old.Labels = map[string]string{}
+			new.Labels = nil
+		}},
+		{name: "annotations", want: true, update: func(old, new *samplev1alpha1.Foo) {
+			new.Annotations = map[string]string{RollbackAnnotation: "1"}
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			old := foo.DeepCopy()
+			updated := foo.DeepCopy()
+			updated.ResourceVersion = "2"
+			tc.update(old, updated)
+			if got := anyPredicate(fooUpdatePredicates, old, updated); got != tc.want {
+				t.Errorf("Expected the predicates to return %v, got %v", tc.want, got)
+			}
+
+			f := newFixture(t)
+			c, _, _ := f.newController()
+			c.fooEventHandler().OnUpdate(old, updated)
+			want := 0
+			if tc.want {
+				want = 1
+			}
+			if got := c.workqueue.Len(); got != want {
+				t.Errorf("Expected %d items enqueued, got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestFooNotFound(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+
+	f.run(getRef(foo))
+}
+
+func TestEmptyDeploymentName(t *testing.T) {
+	f := newFixture(t)
+	foo := newFoo("test", pointer.Int32(1))
+	foo.Spec.DeploymentName = ""
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+
+	f.run(getRef(foo))
+}
+
+// TestFlakyAPI runs syncHandler until it succeeds while the API server fails,
+// throttles and slows down a share of the requests, and times out creations
+// which are done. The informer cache is refreshed before a random share of
+// the attempts, so some run with a stale cache. The Foo must converge with a
+// single Deployment created once.
+func TestFlakyAPI(t *testing.T) {
+	const maxAttempts = 100
+	for seed := int64(0); seed < 50; seed++ {
+		seed := seed
+		t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+			_, ctx := ktesting.NewTestContext(t)
+			f := newFixture(t)
+			foo := newFoo("test", pointer.Int32(2))
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			c, i, k8sI := f.newController()
+
+			faults := faultinjection.New(seed, clock.RealClock{})
+			faults.Add(
+				faultinjection.Fault{Verb: "create", Resource: "deployments", Error: faultinjection.Timeout, Commit: true, Probability: 0.5, Count: 2},
+				faultinjection.Fault{Resource: "deployments", Error: faultinjection.Conflict, Probability: 0.3, Count: 5},
+				faultinjection.Fault{Error: faultinjection.TooManyRequests, Probability: 0.2, Count: 5},
+				faultinjection.Fault{Verb: "update", Resource: "foos", Subresource: "status", Error: faultinjection.InternalError, Probability: 0.5, Count: 5},
+				faultinjection.Fault{Verb: "get", Delay: time.Millisecond, Probability: 0.5},
+			)
+			faults.Install(f.client)
+			faults.Install(f.kubeclient)
+
+			rng := rand.New(rand.NewSource(seed))
+			for attempt := 0; ; attempt++ {
+				if attempt == maxAttempts {
+					t.Fatalf("Foo not synced after %d attempts with %d injected faults", maxAttempts, len(faults.Injected()))
+				}
+				if rng.Intn(2) == 0 {
+					f.refreshCache(i, k8sI)
+				}
+				if _, err := c.syncHandler(ctx, getRef(foo)); err == nil {
+					break
+				}
+			}
+
+			deployments, err := f.kubeclient.Tracker().List(deploymentsResource, appsv1.SchemeGroupVersion.WithKind("Deployment"), foo.Namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			items := deployments.(*appsv1.DeploymentList).Items
+			if len(items) != 1 || !metav1.IsControlledBy(&items[0], foo) || *items[0].Spec.Replicas != 2 {
+				t.Fatalf("Expected a single Deployment with 2 replicas controlled by the Foo, got %+v", items)
+			}
+			created := 0
+			for len(f.recorder.Events) > 0 {
+				if strings.HasPrefix(<-f.recorder.Events, corev1.EventTypeNormal+" "+Created+" ") {
+					created++
+				}
+			}
+			if created > 1 {
+				t.Errorf("Expected the Deployment to be created once, got %d Created events", created)
+			}
+		})
+	}
+}
+
+// TestDryRunClient checks a reconcile in the client dry run makes no writes
+// and no Events, and reports the creation of the Deployment and the update of
+// the status of the Foo.
+func TestDryRunClient(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	f.opts.DryRun = DryRunClient
+	foo := newFoo("test", pointer.Int32(1))
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	c, _, _ := f.newController()
+
+	key := getRef(foo)
+	if _, err := c.syncHandler(withDryRunKey(ctx, key), key); err != nil {
+		t.Fatalf("error syncing foo: %v", err)
+	}
+	c.plan.end(key)
+
+	for _, action := range append(f.kubeclient.Actions(), f.client.Actions()...) {
+		if verb := action.GetVerb(); verb != "get" && verb != "list" && verb != "watch" {
+			t.Errorf("Unexpected action %s %s in the dry run", verb, action.GetResource().Resource)
+		}
+	}
+	f.checkEvents()
+
+	pending := c.PendingChanges()
+	if len(pending) != 1 || pending[0].Namespace != foo.Namespace || pending[0].Name != foo.Name {
+		t.Fatalf("Expected the pending changes of %s, got %+v", key, pending)
+	}
+	changes := pending[0].Changes
+	if len(changes) != 2 {
+		t.Fatalf("Expected 2 changes, got %+v", changes)
+	}
+	if want := (Change{Verb: changeVerbCreate, Kind: "Deployment", Name: foo.Spec.DeploymentName}); !reflect.DeepEqual(changes[0], want) {
+		t.Errorf("Expected %+v, got %+v", want, changes[0])
+	}
+	if changes[1].Verb != changeVerbUpdate || changes[1].Kind != "Foo" || changes[1].Subresource != "status" {
+		t.Errorf("Expected an update of the status of the Foo, got %+v", changes[1])
+	}
+	var observedGeneration bool
+	for _, field := range changes[1].Fields {
+		observedGeneration = observedGeneration || field.Path == "status.observedGeneration"
+	}
+	if !observedGeneration {
+		t.Errorf("Expected a change of status.observedGeneration, got %+v", changes[1].Fields)
+	}
+}
+
+// TestDryRunRollback checks the rollback in the client dry run reports one
+// update of the Deployment with the changes of the rollback and the scaling,
+// and the same changes when the Foo is reconciled again.
+func TestDryRunRollback(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	f := newFixture(t)
+	f.opts.DryRun = DryRunClient
+	foo := newFoo("test", pointer.Int32(2))
+	previous := newDeployment(foo)
+	previous.UID = "deployment-uid"
+	d := previous.DeepCopy()
+	d.Spec.Replicas = pointer.Int32(1)
+	d.Spec.Template.Spec.Containers[0].Image = "nginx:broken"
+	foo.Annotations = map[string]string{RollbackAnnotation: ""}
+	foo.Status.ObservedGeneration = foo.Generation
+	foo.Status.TemplateHash = computeTemplateHash(&d.Spec.Template)
+	foo.Status.PreviousTemplateHash = computeTemplateHash(&previous.Spec.Template)
+	rs := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            d.Name + "-previous",
+			Namespace:       d.Namespace,
+			Labels:          previous.Spec.Template.Labels,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(d, appsv1.SchemeGroupVersion.WithKind("Deployment"))},
+		},
+		Spec: appsv1.ReplicaSetSpec{Template: previous.Spec.Template},
+	}
+
+	f.fooLister = append(f.fooLister, foo)
+	f.objects = append(f.objects, foo)
+	f.deploymentLister = append(f.deploymentLister, d)
+	f.kubeobjects = append(f.kubeobjects, d, rs)
+	c, _, _ := f.newController()
+
+	key := getRef(foo)
+	for reconcile := 0; reconcile < 2; reconcile++ {
+		if _, err := c.syncHandler(withDryRunKey(ctx, key), key); err != nil {
+			t.Fatalf("error syncing foo: %v", err)
+		}
+		c.plan.end(key)
+
+		pending := c.PendingChanges()
+		if len(pending) != 1 {
+			t.Fatalf("Expected the pending changes of %s, got %+v", key, pending)
+		}
+		var changes, deploymentFields []string
+		for _, change := range pending[0].Changes {
+			changes = append(changes, strings.TrimSuffix(change.Verb+" "+change.Kind+" "+change.Subresource, " "))
+			if change.Kind == "Deployment" {
+				for _, field := range change.Fields {
+					deploymentFields = append(deploymentFields, field.Path)
+				}
+			}
+		}
+		if diff := cmp.Diff([]string{"update Deployment", "update Foo", "update Foo status"}, changes); diff != "" {
+			t.Errorf("unexpected changes of reconcile %d (-want +got):\n%s", reconcile, diff)
+		}
+		if diff := cmp.Diff([]string{"spec.replicas", "spec.template.spec.containers[0].image"}, deploymentFields); diff != "" {
+			t.Errorf("unexpected changes of the Deployment of reconcile %d (-want +got):\n%s", reconcile, diff)
+		}
+	}
+}
+
+func TestDiffObjects(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	old := newDeployment(foo)
+	new := old.DeepCopy()
+	new.ResourceVersion = "2"
+	new.Spec.Replicas = pointer.Int32(3)
+	new.Labels["example.com/track"] = "stable"
+	new.Status.Replicas = 3
+
+	changes, err := diffObjects(old, new, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []FieldChange{
+		{Path: `metadata.labels["example.com/track"]`, New: "stable"},
+		{Path: "spec.replicas", Old: int64(1), New: int64(3)},
+	}
+	if diff := cmp.Diff(want, changes); diff != "" {
+		t.Errorf("unexpected changes (-want +got):\n%s", diff)
+	}
+
+	changes, err = diffObjects(old, new, "status")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = []FieldChange{{Path: "status.replicas", New: int64(3)}}
+	if diff := cmp.Diff(want, changes); diff != "" {
+		t.Errorf("unexpected status changes (-want +got):\n%s", diff)
+	}
+}
+
+// TestEventPolicy checks the Normal Events identical to the last Event are
+// dropped, and the repeats of a warning are counted in the window and
+// emitted after it.
+func TestEventPolicy(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	emit := func(eventtype, reason string) func(c *Controller) {
+		return func(c *Controller) {
+			c.event(context.Background(), foo, eventtype, reason, "message")
+		}
+	}
+	flush := func(c *Controller) { c.events.Flush() }
+	forget := func(c *Controller) { c.events.Forget(getRef(foo)) }
+	type step struct {
+		// elapse is the time passed before the step.
+		elapse time.Duration
+		do     func(c *Controller)
+		// want are the Events emitted by the step.
+		want []string
+	}
+	for _, tc := range []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "normal dedup",
+			steps: []step{
+				{do: emit(corev1.EventTypeNormal, SuccessSynced), want: []string{"Normal Synced message"}},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced)},
+				{do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced), want: []string{"Normal Synced message"}},
+			},
+		},
+		{
+			name: "repeat after the window",
+			steps: []step{
+				{do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+				{elapse: 8 * time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message (repeated 3 times in the last 10m0s)"}},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+			},
+		},
+		{
+			name: "flush after the window",
+			steps: []step{
+				{do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+				{elapse: time.Minute, do: flush},
+				{elapse: 7 * time.Minute, do: flush, want: []string{"Warning ReconcileFailed message (repeated 2 times in the last 10m0s)"}},
+				{do: flush},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+			},
+		},
+		{
+			// A warning persisting across the resyncs, such as
+			// CanarySkipped, doesn't make Synced emitted again while it's
+			// aggregated.
+			name: "normal dedup with repeated warning",
+			steps: []step{
+				{do: emit(corev1.EventTypeWarning, CanarySkipped), want: []string{"Warning CanarySkipped message"}},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced), want: []string{"Normal Synced message"}},
+				{elapse: 30 * time.Second, do: emit(corev1.EventTypeWarning, CanarySkipped)},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced)},
+				{elapse: 30 * time.Second, do: emit(corev1.EventTypeWarning, CanarySkipped)},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced)},
+				{elapse: 30 * time.Second, do: emit(corev1.EventTypeWarning, CanarySkipped)},
+				{do: emit(corev1.EventTypeNormal, SuccessSynced)},
+			},
+		},
+		{
+			name: "flush without repeats",
+			steps: []step{
+				{do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+				{elapse: 10 * time.Minute, do: flush},
+			},
+		},
+		{
+			name: "forget",
+			steps: []step{
+				{do: emit(corev1.EventTypeWarning, ReconcileFailed), want: []string{"Warning ReconcileFailed message"}},
+				{elapse: time.Minute, do: emit(corev1.EventTypeWarning, ReconcileFailed)},
+				{do: forget, want: []string{"Warning ReconcileFailed message (repeated 1 times in the last 10m0s)"}},
+				{elapse: 10 * time.Minute, do: flush},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			f := newFixture(t)
+			clock := testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			f.opts.Clock = clock
+			f.opts.Events = EventPolicyOptions{AggregationWindow: 10 * time.Minute}
+			c, _, _ := f.newController()
+			for i, step := range tc.steps {
+				clock.Step(step.elapse)
+				step.do(c)
+				var events []string
+				for len(f.recorder.Events) > 0 {
+					events = append(events, <-f.recorder.Events)
+				}
+				if diff := cmp.Diff(step.want, events); diff != "" {
+					t.Errorf("unexpected events of step %d (-want +got):\n%s", i, diff)
+				}
+			}
+		})
+	}
+}
+
+// TestReload checks the reloaded options apply to the next reconciles.
+func TestReload(t *testing.T) {
+	f := newFixture(t)
+	f.opts.Events = EventPolicyOptions{QPS: 0.1, Burst: 1}
+	foo := newFoo("test", pointer.Int32(1))
+	c, _, _ := f.newController()
+	key := getRef(foo)
+
+	c.eventf(context.Background(), foo, corev1.EventTypeWarning, ReconcileFailed, "first")
+	c.eventf(context.Background(), foo, corev1.EventTypeWarning, ReconcileFailed, "dropped beyond the burst")
+	c.Reload(ControllerOptions{MaxRetries: 3, LogSampleQPS: 1, LogSampleBurst: 1, Events: EventPolicyOptions{QPS: 0.1, Burst: 2}})
+	c.eventf(context.Background(), foo, corev1.EventTypeWarning, ReconcileFailed, "emitted with the new burst")
+	f.expectEvent(corev1.EventTypeWarning, ReconcileFailed, "first")
+	f.expectEvent(corev1.EventTypeWarning, ReconcileFailed, "emitted with the new burst")
+	f.checkEvents()
+
+	if got := c.maxRetries.Load(); got != 3 {
+		t.Errorf("Expected 3 max retries, got %d", got)
+	}
+	logger := ktesting.NewLogger(t, ktesting.DefaultConfig)
+	if _, sampledOut := c.logSampler.Sample(key, logger).GetSink().(errorOnlySink); sampledOut {
+		t.Error("Expected the first reconcile to be logged")
+	}
+	if _, sampledOut := c.logSampler.Sample(key, logger).GetSink().(errorOnlySink); !sampledOut {
+		t.Error("Expected the second reconcile to be sampled out")
+	}
+}
+
+// refreshCache replaces the objects in the informer indexers with the objects
+// of the fake clientsets.
+func (f *fixture) refreshCache(i informers.SharedInformerFactory, k8sI kubeinformers.SharedInformerFactory) {
+	foos, err := f.client.Tracker().List(foosResource, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"), metav1.NamespaceAll)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	deployments, err := f.kubeclient.Tracker().List(deploymentsResource, appsv1.SchemeGroupVersion.WithKind("Deployment"), metav1.NamespaceAll)
+	if err != nil {
+		f.t.Fatal(err)
+	}
+	for _, refresh := range []struct {
+		indexer cache.Indexer
+		list    runtime.Object
+	}{
+		{indexer: i.Example().V1alpha1().Foos().Informer().GetIndexer(), list: foos},
+		{indexer: k8sI.Apps().V1().Deployments().Informer().GetIndexer(), list: deployments},
+	} {
+		objects, err := meta.ExtractList(refresh.list)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		var transformed []interface{}
+		for _, obj := range objects {
+			obj, err := transformObject(obj)
+			if err != nil {
+				f.t.Fatal(err)
+			}
+			transformed = append(transformed, obj)
+		}
+		if err := refresh.indexer.Replace(transformed, ""); err != nil {
+			f.t.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkSyncHandler measures a reconcile of a Foo whose Deployment is in
+// sync, has to be scaled or has to be created.
+func BenchmarkSyncHandler(b *testing.B) {
+	for _, bm := range []struct {
+		name string
+		// deployment returns the cached Deployment of the Foo, or nil if
+		// there's none.
+		deployment func(foo *samplev1alpha1.Foo) *appsv1.Deployment
+	}{
+		{name: "InSync", deployment: newDeployment},
+		{name: "Scale", deployment: func(foo *samplev1alpha1.Foo) *appsv1.Deployment {
+			d := newDeployment(foo)
+			d.Spec.Replicas = pointer.Int32(*foo.Spec.Replicas + 1)
+			return d
+		}},
+		{name: "Create", deployment: func(*samplev1alpha1.Foo) *appsv1.Deployment { return nil }},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			ctx := klog.NewContext(context.Background(), logr.Discard())
+			f := newFixture(b)
+			foo := newFoo("test", pointer.Int32(1))
+			f.fooLister = append(f.fooLister, foo)
+			f.objects = append(f.objects, foo)
+			if d := bm.deployment(foo); d != nil {
+				f.deploymentLister = append(f.deploymentLister, d)
+				f.kubeobjects = append(f.kubeobjects, d)
+			}
+			c, _, _ := f.newController()
+
+			b.ReportAllocs()
+			b.ResetTimer()
+			for n := 0; n < b.N; n++ {
+				if _, err := c.syncHandler(ctx, getRef(foo)); err != nil {
+					b.Fatal(err)
+				}
+
+				b.StopTimer()
+				// The created Deployment is deleted so the next reconcile
+				// creates it again, and the recorded actions and Events
+				// are dropped.
+				if bm.name == "Create" {
+					if err := f.kubeclient.Tracker().Delete(deploymentsResource, foo.Namespace, foo.Spec.DeploymentName); err != nil {
+						b.Fatal(err)
+					}
+				}
+				f.client.ClearActions()
+				f.kubeclient.ClearActions()
+				for len(f.recorder.Events) > 0 {
+					<-f.recorder.Events
+				}
+				b.StartTimer()
+			}
+		})
+	}
+}
+
+func BenchmarkNewDeployment(b *testing.B) {
+	foo := newFoo("test", pointer.Int32(1))
+	b.ReportAllocs()
+	for n := 0; n < b.N; n++ {
+		newDeployment(foo)
+	}
+}
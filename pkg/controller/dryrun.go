@@ -0,0 +1,521 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+	examplev1alpha1 "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/typed/example.com/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	typedappsv1 "k8s.io/client-go/kubernetes/typed/apps/v1"
+	typedautoscalingv2 "k8s.io/client-go/kubernetes/typed/autoscaling/v2"
+	typedpolicyv1 "k8s.io/client-go/kubernetes/typed/policy/v1"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// The dry run modes, which are defined with the configuration.
+const (
+	// DryRunNone makes the writes of the controller.
+	DryRunNone = configv1alpha1.DryRunNone
+	// DryRunServer sends the writes of the controller with DryRun: All, so
+	// the API server validates and defaults them without persisting them.
+	DryRunServer = configv1alpha1.DryRunServer
+	// DryRunClient skips the writes of the controller. Creates of existing
+	// objects and updates of stale objects fail as they would on the API
+	// server.
+	DryRunClient = configv1alpha1.DryRunClient
+)
+
+// Verbs of a Change.
+const (
+	changeVerbCreate = "create"
+	changeVerbUpdate = "update"
+	changeVerbDelete = "delete"
+)
+
+// PendingChanges is the changes the last reconcile of a Foo would have made
+// without the dry run.
+type PendingChanges struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// ReconciledAt is the time of the reconcile.
+	ReconciledAt time.Time `json:"reconciledAt"`
+	Changes      []Change  `json:"changes"`
+}
+
+// Change is a write of a reconcile.
+type Change struct {
+	Verb string `json:"verb"`
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// Subresource is "status" for an update of the status.
+	Subresource string `json:"subresource,omitempty"`
+	// Fields are the fields changed by an update.
+	Fields []FieldChange `json:"fields,omitempty"`
+}
+
+// FieldChange is the change of a field of an object, such as
+// "spec.replicas" or `metadata.labels["app.kubernetes.io/managed-by"]`. Old
+// or New is nil when the field is added or removed.
+type FieldChange struct {
+	Path string      `json:"path"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// dryRunPlan records the changes of the reconciles in a dry run. The changes
+// of a reconcile are published for the Foo when the reconcile ends, so the
+// report has the changes of the last reconcile of each Foo.
+type dryRunPlan struct {
+	mode  string
+	clock clock.Clock
+
+	mu sync.Mutex
+	// reconciling has the changes of the reconciles in progress.
+	reconciling map[types.NamespacedName][]Change
+	// pending has the changes of the last reconcile of the Foos with changes.
+	pending map[types.NamespacedName]*PendingChanges
+}
+
+func newDryRunPlan(mode string, clock clock.Clock) *dryRunPlan {
+	return &dryRunPlan{
+		mode:        mode,
+		clock:       clock,
+		reconciling: map[types.NamespacedName][]Change{},
+		pending:     map[types.NamespacedName]*PendingChanges{},
+	}
+}
+
+type dryRunKeyContextKey struct{}
+
+// withDryRunKey returns the context of the reconcile of the Foo, to which the
+// changes made with the context are recorded.
+func withDryRunKey(ctx context.Context, key types.NamespacedName) context.Context {
+	return context.WithValue(ctx, dryRunKeyContextKey{}, key)
+}
+
+// record records the change to the reconcile of the context and logs it. A
+// change replaces the earlier one of the same verb and object in the
+// reconcile, such as the update of the Deployment after a rollback, as the
+// later write is made on the result of the earlier one and has the fields
+// changed by both.
+func (p *dryRunPlan) record(ctx context.Context, change Change) {
+	logger := klog.FromContext(ctx)
+	logger.Info("Dry run: pending change", "mode", p.mode, "verb", change.Verb, "kind", change.Kind, "name", change.Name, "subresource", change.Subresource, "changes", change.Fields)
+	key, ok := ctx.Value(dryRunKeyContextKey{}).(types.NamespacedName)
+	if !ok {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changes := p.reconciling[key]
+	for i := range changes {
+		if changes[i].Verb == change.Verb && changes[i].Kind == change.Kind && changes[i].Name == change.Name && changes[i].Subresource == change.Subresource {
+			changes[i] = change
+			return
+		}
+	}
+	p.reconciling[key] = append(changes, change)
+}
+
+// end publishes the changes of the reconcile of the Foo.
+func (p *dryRunPlan) end(key types.NamespacedName) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	changes := p.reconciling[key]
+	delete(p.reconciling, key)
+	if len(changes) == 0 {
+		delete(p.pending, key)
+		return
+	}
+	p.pending[key] = &PendingChanges{
+		Namespace:    key.Namespace,
+		Name:         key.Name,
+		ReconciledAt: p.clock.Now(),
+		Changes:      changes,
+	}
+}
+
+// report returns the pending changes of the Foos sorted by namespace and name.
+func (p *dryRunPlan) report() []PendingChanges {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	report := make([]PendingChanges, 0, len(p.pending))
+	for _, changes := range p.pending {
+		report = append(report, *changes)
+	}
+	sort.Slice(report, func(i, j int) bool {
+		if report[i].Namespace != report[j].Namespace {
+			return report[i].Namespace < report[j].Namespace
+		}
+		return report[i].Name < report[j].Name
+	})
+	return report
+}
+
+// ServeHTTP serves the pending changes as JSON. The namespace and name query
+// parameters filter the Foos.
+func (p *dryRunPlan) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	namespace, name := r.URL.Query().Get("namespace"), r.URL.Query().Get("name")
+	foos := []PendingChanges{}
+	for _, changes := range p.report() {
+		if (namespace == "" || changes.Namespace == namespace) && (name == "" || changes.Name == name) {
+			foos = append(foos, changes)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(struct {
+		Mode string           `json:"mode"`
+		Foos []PendingChanges `json:"foos"`
+	}{Mode: p.mode, Foos: foos}); err != nil {
+		klog.FromContext(r.Context()).Error(err, "Failed to write the pending changes")
+	}
+}
+
+// PendingChanges returns the changes the last reconcile of each Foo would
+// have made, or nil if the controller isn't in a dry run.
+func (c *Controller) PendingChanges() []PendingChanges {
+	if c.plan == nil {
+		return nil
+	}
+	return c.plan.report()
+}
+
+// PendingChangesHandler returns the handler serving the pending changes as
+// JSON, or nil if the controller isn't in a dry run.
+func (c *Controller) PendingChangesHandler() http.Handler {
+	if c.plan == nil {
+		return nil
+	}
+	return c.plan
+}
+
+// dryRunCreate creates the object in the dry run. The object is returned as
+// is when the create is skipped.
+func dryRunCreate[T runtime.Object](ctx context.Context, p *dryRunPlan, kind string, obj T,
+	get func(context.Context, string, metav1.GetOptions) (T, error),
+	create func(context.Context, T, metav1.CreateOptions) (T, error),
+	opts metav1.CreateOptions) (T, error) {
+	var zero T
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return zero, err
+	}
+	result := obj.DeepCopyObject().(T)
+	if p.mode == DryRunClient {
+		_, err := get(ctx, object.GetName(), metav1.GetOptions{})
+		switch {
+		case err == nil:
+			return zero, errors.NewAlreadyExists(resourceOf(obj), object.GetName())
+		case !errors.IsNotFound(err):
+			return zero, err
+		}
+	} else {
+		opts.DryRun = []string{metav1.DryRunAll}
+		if result, err = create(ctx, obj, opts); err != nil {
+			return zero, err
+		}
+	}
+	p.record(ctx, Change{Verb: changeVerbCreate, Kind: kind, Name: object.GetName()})
+	return result, nil
+}
+
+// dryRunUpdate updates the object or its status in the dry run and records
+// the fields changed from the live object. The object is returned as is when
+// the update is skipped.
+func dryRunUpdate[T runtime.Object](ctx context.Context, p *dryRunPlan, kind, subresource string, obj T,
+	get func(context.Context, string, metav1.GetOptions) (T, error),
+	update func(context.Context, T, metav1.UpdateOptions) (T, error),
+	opts metav1.UpdateOptions) (T, error) {
+	var zero T
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return zero, err
+	}
+	live, err := get(ctx, object.GetName(), metav1.GetOptions{})
+	if err != nil {
+		return zero, err
+	}
+	result := obj.DeepCopyObject().(T)
+	if p.mode == DryRunClient {
+		liveObject, err := meta.Accessor(live)
+		if err != nil {
+			return zero, err
+		}
+		if rv := object.GetResourceVersion(); rv != "" && rv != liveObject.GetResourceVersion() {
+			return zero, errors.NewConflict(resourceOf(obj), object.GetName(), fmt.Errorf("the object has been modified"))
+		}
+	} else {
+		opts.DryRun = []string{metav1.DryRunAll}
+		if result, err = update(ctx, obj, opts); err != nil {
+			return zero, err
+		}
+	}
+	fields, err := diffObjects(live, result, subresource)
+	if err != nil {
+		return zero, err
+	}
+	if len(fields) > 0 {
+		p.record(ctx, Change{Verb: changeVerbUpdate, Kind: kind, Name: object.GetName(), Subresource: subresource, Fields: fields})
+	}
+	return result, nil
+}
+
+// dryRunDelete deletes the object in the dry run.
+func dryRunDelete[T runtime.Object](ctx context.Context, p *dryRunPlan, kind, name string,
+	get func(context.Context, string, metav1.GetOptions) (T, error),
+	del func(context.Context, string, metav1.DeleteOptions) error,
+	opts metav1.DeleteOptions) error {
+	if p.mode == DryRunClient {
+		if _, err := get(ctx, name, metav1.GetOptions{}); err != nil {
+			return err
+		}
+	} else {
+		opts.DryRun = []string{metav1.DryRunAll}
+		if err := del(ctx, name, opts); err != nil {
+			return err
+		}
+	}
+	p.record(ctx, Change{Verb: changeVerbDelete, Kind: kind, Name: name})
+	return nil
+}
+
+// resourceOf returns the resource of the object for the errors of the
+// skipped writes.
+func resourceOf(obj runtime.Object) schema.GroupResource {
+	switch obj.(type) {
+	case *appsv1.Deployment:
+		return appsv1.Resource("deployments")
+	case *policyv1.PodDisruptionBudget:
+		return policyv1.Resource("poddisruptionbudgets")
+	case *autoscalingv2.HorizontalPodAutoscaler:
+		return autoscalingv2.Resource("horizontalpodautoscalers")
+	default:
+		return samplev1alpha1.Resource("foos")
+	}
+}
+
+// diffObjects returns the changes of the fields from the old object to the
+// new one, only in the status for the status subresource and out of the
+// status otherwise. The resource version and the managed fields are ignored.
+func diffObjects(old, new runtime.Object, subresource string) ([]FieldChange, error) {
+	var objects [2]map[string]interface{}
+	for i, obj := range []runtime.Object{old, new} {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+		if metadata, ok := u["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "resourceVersion")
+			delete(metadata, "managedFields")
+		}
+		if subresource == "status" {
+			u = map[string]interface{}{"status": u["status"]}
+		} else {
+			delete(u, "status")
+		}
+		objects[i] = u
+	}
+	var changes []FieldChange
+	diffValues("", objects[0], objects[1], &changes)
+	return changes, nil
+}
+
+// diffValues appends the changes from the old value to the new one at the
+// path. Maps and lists of the same length are compared element by element.
+func diffValues(path string, old, new interface{}, changes *[]FieldChange) {
+	switch o := old.(type) {
+	case map[string]interface{}:
+		if n, ok := new.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(o)+len(n))
+			for key := range o {
+				keys = append(keys, key)
+			}
+			for key := range n {
+				if _, ok := o[key]; !ok {
+					keys = append(keys, key)
+				}
+			}
+			sort.Strings(keys)
+			for _, key := range keys {
+				diffValues(fieldPath(path, key), o[key], n[key], changes)
+			}
+			return
+		}
+	case []interface{}:
+		if n, ok := new.([]interface{}); ok && len(n) == len(o) {
+			for i := range o {
+				diffValues(fmt.Sprintf("%s[%d]", path, i), o[i], n[i], changes)
+			}
+			return
+		}
+	}
+	if !reflect.DeepEqual(old, new) {
+		*changes = append(*changes, FieldChange{Path: path, Old: old, New: new})
+	}
+}
+
+// fieldPath returns the path of the key of the map at the path. The keys
+// which aren't identifiers, like labels, are quoted in brackets.
+func fieldPath(path, key string) string {
+	if strings.ContainsAny(key, "./") {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// dryRunKubeClient is the Kubernetes clientset making the writes of the
+// Deployments, PodDisruptionBudgets and HorizontalPodAutoscalers in the dry
+// run.
+type dryRunKubeClient struct {
+	kubernetes.Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunKubeClient) AppsV1() typedappsv1.AppsV1Interface {
+	return &dryRunAppsV1{AppsV1Interface: c.Interface.AppsV1(), plan: c.plan}
+}
+
+func (c *dryRunKubeClient) PolicyV1() typedpolicyv1.PolicyV1Interface {
+	return &dryRunPolicyV1{PolicyV1Interface: c.Interface.PolicyV1(), plan: c.plan}
+}
+
+func (c *dryRunKubeClient) AutoscalingV2() typedautoscalingv2.AutoscalingV2Interface {
+	return &dryRunAutoscalingV2{AutoscalingV2Interface: c.Interface.AutoscalingV2(), plan: c.plan}
+}
+
+type dryRunAppsV1 struct {
+	typedappsv1.AppsV1Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunAppsV1) Deployments(namespace string) typedappsv1.DeploymentInterface {
+	return &dryRunDeployments{DeploymentInterface: c.AppsV1Interface.Deployments(namespace), plan: c.plan}
+}
+
+type dryRunDeployments struct {
+	typedappsv1.DeploymentInterface
+	plan *dryRunPlan
+}
+
+func (c *dryRunDeployments) Create(ctx context.Context, deployment *appsv1.Deployment, opts metav1.CreateOptions) (*appsv1.Deployment, error) {
+	return dryRunCreate(ctx, c.plan, "Deployment", deployment, c.Get, c.DeploymentInterface.Create, opts)
+}
+
+func (c *dryRunDeployments) Update(ctx context.Context, deployment *appsv1.Deployment, opts metav1.UpdateOptions) (*appsv1.Deployment, error) {
+	return dryRunUpdate(ctx, c.plan, "Deployment", "", deployment, c.Get, c.DeploymentInterface.Update, opts)
+}
+
+func (c *dryRunDeployments) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return dryRunDelete(ctx, c.plan, "Deployment", name, c.Get, c.DeploymentInterface.Delete, opts)
+}
+
+type dryRunPolicyV1 struct {
+	typedpolicyv1.PolicyV1Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunPolicyV1) PodDisruptionBudgets(namespace string) typedpolicyv1.PodDisruptionBudgetInterface {
+	return &dryRunPodDisruptionBudgets{PodDisruptionBudgetInterface: c.PolicyV1Interface.PodDisruptionBudgets(namespace), plan: c.plan}
+}
+
+type dryRunPodDisruptionBudgets struct {
+	typedpolicyv1.PodDisruptionBudgetInterface
+	plan *dryRunPlan
+}
+
+func (c *dryRunPodDisruptionBudgets) Create(ctx context.Context, pdb *policyv1.PodDisruptionBudget, opts metav1.CreateOptions) (*policyv1.PodDisruptionBudget, error) {
+	return dryRunCreate(ctx, c.plan, "PodDisruptionBudget", pdb, c.Get, c.PodDisruptionBudgetInterface.Create, opts)
+}
+
+func (c *dryRunPodDisruptionBudgets) Update(ctx context.Context, pdb *policyv1.PodDisruptionBudget, opts metav1.UpdateOptions) (*policyv1.PodDisruptionBudget, error) {
+	return dryRunUpdate(ctx, c.plan, "PodDisruptionBudget", "", pdb, c.Get, c.PodDisruptionBudgetInterface.Update, opts)
+}
+
+func (c *dryRunPodDisruptionBudgets) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return dryRunDelete(ctx, c.plan, "PodDisruptionBudget", name, c.Get, c.PodDisruptionBudgetInterface.Delete, opts)
+}
+
+type dryRunAutoscalingV2 struct {
+	typedautoscalingv2.AutoscalingV2Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunAutoscalingV2) HorizontalPodAutoscalers(namespace string) typedautoscalingv2.HorizontalPodAutoscalerInterface {
+	return &dryRunHorizontalPodAutoscalers{HorizontalPodAutoscalerInterface: c.AutoscalingV2Interface.HorizontalPodAutoscalers(namespace), plan: c.plan}
+}
+
+type dryRunHorizontalPodAutoscalers struct {
+	typedautoscalingv2.HorizontalPodAutoscalerInterface
+	plan *dryRunPlan
+}
+
+func (c *dryRunHorizontalPodAutoscalers) Create(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, opts metav1.CreateOptions) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	return dryRunCreate(ctx, c.plan, "HorizontalPodAutoscaler", hpa, c.Get, c.HorizontalPodAutoscalerInterface.Create, opts)
+}
+
+func (c *dryRunHorizontalPodAutoscalers) Update(ctx context.Context, hpa *autoscalingv2.HorizontalPodAutoscaler, opts metav1.UpdateOptions) (*autoscalingv2.HorizontalPodAutoscaler, error) {
+	return dryRunUpdate(ctx, c.plan, "HorizontalPodAutoscaler", "", hpa, c.Get, c.HorizontalPodAutoscalerInterface.Update, opts)
+}
+
+func (c *dryRunHorizontalPodAutoscalers) Delete(ctx context.Context, name string, opts metav1.DeleteOptions) error {
+	return dryRunDelete(ctx, c.plan, "HorizontalPodAutoscaler", name, c.Get, c.HorizontalPodAutoscalerInterface.Delete, opts)
+}
+
+// dryRunSampleClient is the clientset of the Foos making the writes of the
+// Foos in the dry run.
+type dryRunSampleClient struct {
+	clientset.Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunSampleClient) ExampleV1alpha1() examplev1alpha1.ExampleV1alpha1Interface {
+	return &dryRunExampleV1alpha1{ExampleV1alpha1Interface: c.Interface.ExampleV1alpha1(), plan: c.plan}
+}
+
+type dryRunExampleV1alpha1 struct {
+	examplev1alpha1.ExampleV1alpha1Interface
+	plan *dryRunPlan
+}
+
+func (c *dryRunExampleV1alpha1) Foos(namespace string) examplev1alpha1.FooInterface {
+	return &dryRunFoos{FooInterface: c.ExampleV1alpha1Interface.Foos(namespace), plan: c.plan}
+}
+
+type dryRunFoos struct {
+	examplev1alpha1.FooInterface
+	plan *dryRunPlan
+}
+
+func (c *dryRunFoos) Update(ctx context.Context, foo *samplev1alpha1.Foo, opts metav1.UpdateOptions) (*samplev1alpha1.Foo, error) {
+	return dryRunUpdate(ctx, c.plan, "Foo", "", foo, c.Get, c.FooInterface.Update, opts)
+}
+
+func (c *dryRunFoos) UpdateStatus(ctx context.Context, foo *samplev1alpha1.Foo, opts metav1.UpdateOptions) (*samplev1alpha1.Foo, error) {
+	return dryRunUpdate(ctx, c.plan, "Foo", "status", foo, c.Get, c.FooInterface.UpdateStatus, opts)
+}
@@ -0,0 +1,303 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/time/rate"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+// The catalog of the Event reasons common to the resources of a Foo. The
+// reasons specific to a feature are defined next to it: RolledBack and
+// RollbackFailed in rollout.go, CanaryPromoted, CanaryAborted and
+// CanaryOperationFailed in canary.go, and DisruptionBudgetSkipped in
+// poddisruptionbudget.go.
+const (
+	// SuccessSynced is used as part of the Event 'reason' when a Foo is synced
+	SuccessSynced = "Synced"
+	// Created is used as part of the Event 'reason' when a resource of a Foo
+	// is created
+	Created = "Created"
+	// Updated is used as part of the Event 'reason' when a resource of a Foo
+	// is updated for a change of the Foo
+	Updated = "Updated"
+	// Scaled is used as part of the Event 'reason' when the replicas of the
+	// Deployment of a Foo are changed
+	Scaled = "Scaled"
+	// DriftCorrected is used as part of the Event 'reason' when a resource
+	// of a Foo is updated back to the desired state after it was changed
+	// while the Foo was not
+	DriftCorrected = "DriftCorrected"
+	// Adopted is used as part of the Event 'reason' when a Deployment with
+	// the managed label and no owner is adopted by a Foo
+	Adopted = "Adopted"
+	// ErrResourceExists is used as part of the Event 'reason' when a Foo fails
+	// to sync due to a Deployment of the same name already existing.
+	ErrResourceExists = "ErrResourceExists"
+	// ReconcileFailed is used as part of the Event 'reason' and the reason of
+	// the ReconcileFailed condition when a Foo is dropped from the workqueue
+	// after exhausting the retry budget.
+	ReconcileFailed = "ReconcileFailed"
+
+	// MessageResourceSynced is the message used for an Event fired when a Foo
+	// is synced successfully
+	MessageResourceSynced = "Foo synced successfully"
+	// MessageCreated is the message used for an Event fired when a resource
+	// of a Foo is created
+	MessageCreated = "Created %s %q"
+	// MessageUpdated is the message used for an Event fired when a resource
+	// of a Foo is updated
+	MessageUpdated = "Updated %s %q"
+	// MessageScaled is the message used for an Event fired when the
+	// Deployment of a Foo is scaled
+	MessageScaled = "Scaled Deployment %q from %d to %d replicas"
+	// MessageDriftCorrected is the message used for an Event fired when the
+	// drift of a resource of a Foo is corrected
+	MessageDriftCorrected = "Corrected drift of %s %q"
+	// MessageAdopted is the message used for an Event fired when a
+	// Deployment is adopted
+	MessageAdopted = "Adopted Deployment %q with the label %s=%s"
+	// MessageResourceExists is the message used for Events when a resource
+	// fails to sync due to a Deployment already existing
+	MessageResourceExists = "Resource %q already exists and is not managed by Foo"
+	// MessageReconcileFailed is the message used for an Event fired when a Foo
+	// is dropped from the workqueue
+	MessageReconcileFailed = "Foo failed to sync %d times and is dropped until it changes: %s"
+	// MessageRepeated is appended to the message of an aggregated warning
+	// Event
+	MessageRepeated = "%s (repeated %d times in the last %s)"
+)
+
+// eventFlushPeriod is the period of the flushes of the repeated warnings, so
+// their counts are emitted at most this long after their windows end.
+const eventFlushPeriod = 30 * time.Second
+
+// EventPolicyOptions configures the Events emitted for each Foo.
+type EventPolicyOptions struct {
+	// QPS and Burst limit the rate of the Events of each Foo. The Events
+	// beyond the rate are dropped.
+	QPS   float64
+	Burst int
+	// AggregationWindow is the window in which the repeats of a warning
+	// Event are counted instead of emitted. The count is emitted by Flush
+	// after the window, with the first repeat after the window if it comes
+	// earlier, or when the Foo is deleted.
+	AggregationWindow time.Duration
+}
+
+// DefaultEventPolicyOptions returns the default options.
+func DefaultEventPolicyOptions() EventPolicyOptions {
+	return EventPolicyOptions{
+		QPS:               configv1alpha1.DefaultEventQPS,
+		Burst:             configv1alpha1.DefaultEventBurst,
+		AggregationWindow: configv1alpha1.DefaultEventAggregationWindow,
+	}
+}
+
+// eventPolicy emits the Events of the Foos through the recorder, which
+// - drops a Normal Event identical to the last emitted Event of the Foo, so an
+// Event is only emitted on a state transition, such as Synced after a
+// change or a failure.
+// - aggregates the repeats of a warning Event in AggregationWindow.
+// - drops the Events of a Foo beyond the rate of QPS and Burst.
+type eventPolicy struct {
+	recorder record.EventRecorder
+	clock    clock.PassiveClock
+
+	mu   sync.Mutex
+	opts EventPolicyOptions
+	foos map[types.NamespacedName]*fooEvents
+}
+
+// fooEvents is the state of the Events of a Foo.
+type fooEvents struct {
+	limiter *rate.Limiter
+	// last is the reason and message of the last emitted Event.
+	last string
+	// warnings are the aggregated warnings by reason and message.
+	warnings map[string]*warningAggregate
+}
+
+// warningAggregate counts the repeats of a warning Event in the window
+// started at since, and keeps the Foo and the annotations of the last repeat
+// to emit the count with.
+type warningAggregate struct {
+	since       time.Time
+	repeats     int
+	foo         *samplev1alpha1.Foo
+	annotations map[string]string
+	reason      string
+	message     string
+}
+
+func newEventPolicy(recorder record.EventRecorder, opts EventPolicyOptions, clock clock.PassiveClock) *eventPolicy {
+	return &eventPolicy{
+		recorder: recorder,
+		opts:     opts,
+		clock:    clock,
+		foos:     map[types.NamespacedName]*fooEvents{},
+	}
+}
+
+// SetOptions replaces the options of the policy. The rates of the Foos start
+// over, and the last Events and the aggregated warnings are kept.
+func (p *eventPolicy) SetOptions(opts EventPolicyOptions) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.opts = opts
+	for _, events := range p.foos {
+		events.limiter = rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst)
+	}
+}
+
+// Emit emits the Event with the annotations for the Foo unless the policy
+// drops it, and returns true if it's emitted.
+func (p *eventPolicy) Emit(foo *samplev1alpha1.Foo, annotations map[string]string, eventtype, reason, message string) bool {
+	key := types.NamespacedName{Namespace: foo.Namespace, Name: foo.Name}
+	now := p.clock.Now()
+
+	p.mu.Lock()
+	events, ok := p.foos[key]
+	if !ok {
+		events = &fooEvents{
+			limiter:  rate.NewLimiter(rate.Limit(p.opts.QPS), p.opts.Burst),
+			warnings: map[string]*warningAggregate{},
+		}
+		p.foos[key] = events
+	}
+	id := reason + "/" + message
+	emit := true
+	switch {
+	case eventtype == corev1.EventTypeNormal:
+		emit = events.last != id
+	case p.opts.AggregationWindow > 0:
+		aggregate, ok := events.warnings[id]
+		switch {
+		case !ok:
+			events.warnings[id] = &warningAggregate{since: now, reason: reason, message: message}
+		case now.Sub(aggregate.since) < p.opts.AggregationWindow:
+			aggregate.repeats++
+			aggregate.foo = foo
+			aggregate.annotations = annotations
+			emit = false
+		default:
+			if aggregate.repeats > 0 {
+				message = fmt.Sprintf(MessageRepeated, message, aggregate.repeats+1, p.opts.AggregationWindow)
+			}
+			events.warnings[id] = &warningAggregate{since: now, reason: reason, message: message}
+		}
+	}
+	if emit && p.opts.QPS > 0 {
+		emit = events.limiter.AllowN(now, 1)
+	}
+	// The dropped Events are not seen, so a warning repeated in the window
+	// doesn't make the following Normal Event emitted again.
+	if emit {
+		events.last = id
+	}
+	p.mu.Unlock()
+
+	if !emit {
+		return false
+	}
+	p.record(foo, annotations, eventtype, reason, message)
+	return true
+}
+
+// Flush emits the counts of the warnings repeated in the windows which have
+// ended, as no repeat may come after the window to emit them with.
+func (p *eventPolicy) Flush() {
+	now := p.clock.Now()
+	p.mu.Lock()
+	var repeated []*warningAggregate
+	for _, events := range p.foos {
+		repeated = append(repeated, p.takeRepeated(events, now, false)...)
+	}
+	window := p.opts.AggregationWindow
+	p.mu.Unlock()
+	for _, aggregate := range repeated {
+		p.recordRepeated(aggregate, window)
+	}
+}
+
+// Forget emits the counts of the repeated warnings and removes the state of
+// the Events of the deleted Foo.
+func (p *eventPolicy) Forget(key types.NamespacedName) {
+	p.mu.Lock()
+	var repeated []*warningAggregate
+	if events, ok := p.foos[key]; ok {
+		repeated = p.takeRepeated(events, p.clock.Now(), true)
+		delete(p.foos, key)
+	}
+	window := p.opts.AggregationWindow
+	p.mu.Unlock()
+	for _, aggregate := range repeated {
+		p.recordRepeated(aggregate, window)
+	}
+}
+
+// takeRepeated removes the aggregated warnings of the Foo whose windows have
+// ended, or all of them if all is true, and returns the ones repeated within
+// the rate of the Foo. It must be called with the lock held.
+func (p *eventPolicy) takeRepeated(events *fooEvents, now time.Time, all bool) []*warningAggregate {
+	var repeated []*warningAggregate
+	for id, aggregate := range events.warnings {
+		if !all && now.Sub(aggregate.since) < p.opts.AggregationWindow {
+			continue
+		}
+		delete(events.warnings, id)
+		if aggregate.repeats > 0 && (p.opts.QPS <= 0 || events.limiter.AllowN(now, 1)) {
+			repeated = append(repeated, aggregate)
+		}
+	}
+	return repeated
+}
+
+// recordRepeated records the count of the repeats of the aggregated warning.
+func (p *eventPolicy) recordRepeated(aggregate *warningAggregate, window time.Duration) {
+	message := fmt.Sprintf(MessageRepeated, aggregate.message, aggregate.repeats, window)
+	p.record(aggregate.foo, aggregate.annotations, corev1.EventTypeWarning, aggregate.reason, message)
+}
+
+// record records the Event with the annotations for the Foo.
+func (p *eventPolicy) record(foo *samplev1alpha1.Foo, annotations map[string]string, eventtype, reason, message string) {
+	if len(annotations) == 0 {
+		p.recorder.Event(foo, eventtype, reason, message)
+	} else {
+		p.recorder.AnnotatedEventf(foo, annotations, eventtype, reason, "%s", message)
+	}
+}
+
+// event emits an Event for the Foo with the trace ID of the context through
+// the event policy. The Event is only logged in a dry run.
+func (c *Controller) event(ctx context.Context, foo *samplev1alpha1.Foo, eventtype, reason, message string) {
+	if c.plan != nil {
+		klog.FromContext(ctx).Info("Dry run: skipping Event", "type", eventtype, "reason", reason, "message", message)
+		return
+	}
+	var annotations map[string]string
+	// The trace ID is only set if the trace is sampled.
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsSampled() {
+		annotations = map[string]string{TraceIDAnnotation: spanContext.TraceID().String()}
+	}
+	if !c.events.Emit(foo, annotations, eventtype, reason, message) {
+		klog.FromContext(ctx).V(logLevelDebug).Info("Event is dropped by the event policy", "type", eventtype, "reason", reason)
+	}
+}
+
+// eventf emits an Event for the Foo with the trace ID of the context through
+// the event policy.
+func (c *Controller) eventf(ctx context.Context, foo *samplev1alpha1.Foo, eventtype, reason, messageFmt string, args ...interface{}) {
+	c.event(ctx, foo, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
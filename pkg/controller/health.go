@@ -0,0 +1,165 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ConditionTypeDegraded is the condition type of a Foo which reports
+	// whether the pods of the Foo are unhealthy over the thresholds in
+	// spec.health.
+	ConditionTypeDegraded = "Degraded"
+
+	// Reasons of the Degraded condition.
+	reasonHealthy         = "Healthy"
+	reasonUnhealthyPods   = "UnhealthyPods"
+	reasonTooManyRestarts = "TooManyRestarts"
+
+	// waitingCrashLoopBackOff is the waiting reason of a container in
+	// CrashLoopBackOff.
+	waitingCrashLoopBackOff = "CrashLoopBackOff"
+)
+
+// imagePullErrorReasons are the waiting reasons of a container failing to
+// pull its image.
+var imagePullErrorReasons = map[string]bool{
+	"ErrImagePull":     true,
+	"ImagePullBackOff": true,
+	"InvalidImageName": true,
+}
+
+// getPodHealth aggregates the health of the pods of the Foo from the pods
+// with the controller label.
+func (c *Controller) getPodHealth(ctx context.Context, foo *samplev1alpha1.Foo) (*samplev1alpha1.FooHealthStatus, error) {
+	_, span := c.tracer.Start(ctx, "getPodHealth")
+	defer span.End()
+	selector := labels.SelectorFromSet(labels.Set{controllerLabel: foo.Name})
+	pods, err := c.podsLister.Pods(foo.Namespace).List(selector)
+	if err != nil {
+		return nil, err
+	}
+
+	health := &samplev1alpha1.FooHealthStatus{}
+	for _, pod := range pods {
+		crashLoopBackOff, imagePullError := false, false
+		statuses := append(append([]corev1.ContainerStatus{}, pod.Status.InitContainerStatuses...), pod.Status.ContainerStatuses...)
+		for _, status := range statuses {
+			health.Restarts += status.RestartCount
+			if status.State.Waiting == nil {
+				continue
+			}
+			if status.State.Waiting.Reason == waitingCrashLoopBackOff {
+				crashLoopBackOff = true
+			}
+			if imagePullErrorReasons[status.State.Waiting.Reason] {
+				imagePullError = true
+			}
+		}
+		if crashLoopBackOff {
+			health.CrashLoopBackOffPods++
+		}
+		if imagePullError {
+			health.ImagePullErrorPods++
+		}
+	}
+	return health, nil
+}
+
+// newDegradedCondition returns the Degraded condition of the Foo from the
+// health of the pods and the thresholds in spec.health.
+func newDegradedCondition(foo *samplev1alpha1.Foo, health *samplev1alpha1.FooHealthStatus) metav1.Condition {
+	var maxUnhealthyPods int32
+	var maxRestarts *int32
+	if foo.Spec.Health != nil {
+		if foo.Spec.Health.MaxUnhealthyPods != nil {
+			maxUnhealthyPods = *foo.Spec.Health.MaxUnhealthyPods
+		}
+		maxRestarts = foo.Spec.Health.MaxRestarts
+	}
+
+	condition := metav1.Condition{
+		Type:               ConditionTypeDegraded,
+		ObservedGeneration: foo.Generation,
+	}
+	switch unhealthyPods := health.CrashLoopBackOffPods + health.ImagePullErrorPods; {
+	case unhealthyPods > maxUnhealthyPods:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonUnhealthyPods
+		condition.Message = fmt.Sprintf("%d pods in CrashLoopBackOff and %d pods failing to pull images", health.CrashLoopBackOffPods, health.ImagePullErrorPods)
+	case maxRestarts != nil && health.Restarts > *maxRestarts:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonTooManyRestarts
+		condition.Message = fmt.Sprintf("%d restarts exceed %d", health.Restarts, *maxRestarts)
+	default:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonHealthy
+		condition.Message = "pods are healthy"
+	}
+	return condition
+}
+
+// shouldAutoPauseRollout returns true if the rollout of the Deployment is in
+// progress while the Foo is Degraded and spec.health.autoPause is enabled.
+func shouldAutoPauseRollout(foo *samplev1alpha1.Foo, deployment *appsv1.Deployment, degraded metav1.Condition) bool {
+	if foo.Spec.Health == nil || !foo.Spec.Health.AutoPause || degraded.Status != metav1.ConditionTrue {
+		return false
+	}
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return deployment.Spec.Paused
+	}
+	return deployment.Status.UpdatedReplicas < getDeploymentReplicas(deployment)
+}
+
+// podEventHandler returns the event handler of the pod informer, which
+// enqueues the Foo of the pod with handlePod. The resyncs are ignored.
+func (c *Controller) podEventHandler() cache.ResourceEventHandler {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: c.handlePod,
+		UpdateFunc: func(old, new interface{}) {
+			newPod := new.(*corev1.Pod)
+			oldPod := old.(*corev1.Pod)
+			if newPod.ResourceVersion == oldPod.ResourceVersion {
+				return
+			}
+			c.handlePod(new)
+		},
+		DeleteFunc: c.handlePod,
+	}
+}
+
+// handlePod enqueues the Foo of the pod from the controller label, which is
+// set on the pod template by newDeployment.
+func (c *Controller) handlePod(obj interface{}) {
+	var pod *corev1.Pod
+	var ok bool
+	if pod, ok = obj.(*corev1.Pod); !ok {
+		tombstone, ok := obj.(cache.DeletedFinalStateUnknown)
+		if !ok {
+			return
+		}
+		pod, ok = tombstone.Obj.(*corev1.Pod)
+		if !ok {
+			return
+		}
+	}
+	name, ok := pod.Labels[controllerLabel]
+	if !ok {
+		return
+	}
+	foo, err := c.foosLister.Foos(pod.Namespace).Get(name)
+	if err != nil {
+		c.logger.V(logLevelDebug).Info("Ignoring pod of unknown Foo", "pod", klog.KObj(pod), "foo", klog.KRef(pod.Namespace, name))
+		return
+	}
+	c.enqueueFoo(foo, TriggerPodChange)
+}
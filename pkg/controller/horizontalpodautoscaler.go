@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+)
+
+// defaultTargetCPUUtilizationPercentage is used when no target is specified
+// in spec.autoscaling, which is the same as the default of
+// HorizontalPodAutoscaler. The utilization is measured against the CPU
+// requests set by spec.resources, which the CRD requires for autoscaling.
+const defaultTargetCPUUtilizationPercentage int32 = 80
+
+// syncHorizontalPodAutoscaler makes the HorizontalPodAutoscaler owned by the
+// Foo match spec.autoscaling. The HorizontalPodAutoscaler is deleted when
+// autoscaling is removed from the Foo.
+func (c *Controller) syncHorizontalPodAutoscaler(ctx context.Context, foo *samplev1alpha1.Foo) error {
+	ctx, span := c.tracer.Start(ctx, "syncHorizontalPodAutoscaler")
+	defer span.End()
+	logger := klog.FromContext(ctx)
+	hpa, err := c.hpasLister.HorizontalPodAutoscalers(foo.Namespace).Get(foo.Spec.DeploymentName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if errors.IsNotFound(err) {
+		hpa = nil
+	}
+
+	if foo.Spec.Autoscaling == nil {
+		if hpa == nil || !metav1.IsControlledBy(hpa, foo) {
+			return nil
+		}
+		logger.Info("Deleting HorizontalPodAutoscaler", "horizontalPodAutoscaler", klog.KObj(hpa))
+		err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(hpa.Namespace).Delete(ctx, hpa.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if hpa == nil {
+		hpa, err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(foo.Namespace).Create(ctx, newHorizontalPodAutoscaler(foo), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		c.eventf(ctx, foo, corev1.EventTypeNormal, Created, MessageCreated, "HorizontalPodAutoscaler", hpa.Name)
+		return nil
+	}
+
+	// If the HorizontalPodAutoscaler is not controlled by this Foo resource,
+	// we should log a warning to the event recorder and return error msg.
+	if !metav1.IsControlledBy(hpa, foo) {
+		msg := fmt.Sprintf(MessageResourceExists, hpa.Name)
+		c.event(ctx, foo, corev1.EventTypeWarning, ErrResourceExists, msg)
+		logger.Info("HorizontalPodAutoscaler already exists and is not managed by Foo", "horizontalPodAutoscaler", klog.KObj(hpa))
+		return fmt.Errorf("%s", msg)
+	}
+
+	desired := newHorizontalPodAutoscaler(foo)
+	// Only compare the fields set by the controller as the API server
+	// defaults the others (e.g. behavior).
+	if equality.Semantic.DeepEqual(hpa.Spec.ScaleTargetRef, desired.Spec.ScaleTargetRef) &&
+		equality.Semantic.DeepEqual(hpa.Spec.MinReplicas, desired.Spec.MinReplicas) &&
+		hpa.Spec.MaxReplicas == desired.Spec.MaxReplicas &&
+		equality.Semantic.DeepEqual(hpa.Spec.Metrics, desired.Spec.Metrics) {
+		return nil
+	}
+	// NEVER modify objects from the store.
+	hpaCopy := hpa.DeepCopy()
+	hpaCopy.Spec.ScaleTargetRef = desired.Spec.ScaleTargetRef
+	hpaCopy.Spec.MinReplicas = desired.Spec.MinReplicas
+	hpaCopy.Spec.MaxReplicas = desired.Spec.MaxReplicas
+	hpaCopy.Spec.Metrics = desired.Spec.Metrics
+	_, err = c.kubeclientset.AutoscalingV2().HorizontalPodAutoscalers(foo.Namespace).Update(ctx, hpaCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	c.recordUpdate(ctx, foo, "HorizontalPodAutoscaler", hpa.Name)
+	return nil
+}
+
+// getDesiredReplicas returns the replicas of the Deployment of the Foo, or
+// nil if they are left as they are. While autoscaling is specified, they are
+// left to the HorizontalPodAutoscaler. Once autoscaling is removed, the
+// replicas chosen by the HorizontalPodAutoscaler are reset to the default of
+// 1 if spec.replicas is not specified, before the HorizontalPodAutoscaler is
+// deleted.
+func (c *Controller) getDesiredReplicas(foo *samplev1alpha1.Foo) (*int32, error) {
+	if foo.Spec.Autoscaling != nil {
+		return nil, nil
+	}
+	if foo.Spec.Replicas != nil {
+		return foo.Spec.Replicas, nil
+	}
+	hpa, err := c.hpasLister.HorizontalPodAutoscalers(foo.Namespace).Get(foo.Spec.DeploymentName)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !metav1.IsControlledBy(hpa, foo) {
+		return nil, nil
+	}
+	replicas := int32(1)
+	return &replicas, nil
+}
+
+// getMinReplicas returns spec.autoscaling.minReplicas of the Foo, which is
+// 1 when it's not specified as in HorizontalPodAutoscaler.
+func getMinReplicas(autoscaling *samplev1alpha1.FooAutoscaling) int32 {
+	if autoscaling.MinReplicas == nil {
+		return 1
+	}
+	return *autoscaling.MinReplicas
+}
+
+func newHorizontalPodAutoscaler(foo *samplev1alpha1.Foo) *autoscalingv2.HorizontalPodAutoscaler {
+	autoscaling := foo.Spec.Autoscaling
+	minReplicas := getMinReplicas(autoscaling)
+
+	var metrics []autoscalingv2.MetricSpec
+	if autoscaling.TargetCPUUtilizationPercentage != nil {
+		metrics = append(metrics, newResourceMetric(corev1.ResourceCPU, *autoscaling.TargetCPUUtilizationPercentage))
+	}
+	if autoscaling.TargetMemoryUtilizationPercentage != nil {
+		metrics = append(metrics, newResourceMetric(corev1.ResourceMemory, *autoscaling.TargetMemoryUtilizationPercentage))
+	}
+	if len(metrics) == 0 {
+		metrics = append(metrics, newResourceMetric(corev1.ResourceCPU, defaultTargetCPUUtilizationPercentage))
+	}
+
+	return &autoscalingv2.HorizontalPodAutoscaler{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            foo.Spec.DeploymentName,
+			Namespace:       foo.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))},
+		},
+		Spec: autoscalingv2.HorizontalPodAutoscalerSpec{
+			ScaleTargetRef: autoscalingv2.CrossVersionObjectReference{
+				APIVersion: "apps/v1",
+				Kind:       "Deployment",
+				Name:       foo.Spec.DeploymentName,
+			},
+			MinReplicas: &minReplicas,
+			MaxReplicas: autoscaling.MaxReplicas,
+			Metrics:     metrics,
+		},
+	}
+}
+
+func newResourceMetric(name corev1.ResourceName, averageUtilization int32) autoscalingv2.MetricSpec {
+	return autoscalingv2.MetricSpec{
+		Type: autoscalingv2.ResourceMetricSourceType,
+		Resource: &autoscalingv2.ResourceMetricSource{
+			Name: name,
+			Target: autoscalingv2.MetricTarget{
+				Type:               autoscalingv2.UtilizationMetricType,
+				AverageUtilization: &averageUtilization,
+			},
+		},
+	}
+}
@@ -0,0 +1,235 @@
+package controller
+
+import (
+	"fmt"
+	"time"
+
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+	exampleinformers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions"
+	informers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions/example.com/v1alpha1"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kubeinformers "k8s.io/client-go/informers"
+	appsinformers "k8s.io/client-go/informers/apps/v1"
+	autoscalinginformers "k8s.io/client-go/informers/autoscaling/v2"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	policyinformers "k8s.io/client-go/informers/policy/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Informers is the set of informers watched by the Controller, which are
+// either for all the namespaces or for one namespace.
+type Informers struct {
+	Deployments              appsinformers.DeploymentInformer
+	PodDisruptionBudgets     policyinformers.PodDisruptionBudgetInformer
+	HorizontalPodAutoscalers autoscalinginformers.HorizontalPodAutoscalerInformer
+	// Pods should only watch the pods with the controller label.
+	Pods coreinformers.PodInformer
+	Foos informers.FooInformer
+}
+
+// informers returns all the informers in the set.
+func (i Informers) informers() []cache.SharedIndexInformer {
+	return []cache.SharedIndexInformer{
+		i.Deployments.Informer(),
+		i.PodDisruptionBudgets.Informer(),
+		i.HorizontalPodAutoscalers.Informer(),
+		i.Pods.Informer(),
+		i.Foos.Informer(),
+	}
+}
+
+// InformerFactory is the common interface of the informer factories.
+type InformerFactory interface {
+	Start(stopCh <-chan struct{})
+}
+
+// NewInformers returns the informers for each namespace, or for all the
+// namespaces if no namespace is given, and their factories to start. The
+// informers strip the unused fields of the objects with transformObject.
+func NewInformers(kubeClient kubernetes.Interface, exampleClient clientset.Interface, namespaces []string, fooSelector, deploymentSelector string, resyncPeriod time.Duration) ([]Informers, []InformerFactory, error) {
+	if len(namespaces) == 0 {
+		namespaces = []string{metav1.NamespaceAll}
+	}
+
+	var informerSets []Informers
+	var factories []InformerFactory
+	for _, namespace := range namespaces {
+		kubeInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeinformers.WithNamespace(namespace))
+		deploymentInformerFactory := kubeInformerFactory
+		if deploymentSelector != "" {
+			deploymentInformerFactory = kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeinformers.WithNamespace(namespace), kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+				options.LabelSelector = deploymentSelector
+			}))
+			factories = append(factories, deploymentInformerFactory)
+		}
+		// Only the pods of Foos are cached, which have the controller label.
+		podInformerFactory := kubeinformers.NewSharedInformerFactoryWithOptions(kubeClient, resyncPeriod, kubeinformers.WithNamespace(namespace), kubeinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = controllerLabel
+		}))
+		exampleInformerFactory := exampleinformers.NewSharedInformerFactoryWithOptions(exampleClient, resyncPeriod, exampleinformers.WithNamespace(namespace), exampleinformers.WithTweakListOptions(func(options *metav1.ListOptions) {
+			options.LabelSelector = fooSelector
+		}))
+		factories = append(factories, kubeInformerFactory, podInformerFactory, exampleInformerFactory)
+
+		informerSet := Informers{
+			Deployments:              deploymentInformerFactory.Apps().V1().Deployments(),
+			PodDisruptionBudgets:     kubeInformerFactory.Policy().V1().PodDisruptionBudgets(),
+			HorizontalPodAutoscalers: kubeInformerFactory.Autoscaling().V2().HorizontalPodAutoscalers(),
+			Pods:                     podInformerFactory.Core().V1().Pods(),
+			Foos:                     exampleInformerFactory.Example().V1alpha1().Foos(),
+		}
+		for _, informer := range informerSet.informers() {
+			if err := informer.SetTransform(transformObject); err != nil {
+				return nil, nil, err
+			}
+		}
+		informerSets = append(informerSets, informerSet)
+	}
+	return informerSets, factories, nil
+}
+
+// mergeIndexers returns an indexer which reads from the indexers of the
+// informers. The indexers must be for distinct namespaces unless there's only
+// one.
+func mergeIndexers(informers []cache.SharedIndexInformer) cache.Indexer {
+	if len(informers) == 1 {
+		return informers[0].GetIndexer()
+	}
+	indexers := make([]cache.Indexer, 0, len(informers))
+	for _, informer := range informers {
+		indexers = append(indexers, informer.GetIndexer())
+	}
+	return &multiNamespaceIndexer{indexers: indexers}
+}
+
+// mergeHasSynced returns an InformerSynced which returns true once all the
+// informers have synced.
+func mergeHasSynced(informers []cache.SharedIndexInformer) cache.InformerSynced {
+	return func() bool {
+		for _, informer := range informers {
+			if !informer.HasSynced() {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// multiNamespaceIndexer is a read-only cache.Indexer over the indexers of
+// the informers for distinct namespaces, so the listers can be used in the
+// same way as for all the namespaces.
+type multiNamespaceIndexer struct {
+	indexers []cache.Indexer
+}
+
+var _ cache.Indexer = &multiNamespaceIndexer{}
+
+var errReadOnlyIndexer = fmt.Errorf("multiNamespaceIndexer is read-only")
+
+func (i *multiNamespaceIndexer) Add(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Update(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Delete(obj interface{}) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Replace(list []interface{}, resourceVersion string) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) Resync() error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) AddIndexers(newIndexers cache.Indexers) error {
+	return errReadOnlyIndexer
+}
+
+func (i *multiNamespaceIndexer) List() []interface{} {
+	var items []interface{}
+	for _, indexer := range i.indexers {
+		items = append(items, indexer.List()...)
+	}
+	return items
+}
+
+func (i *multiNamespaceIndexer) ListKeys() []string {
+	var keys []string
+	for _, indexer := range i.indexers {
+		keys = append(keys, indexer.ListKeys()...)
+	}
+	return keys
+}
+
+func (i *multiNamespaceIndexer) Get(obj interface{}) (interface{}, bool, error) {
+	key, err := cache.MetaNamespaceKeyFunc(obj)
+	if err != nil {
+		return nil, false, err
+	}
+	return i.GetByKey(key)
+}
+
+func (i *multiNamespaceIndexer) GetByKey(key string) (interface{}, bool, error) {
+	for _, indexer := range i.indexers {
+		item, exists, err := indexer.GetByKey(key)
+		if err != nil || exists {
+			return item, exists, err
+		}
+	}
+	return nil, false, nil
+}
+
+func (i *multiNamespaceIndexer) Index(indexName string, obj interface{}) ([]interface{}, error) {
+	var items []interface{}
+	for _, indexer := range i.indexers {
+		indexed, err := indexer.Index(indexName, obj)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, indexed...)
+	}
+	return items, nil
+}
+
+func (i *multiNamespaceIndexer) IndexKeys(indexName, indexedValue string) ([]string, error) {
+	var keys []string
+	for _, indexer := range i.indexers {
+		indexed, err := indexer.IndexKeys(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, indexed...)
+	}
+	return keys, nil
+}
+
+func (i *multiNamespaceIndexer) ListIndexFuncValues(indexName string) []string {
+	var values []string
+	for _, indexer := range i.indexers {
+		values = append(values, indexer.ListIndexFuncValues(indexName)...)
+	}
+	return values
+}
+
+func (i *multiNamespaceIndexer) ByIndex(indexName, indexedValue string) ([]interface{}, error) {
+	var items []interface{}
+	for _, indexer := range i.indexers {
+		indexed, err := indexer.ByIndex(indexName, indexedValue)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, indexed...)
+	}
+	return items, nil
+}
+
+func (i *multiNamespaceIndexer) GetIndexers() cache.Indexers {
+	return i.indexers[0].GetIndexers()
+}
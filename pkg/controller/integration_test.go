@@ -0,0 +1,469 @@
+//go:build integration
+
+package controller
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	clientset "github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2/ktesting"
+	"k8s.io/utils/pointer"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+)
+
+const (
+	// integrationTimeout bounds every wait of the integration tests.
+	integrationTimeout = 30 * time.Second
+	// integrationInterval is the interval to poll the API server.
+	integrationInterval = 100 * time.Millisecond
+)
+
+// testConfig is the config of the kube-apiserver started by TestMain.
+var testConfig *rest.Config
+
+// TestMain starts kube-apiserver and etcd from KUBEBUILDER_ASSETS with the
+// Foo CRD installed, which are shared by the tests. There's no
+// kube-controller-manager, so Deployments don't create pods and the garbage
+// collector doesn't delete the dependents of a deleted Foo.
+func TestMain(m *testing.M) {
+	if os.Getenv("KUBEBUILDER_ASSETS") == "" {
+		fmt.Println("Skipping the integration tests as KUBEBUILDER_ASSETS is not set")
+		os.Exit(0)
+	}
+	testEnv := &envtest.Environment{
+		CRDDirectoryPaths:     []string{filepath.Join("..", "..", "config", "crd")},
+		ErrorIfCRDPathMissing: true,
+	}
+	var err error
+	testConfig, err = testEnv.Start()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error starting the test environment: %v\n", err)
+		os.Exit(1)
+	}
+	code := m.Run()
+	if err := testEnv.Stop(); err != nil {
+		fmt.Fprintf(os.Stderr, "Error stopping the test environment: %v\n", err)
+	}
+	os.Exit(code)
+}
+
+// integrationFixture runs the Controller against the API server of TestMain
+// for the Foos in a namespace of its own.
+type integrationFixture struct {
+	t *testing.T
+
+	kubeclient kubernetes.Interface
+	client     clientset.Interface
+	namespace  string
+
+	// opts are the options of the Controllers of the fixture.
+	opts ControllerOptions
+}
+
+// newIntegrationFixture creates a namespace for the test, which is deleted on
+// cleanup.
+func newIntegrationFixture(t *testing.T) *integrationFixture {
+	f := &integrationFixture{
+		t:          t,
+		kubeclient: kubernetes.NewForConfigOrDie(testConfig),
+		client:     clientset.NewForConfigOrDie(testConfig),
+	}
+	ns, err := f.kubeclient.CoreV1().Namespaces().Create(context.Background(), &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{GenerateName: "integration-"},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		t.Fatalf("Failed to create namespace: %v", err)
+	}
+	f.namespace = ns.Name
+	t.Cleanup(func() {
+		if err := f.kubeclient.CoreV1().Namespaces().Delete(context.Background(), f.namespace, metav1.DeleteOptions{}); err != nil {
+			t.Errorf("Failed to delete namespace: %v", err)
+		}
+	})
+	return f
+}
+
+// newController returns a Controller for the namespace of the fixture and
+// starts its informers until the returned function is called. The Controller
+// isn't run, so syncHandler can be called directly.
+func (f *integrationFixture) newController() (*Controller, func()) {
+	_, ctx := ktesting.NewTestContext(f.t)
+	ctx, cancel := context.WithCancel(ctx)
+	informerSets, factories, err := NewInformers(f.kubeclient, f.client, []string{f.namespace}, "", "", 0)
+	if err != nil {
+		f.t.Fatalf("Failed to build informers: %v", err)
+	}
+	controller := NewController(ctx, f.kubeclient, f.client, informerSets, f.opts)
+	for _, factory := range factories {
+		factory.Start(ctx.Done())
+	}
+	if !cache.WaitForCacheSync(ctx.Done(), controller.foosSynced, controller.deploymentsSynced, controller.pdbsSynced, controller.hpasSynced, controller.podsSynced) {
+		f.t.Fatal("Failed to sync the informer caches")
+	}
+	return controller, cancel
+}
+
+// startController runs a Controller for the namespace of the fixture until
+// the returned function is called, which waits for the Controller to stop.
+// The Controller is also stopped on cleanup.
+func (f *integrationFixture) startController() func() {
+	controller, cancel := f.newController()
+	_, ctx := ktesting.NewTestContext(f.t)
+	ctx, cancelRun := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		if err := controller.Run(ctx); err != nil {
+			f.t.Errorf("Error running controller: %v", err)
+		}
+	}()
+	stop := func() {
+		cancelRun()
+		cancel()
+		<-done
+	}
+	f.t.Cleanup(func() {
+		select {
+		case <-done:
+		default:
+			stop()
+		}
+	})
+	return stop
+}
+
+// createFoo creates a Foo with the replicas in the namespace of the fixture.
+func (f *integrationFixture) createFoo(name string, replicas int32) *samplev1alpha1.Foo {
+	foo := newFoo(name, pointer.Int32(replicas))
+	foo.Namespace = f.namespace
+	return f.createFooObject(foo)
+}
+
+// createFooObject creates the Foo.
+func (f *integrationFixture) createFooObject(foo *samplev1alpha1.Foo) *samplev1alpha1.Foo {
+	foo, err := f.client.ExampleV1alpha1().Foos(f.namespace).Create(context.Background(), foo, metav1.CreateOptions{})
+	if err != nil {
+		f.t.Fatalf("Failed to create Foo: %v", err)
+	}
+	return foo
+}
+
+// updateFoo gets the latest Foo, mutates it and updates it, retrying on
+// conflicts.
+func (f *integrationFixture) updateFoo(name string, mutate func(*samplev1alpha1.Foo)) *samplev1alpha1.Foo {
+	var foo *samplev1alpha1.Foo
+	f.waitFor(fmt.Sprintf("Foo %s to be updated", name), func(ctx context.Context) (bool, error) {
+		latest, err := f.client.ExampleV1alpha1().Foos(f.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		mutate(latest)
+		foo, err = f.client.ExampleV1alpha1().Foos(f.namespace).Update(ctx, latest, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	return foo
+}
+
+// waitFor polls the condition until it's true within integrationTimeout, and
+// fails the test otherwise.
+func (f *integrationFixture) waitFor(desc string, condition wait.ConditionWithContextFunc) {
+	f.t.Helper()
+	err := wait.PollUntilContextTimeout(context.Background(), integrationInterval, integrationTimeout, true, condition)
+	if err != nil {
+		f.t.Fatalf("Failed waiting for %s: %v", desc, err)
+	}
+}
+
+// waitForDeployment waits for the Deployment of the Foo to satisfy the
+// condition and returns it.
+func (f *integrationFixture) waitForDeployment(foo *samplev1alpha1.Foo, condition func(*appsv1.Deployment) bool) *appsv1.Deployment {
+	f.t.Helper()
+	var deployment *appsv1.Deployment
+	f.waitFor(fmt.Sprintf("Deployment %s", foo.Spec.DeploymentName), func(ctx context.Context) (bool, error) {
+		var err error
+		deployment, err = f.kubeclient.AppsV1().Deployments(f.namespace).Get(ctx, foo.Spec.DeploymentName, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		if err != nil {
+			return false, err
+		}
+		return condition(deployment), nil
+	})
+	return deployment
+}
+
+// waitForSynced waits for the status of the Foo to observe its generation.
+func (f *integrationFixture) waitForSynced(name string) *samplev1alpha1.Foo {
+	f.t.Helper()
+	var foo *samplev1alpha1.Foo
+	f.waitFor(fmt.Sprintf("Foo %s to be synced", name), func(ctx context.Context) (bool, error) {
+		var err error
+		foo, err = f.client.ExampleV1alpha1().Foos(f.namespace).Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		return foo.Status.ObservedGeneration == foo.Generation, nil
+	})
+	return foo
+}
+
+func hasReplicas(replicas int32) func(*appsv1.Deployment) bool {
+	return func(deployment *appsv1.Deployment) bool {
+		return deployment.Spec.Replicas != nil && *deployment.Spec.Replicas == replicas
+	}
+}
+
+func TestIntegrationCreate(t *testing.T) {
+	f := newIntegrationFixture(t)
+	f.startController()
+
+	foo := f.createFoo("create", 2)
+	deployment := f.waitForDeployment(foo, hasReplicas(2))
+	if !metav1.IsControlledBy(deployment, foo) {
+		t.Errorf("Deployment is not controlled by the Foo: %v", deployment.OwnerReferences)
+	}
+	if deployment.Labels[managedByLabel] != controllerAgentName {
+		t.Errorf("Deployment doesn't have the managed label: %v", deployment.Labels)
+	}
+	// The status is updated through the status subresource.
+	foo = f.waitForSynced(foo.Name)
+	if foo.Status.Stable == nil || foo.Status.Stable.DeploymentName != deployment.Name {
+		t.Errorf("Unexpected stable status: %+v", foo.Status.Stable)
+	}
+
+	// The CRD schema rejects the replicas out of range.
+	invalid := newFoo("invalid", pointer.Int32(11))
+	invalid.Namespace = f.namespace
+	_, err := f.client.ExampleV1alpha1().Foos(f.namespace).Create(context.Background(), invalid, metav1.CreateOptions{})
+	if !errors.IsInvalid(err) {
+		t.Errorf("Expected the Foo with 11 replicas to be invalid, got %v", err)
+	}
+}
+
+func TestIntegrationScale(t *testing.T) {
+	f := newIntegrationFixture(t)
+	f.startController()
+
+	foo := f.createFoo("scale", 1)
+	f.waitForDeployment(foo, hasReplicas(1))
+
+	foo = f.updateFoo(foo.Name, func(foo *samplev1alpha1.Foo) {
+		foo.Spec.Replicas = pointer.Int32(3)
+	})
+	f.waitForDeployment(foo, hasReplicas(3))
+	f.waitForSynced(foo.Name)
+
+	// A change of the Deployment by someone else is reverted.
+	f.waitFor("Deployment to be scaled by hand", func(ctx context.Context) (bool, error) {
+		deployment, err := f.kubeclient.AppsV1().Deployments(f.namespace).Get(ctx, foo.Spec.DeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		deployment.Spec.Replicas = pointer.Int32(5)
+		_, err = f.kubeclient.AppsV1().Deployments(f.namespace).Update(ctx, deployment, metav1.UpdateOptions{})
+		if errors.IsConflict(err) {
+			return false, nil
+		}
+		return err == nil, err
+	})
+	f.waitForDeployment(foo, hasReplicas(3))
+}
+
+func TestIntegrationConflict(t *testing.T) {
+	f := newIntegrationFixture(t)
+	foo := f.createFoo("conflict", 1)
+
+	// Sync the Foo with the informers stopped after the Foo is changed, so
+	// the status is updated from the stale Foo in the cache.
+	controller, stopInformers := f.newController()
+	stopInformers()
+	f.updateFoo(foo.Name, func(foo *samplev1alpha1.Foo) {
+		foo.Labels = map[string]string{"changed": "true"}
+	})
+	_, ctx := ktesting.NewTestContext(t)
+	_, err := controller.syncHandler(ctx, types.NamespacedName{Namespace: f.namespace, Name: foo.Name})
+	if !errors.IsConflict(err) {
+		t.Fatalf("Expected a conflict updating the status of the stale Foo, got %v", err)
+	}
+
+	// The Deployment created before the conflict is taken over and the Foo
+	// converges once the cache catches up.
+	f.startController()
+	f.waitForSynced(foo.Name)
+	f.waitForDeployment(foo, hasReplicas(1))
+
+	// A Deployment of the same name not controlled by the Foo is left alone.
+	other := newDeployment(newFoo("other", pointer.Int32(1)))
+	other.Name = "taken"
+	other.Namespace = f.namespace
+	other.OwnerReferences = nil
+	delete(other.Labels, managedByLabel)
+	if _, err := f.kubeclient.AppsV1().Deployments(f.namespace).Create(context.Background(), other, metav1.CreateOptions{}); err != nil {
+		t.Fatalf("Failed to create Deployment: %v", err)
+	}
+	taken := newFoo("taken", pointer.Int32(2))
+	taken.Namespace = f.namespace
+	taken.Spec.DeploymentName = other.Name
+	taken = f.createFooObject(taken)
+	f.waitFor("ErrResourceExists Event", func(ctx context.Context) (bool, error) {
+		events, err := f.kubeclient.CoreV1().Events(f.namespace).List(ctx, metav1.ListOptions{FieldSelector: "involvedObject.name=" + taken.Name})
+		if err != nil {
+			return false, err
+		}
+		for _, event := range events.Items {
+			if event.Reason == ErrResourceExists {
+				return true, nil
+			}
+		}
+		return false, nil
+	})
+	deployment, err := f.kubeclient.AppsV1().Deployments(f.namespace).Get(context.Background(), other.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get Deployment: %v", err)
+	}
+	if len(deployment.OwnerReferences) != 0 || *deployment.Spec.Replicas != 1 {
+		t.Errorf("Deployment not controlled by the Foo is changed: %+v", deployment)
+	}
+}
+
+func TestIntegrationDeletion(t *testing.T) {
+	f := newIntegrationFixture(t)
+	f.startController()
+
+	foo := f.createFoo("deletion", 1)
+	deployment := f.waitForDeployment(foo, hasReplicas(1))
+	f.waitForSynced(foo.Name)
+
+	// The garbage collector deletes the Deployment from its owner reference,
+	// which must point at the Foo with blockOwnerDeletion.
+	owner := metav1.GetControllerOf(deployment)
+	if owner == nil || owner.UID != foo.UID || owner.BlockOwnerDeletion == nil || !*owner.BlockOwnerDeletion {
+		t.Errorf("Unexpected owner reference of the Deployment: %+v", owner)
+	}
+
+	if err := f.client.ExampleV1alpha1().Foos(f.namespace).Delete(context.Background(), foo.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete Foo: %v", err)
+	}
+	f.waitFor("Foo to be deleted", func(ctx context.Context) (bool, error) {
+		_, err := f.client.ExampleV1alpha1().Foos(f.namespace).Get(ctx, foo.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return true, nil
+		}
+		return false, err
+	})
+
+	// Emulate the garbage collector, and make sure the controller doesn't
+	// recreate the Deployment of the deleted Foo.
+	if err := f.kubeclient.AppsV1().Deployments(f.namespace).Delete(context.Background(), deployment.Name, metav1.DeleteOptions{}); err != nil {
+		t.Fatalf("Failed to delete Deployment: %v", err)
+	}
+	err := wait.PollUntilContextTimeout(context.Background(), integrationInterval, 2*time.Second, true, func(ctx context.Context) (bool, error) {
+		_, err := f.kubeclient.AppsV1().Deployments(f.namespace).Get(ctx, deployment.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return true, err
+	})
+	if err == nil {
+		t.Error("Deployment of the deleted Foo is recreated")
+	}
+}
+
+func TestIntegrationRestart(t *testing.T) {
+	f := newIntegrationFixture(t)
+	stop := f.startController()
+
+	foo := f.createFoo("restart", 1)
+	deployment := f.waitForDeployment(foo, hasReplicas(1))
+	f.waitForSynced(foo.Name)
+	stop()
+
+	// The change while the controller is down is reconciled after the
+	// restart without recreating the Deployment.
+	foo = f.updateFoo(foo.Name, func(foo *samplev1alpha1.Foo) {
+		foo.Spec.Replicas = pointer.Int32(4)
+	})
+	f.startController()
+	restarted := f.waitForDeployment(foo, hasReplicas(4))
+	if restarted.UID != deployment.UID {
+		t.Errorf("Deployment is recreated after the restart")
+	}
+	f.waitForSynced(foo.Name)
+}
+
+func TestIntegrationDryRun(t *testing.T) {
+	f := newIntegrationFixture(t)
+	stop := f.startController()
+	foo := f.createFoo("dry-run", 1)
+	f.waitForDeployment(foo, hasReplicas(1))
+	f.waitForSynced(foo.Name)
+	stop()
+
+	foo = f.updateFoo(foo.Name, func(foo *samplev1alpha1.Foo) {
+		foo.Spec.Replicas = pointer.Int32(3)
+	})
+	f.opts.DryRun = DryRunServer
+	controller, stopInformers := f.newController()
+	defer stopInformers()
+	f.waitFor("Foo to be cached", func(context.Context) (bool, error) {
+		cached, err := controller.foosLister.Foos(f.namespace).Get(foo.Name)
+		return err == nil && cached.Generation == foo.Generation, nil
+	})
+
+	// The writes are validated by the API server without being persisted.
+	_, ctx := ktesting.NewTestContext(t)
+	key := types.NamespacedName{Namespace: f.namespace, Name: foo.Name}
+	if _, err := controller.syncHandler(withDryRunKey(ctx, key), key); err != nil {
+		t.Fatalf("Error syncing Foo: %v", err)
+	}
+	controller.plan.end(key)
+
+	pending := controller.PendingChanges()
+	if len(pending) != 1 || pending[0].Name != foo.Name {
+		t.Fatalf("Expected the pending changes of the Foo, got %+v", pending)
+	}
+	var scaled, status bool
+	for _, change := range pending[0].Changes {
+		switch {
+		case change.Kind == "Deployment" && change.Verb == changeVerbUpdate:
+			for _, field := range change.Fields {
+				if field.Path == "spec.replicas" && field.Old == int64(1) && field.New == int64(3) {
+					scaled = true
+				}
+			}
+		case change.Kind == "Foo" && change.Subresource == "status":
+			status = true
+		}
+	}
+	if !scaled || !status {
+		t.Errorf("Expected the Deployment to be scaled and the status to be updated, got %+v", pending[0].Changes)
+	}
+	f.waitForDeployment(foo, hasReplicas(1))
+	latest, err := f.client.ExampleV1alpha1().Foos(f.namespace).Get(context.Background(), foo.Name, metav1.GetOptions{})
+	if err != nil {
+		t.Fatalf("Failed to get Foo: %v", err)
+	}
+	if latest.Status.ObservedGeneration == latest.Generation {
+		t.Errorf("Expected the status not to be updated in the dry run")
+	}
+}
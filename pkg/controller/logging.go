@@ -0,0 +1,147 @@
+package controller
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"sync"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+
+	"github.com/go-logr/logr"
+	"golang.org/x/time/rate"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// The formats of the logs, which are defined with the configuration.
+const (
+	// LogFormatText is the default text format of klog.
+	LogFormatText = configv1alpha1.LogFormatText
+	// LogFormatJSON is the JSON format with a log entry per line.
+	LogFormatJSON = configv1alpha1.LogFormatJSON
+)
+
+// Verbosity levels of the logs, which are set with the -v flag.
+const (
+	// logLevelDebug is for the decisions of the controller on every event
+	// and every reconcile, such as skipped events.
+	logLevelDebug = 4
+	// logLevelVerbose is for the results of the reconciles which change
+	// nothing.
+	logLevelVerbose = 2
+)
+
+// SetupLogging sets the logger of klog for the format. The JSON format
+// follows the verbosity of the -v flag of klog.
+func SetupLogging(format string) error {
+	return setupLogging(format, os.Stderr)
+}
+
+// setupLogging sets the logger of klog for the format, which writes the JSON
+// logs to w.
+func setupLogging(format string, w io.Writer) error {
+	switch format {
+	case LogFormatText:
+		return nil
+	case LogFormatJSON:
+		verbosity := 0
+		if f := flag.Lookup("v"); f != nil {
+			verbosity, _ = strconv.Atoi(f.Value.String())
+		}
+		handler := slog.NewJSONHandler(w, &slog.HandlerOptions{Level: slog.Level(-verbosity)})
+		klog.SetLogger(logr.FromSlogHandler(handler))
+		return nil
+	default:
+		return fmt.Errorf("unknown log format %q", format)
+	}
+}
+
+// logSampler limits the rate of the reconciles of each Foo that are logged,
+// so a Foo reconciled over and over doesn't flood the logs. The errors are
+// always logged.
+type logSampler struct {
+	mu       sync.Mutex
+	qps      rate.Limit
+	burst    int
+	limiters map[types.NamespacedName]*rate.Limiter
+}
+
+// newLogSampler returns a logSampler which logs burst reconciles of a Foo at
+// once and qps reconciles per second after that. All the reconciles are
+// logged if qps is not positive.
+func newLogSampler(qps float64, burst int) *logSampler {
+	s := &logSampler{}
+	s.SetRate(qps, burst)
+	return s
+}
+
+// SetRate replaces the rate of the reconciles of each Foo that are logged.
+// The counts of the Foos start over.
+func (s *logSampler) SetRate(qps float64, burst int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.qps = rate.Limit(qps)
+	s.burst = burst
+	s.limiters = map[types.NamespacedName]*rate.Limiter{}
+}
+
+// Sample returns the logger for a reconcile of the Foo, which only logs
+// errors if the reconcile is beyond the rate.
+func (s *logSampler) Sample(key types.NamespacedName, logger klog.Logger) klog.Logger {
+	s.mu.Lock()
+	if s.qps <= 0 {
+		s.mu.Unlock()
+		return logger
+	}
+	limiter, ok := s.limiters[key]
+	if !ok {
+		limiter = rate.NewLimiter(s.qps, s.burst)
+		s.limiters[key] = limiter
+	}
+	s.mu.Unlock()
+	if limiter.Allow() || logger.GetSink() == nil {
+		return logger
+	}
+	return logr.New(errorOnlySink{sink: logger.GetSink()})
+}
+
+// Forget removes the rate of the deleted Foo.
+func (s *logSampler) Forget(key types.NamespacedName) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.limiters, key)
+}
+
+// errorOnlySink is a logr.LogSink which drops all the info logs.
+type errorOnlySink struct {
+	sink logr.LogSink
+}
+
+var _ logr.LogSink = errorOnlySink{}
+
+func (s errorOnlySink) Init(info logr.RuntimeInfo) {
+	// The sink is already initialized by the logger it's taken from.
+}
+
+func (s errorOnlySink) Enabled(level int) bool {
+	return false
+}
+
+func (s errorOnlySink) Info(level int, msg string, keysAndValues ...interface{}) {
+}
+
+func (s errorOnlySink) Error(err error, msg string, keysAndValues ...interface{}) {
+	s.sink.Error(err, msg, keysAndValues...)
+}
+
+func (s errorOnlySink) WithValues(keysAndValues ...interface{}) logr.LogSink {
+	return errorOnlySink{sink: s.sink.WithValues(keysAndValues...)}
+}
+
+func (s errorOnlySink) WithName(name string) logr.LogSink {
+	return errorOnlySink{sink: s.sink.WithName(name)}
+}
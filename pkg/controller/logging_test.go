@@ -0,0 +1,93 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/go-logr/logr/funcr"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+)
+
+// TestLogSampler checks the reconciles of each Foo beyond the burst are
+// sampled out, except their errors.
+func TestLogSampler(t *testing.T) {
+	var logs []string
+	logger := funcr.New(func(prefix, args string) {
+		logs = append(logs, args)
+	}, funcr.Options{})
+	// The rate is low enough for no reconcile to be allowed after the burst
+	// during the test.
+	sampler := newLogSampler(0.001, 2)
+	a := types.NamespacedName{Namespace: "default", Name: "a"}
+	b := types.NamespacedName{Namespace: "default", Name: "b"}
+
+	for _, tc := range []struct {
+		key types.NamespacedName
+		err bool
+		// logged is true if the log of the reconcile is expected.
+		logged bool
+	}{
+		{key: a, logged: true},
+		{key: a, logged: true},
+		{key: a},
+		{key: b, logged: true},
+		{key: a, err: true, logged: true},
+		{key: b, logged: true},
+		{key: b, err: true, logged: true},
+		{key: b},
+	} {
+		logs = nil
+		sampled := sampler.Sample(tc.key, logger)
+		if tc.err {
+			sampled.Error(fmt.Errorf("failed"), "Error syncing Foo")
+		} else {
+			sampled.Info("Successfully synced Foo")
+		}
+		if logged := len(logs) > 0; logged != tc.logged {
+			t.Errorf("Expected the log of %v with error %v logged %v, got %v", tc.key, tc.err, tc.logged, logged)
+		}
+	}
+
+	sampler.Forget(a)
+	logs = nil
+	sampler.Sample(a, logger).Info("Successfully synced Foo")
+	if len(logs) == 0 {
+		t.Error("Expected the log of the forgotten Foo logged")
+	}
+}
+
+// TestSetupLogging checks the JSON format writes a JSON object per line with
+// the key-values of the logger.
+func TestSetupLogging(t *testing.T) {
+	var buf bytes.Buffer
+	if err := setupLogging(LogFormatJSON, &buf); err != nil {
+		t.Fatal(err)
+	}
+	defer klog.ClearLogger()
+
+	logger := klog.LoggerWithValues(klog.Background(), "foo", "test", "namespace", "default")
+	logger.Info("Successfully synced Foo")
+	logger.Error(fmt.Errorf("failed"), "Error syncing Foo")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines of logs, got %q", buf.String())
+	}
+	for _, line := range lines {
+		var entry map[string]interface{}
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected a JSON log, got %q: %v", line, err)
+		}
+		if entry["foo"] != "test" {
+			t.Errorf("Expected the foo key in %q", line)
+		}
+	}
+
+	if err := setupLogging("xml", &buf); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+// Values of the result label of reconcileTotal.
+const (
+	reconcileResultSuccess       = "success"
+	reconcileResultRequeue       = "requeue"
+	reconcileResultRequeueAfter  = "requeue_after"
+	reconcileResultError         = "error"
+	reconcileResultTerminalError = "terminal_error"
+	reconcileResultDropped       = "dropped"
+)
+
+// reconcileTotal counts the outcomes of syncHandler.
+var reconcileTotal = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "sample_controller_reconcile_total",
+		Help: "Total number of reconciles of Foos per result.",
+	},
+	[]string{"result"},
+)
+
+// The metrics of the workqueues by the name of the queue.
+var (
+	workqueueDepth = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_depth",
+		Help: "Current depth of the workqueue.",
+	}, []string{"name"})
+	workqueueAdds = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workqueue_adds_total",
+		Help: "Total number of adds to the workqueue.",
+	}, []string{"name"})
+	workqueueLatency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "workqueue_queue_duration_seconds",
+		Help:    "How long in seconds an item stays in the workqueue before being processed.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	workqueueWorkDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "workqueue_work_duration_seconds",
+		Help:    "How long in seconds processing an item from the workqueue takes.",
+		Buckets: prometheus.ExponentialBuckets(10e-9, 10, 10),
+	}, []string{"name"})
+	workqueueUnfinishedWork = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_unfinished_work_seconds",
+		Help: "How many seconds of work has been done that is in progress and hasn't been observed by work_duration.",
+	}, []string{"name"})
+	workqueueLongestRunningProcessor = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "workqueue_longest_running_processor_seconds",
+		Help: "How many seconds has the longest running processor for the workqueue been running.",
+	}, []string{"name"})
+	workqueueRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "workqueue_retries_total",
+		Help: "Total number of retries handled by the workqueue.",
+	}, []string{"name"})
+)
+
+func init() {
+	prometheus.MustRegister(
+		reconcileTotal,
+		workqueueDepth,
+		workqueueAdds,
+		workqueueLatency,
+		workqueueWorkDuration,
+		workqueueUnfinishedWork,
+		workqueueLongestRunningProcessor,
+		workqueueRetries,
+	)
+	workqueue.SetProvider(workqueueMetricsProvider{})
+}
+
+// workqueueMetricsProvider exports the metrics of the named workqueues, such
+// as the depth of the foo queue.
+type workqueueMetricsProvider struct{}
+
+func (workqueueMetricsProvider) NewDepthMetric(name string) workqueue.GaugeMetric {
+	return workqueueDepth.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewAddsMetric(name string) workqueue.CounterMetric {
+	return workqueueAdds.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLatencyMetric(name string) workqueue.HistogramMetric {
+	return workqueueLatency.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewWorkDurationMetric(name string) workqueue.HistogramMetric {
+	return workqueueWorkDuration.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewUnfinishedWorkSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueUnfinishedWork.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewLongestRunningProcessorSecondsMetric(name string) workqueue.SettableGaugeMetric {
+	return workqueueLongestRunningProcessor.WithLabelValues(name)
+}
+
+func (workqueueMetricsProvider) NewRetriesMetric(name string) workqueue.CounterMetric {
+	return workqueueRetries.WithLabelValues(name)
+}
+
+// metricsShutdownTimeout is the time to wait for the requests in flight when
+// the metrics server shuts down.
+const metricsShutdownTimeout = 5 * time.Second
+
+// ServeMetrics serves the metrics at /metrics, and the handlers at their
+// patterns, on the address in background until the context is cancelled.
+// Nothing is served if the address is "0".
+func ServeMetrics(ctx context.Context, addr string, handlers map[string]http.Handler) {
+	logger := klog.FromContext(ctx)
+	if addr == "0" {
+		return
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	for pattern, handler := range handlers {
+		mux.Handle(pattern, handler)
+	}
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		logger.Info("Serving metrics", "address", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logger.Error(err, "Failed to serve metrics")
+		}
+	}()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), metricsShutdownTimeout)
+		defer cancel()
+		if err := srv.Shutdown(shutdownCtx); err != nil {
+			logger.Error(err, "Failed to shut down the metrics server")
+		}
+	}()
+}
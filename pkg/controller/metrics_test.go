@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2/ktesting"
+)
+
+// TestServeMetrics checks the metrics are served until the context is
+// cancelled.
+func TestServeMetrics(t *testing.T) {
+	_, ctx := ktesting.NewTestContext(t)
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	// Reserve a free port for the server.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+
+	ServeMetrics(ctx, addr, nil)
+	get := func() error {
+		resp, err := http.Get("http://" + addr + "/metrics")
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		return nil
+	}
+	err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return get() == nil, nil
+	})
+	if err != nil {
+		t.Fatalf("Expected the metrics served: %v", err)
+	}
+
+	cancel()
+	err = wait.PollUntilContextTimeout(context.Background(), 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return get() != nil, nil
+	})
+	if err != nil {
+		t.Errorf("Expected the metrics server shut down after the context is cancelled: %v", err)
+	}
+}
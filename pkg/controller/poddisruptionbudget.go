@@ -0,0 +1,140 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// DisruptionBudgetSkipped is used as part of the Event 'reason' when the
+	// PodDisruptionBudget of a Foo is not created because it would block
+	// node drains.
+	DisruptionBudgetSkipped = "DisruptionBudgetSkipped"
+
+	// MessageDisruptionBudgetSkipped is the message used for an Event fired
+	// when the PodDisruptionBudget of a Foo is skipped
+	MessageDisruptionBudgetSkipped = "PodDisruptionBudget %q is skipped as it would block evictions of the only replica"
+)
+
+// syncPodDisruptionBudget makes the PodDisruptionBudget owned by the Foo
+// match spec.disruptionBudget. The PodDisruptionBudget is deleted when the
+// budget is removed from the Foo or when it would block node drains.
+func (c *Controller) syncPodDisruptionBudget(ctx context.Context, foo *samplev1alpha1.Foo) error {
+	ctx, span := c.tracer.Start(ctx, "syncPodDisruptionBudget")
+	defer span.End()
+	logger := klog.FromContext(ctx)
+	pdb, err := c.pdbsLister.PodDisruptionBudgets(foo.Namespace).Get(foo.Spec.DeploymentName)
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+	if errors.IsNotFound(err) {
+		pdb = nil
+	}
+
+	budget := foo.Spec.DisruptionBudget
+	if budget != nil && getReplicas(foo) == 1 && blocksEviction(budget, 1) {
+		msg := fmt.Sprintf(MessageDisruptionBudgetSkipped, foo.Spec.DeploymentName)
+		c.event(ctx, foo, corev1.EventTypeWarning, DisruptionBudgetSkipped, msg)
+		logger.Info("Skipping PodDisruptionBudget blocking evictions of the only replica", "podDisruptionBudget", klog.KRef(foo.Namespace, foo.Spec.DeploymentName))
+		budget = nil
+	}
+
+	if budget == nil {
+		if pdb == nil || !metav1.IsControlledBy(pdb, foo) {
+			return nil
+		}
+		logger.Info("Deleting PodDisruptionBudget", "podDisruptionBudget", klog.KObj(pdb))
+		err = c.kubeclientset.PolicyV1().PodDisruptionBudgets(pdb.Namespace).Delete(ctx, pdb.Name, metav1.DeleteOptions{})
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return err
+	}
+
+	if pdb == nil {
+		pdb, err = c.kubeclientset.PolicyV1().PodDisruptionBudgets(foo.Namespace).Create(ctx, newPodDisruptionBudget(foo), metav1.CreateOptions{})
+		if err != nil {
+			return err
+		}
+		c.eventf(ctx, foo, corev1.EventTypeNormal, Created, MessageCreated, "PodDisruptionBudget", pdb.Name)
+		return nil
+	}
+
+	// If the PodDisruptionBudget is not controlled by this Foo resource, we
+	// should log a warning to the event recorder and return error msg.
+	if !metav1.IsControlledBy(pdb, foo) {
+		msg := fmt.Sprintf(MessageResourceExists, pdb.Name)
+		c.event(ctx, foo, corev1.EventTypeWarning, ErrResourceExists, msg)
+		logger.Info("PodDisruptionBudget already exists and is not managed by Foo", "podDisruptionBudget", klog.KObj(pdb))
+		return fmt.Errorf("%s", msg)
+	}
+
+	desired := newPodDisruptionBudget(foo)
+	if equality.Semantic.DeepEqual(pdb.Spec, desired.Spec) {
+		return nil
+	}
+	// NEVER modify objects from the store.
+	pdbCopy := pdb.DeepCopy()
+	pdbCopy.Spec = desired.Spec
+	_, err = c.kubeclientset.PolicyV1().PodDisruptionBudgets(foo.Namespace).Update(ctx, pdbCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return err
+	}
+	c.recordUpdate(ctx, foo, "PodDisruptionBudget", pdb.Name)
+	return nil
+}
+
+// blocksEviction returns true if the budget doesn't allow any pod out of the
+// given number of replicas to be evicted.
+func blocksEviction(budget *samplev1alpha1.FooDisruptionBudget, replicas int) bool {
+	if budget.MaxUnavailable != nil {
+		// The disruption controller rounds up a percentage of MaxUnavailable.
+		maxUnavailable, err := intstr.GetScaledValueFromIntOrPercent(budget.MaxUnavailable, replicas, true)
+		return err == nil && maxUnavailable <= 0
+	}
+	if budget.MinAvailable != nil {
+		minAvailable, err := intstr.GetScaledValueFromIntOrPercent(budget.MinAvailable, replicas, true)
+		return err == nil && minAvailable >= replicas
+	}
+	return false
+}
+
+// getReplicas returns the desired number of replicas of the Foo, which is 1
+// when it's not specified as in Deployment. The minimum number of replicas is
+// returned while autoscaling is specified.
+func getReplicas(foo *samplev1alpha1.Foo) int {
+	if foo.Spec.Autoscaling != nil {
+		return int(getMinReplicas(foo.Spec.Autoscaling))
+	}
+	if foo.Spec.Replicas == nil {
+		return 1
+	}
+	return int(*foo.Spec.Replicas)
+}
+
+func newPodDisruptionBudget(foo *samplev1alpha1.Foo) *policyv1.PodDisruptionBudget {
+	return &policyv1.PodDisruptionBudget{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            foo.Spec.DeploymentName,
+			Namespace:       foo.Namespace,
+			OwnerReferences: []metav1.OwnerReference{*metav1.NewControllerRef(foo, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"))},
+		},
+		Spec: policyv1.PodDisruptionBudgetSpec{
+			MinAvailable:   foo.Spec.DisruptionBudget.MinAvailable,
+			MaxUnavailable: foo.Spec.DisruptionBudget.MaxUnavailable,
+			Selector: &metav1.LabelSelector{
+				MatchLabels: deploymentLabels(foo),
+			},
+		},
+	}
+}
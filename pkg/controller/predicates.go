@@ -0,0 +1,46 @@
+package controller
+
+import (
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	"k8s.io/apimachinery/pkg/api/equality"
+)
+
+// fooPredicate returns true if the update of a Foo from old to new needs a
+// sync.
+type fooPredicate func(old, new *samplev1alpha1.Foo) bool
+
+// fooUpdatePredicates are the predicates of the updates of Foos to enqueue.
+// The updates of the status only, including the ones by the controller
+// itself, match none of them.
+var fooUpdatePredicates = []fooPredicate{
+	generationChanged,
+	labelsChanged,
+	annotationsChanged,
+}
+
+// generationChanged returns true if the spec of the Foo has changed.
+func generationChanged(old, new *samplev1alpha1.Foo) bool {
+	return old.Generation != new.Generation
+}
+
+// labelsChanged returns true if the labels of the Foo have changed.
+func labelsChanged(old, new *samplev1alpha1.Foo) bool {
+	return !equality.Semantic.DeepEqual(old.Labels, new.Labels)
+}
+
+// annotationsChanged returns true if the annotations of the Foo have changed,
+// such as the rollback and canary annotations.
+func annotationsChanged(old, new *samplev1alpha1.Foo) bool {
+	return !equality.Semantic.DeepEqual(old.Annotations, new.Annotations)
+}
+
+// anyPredicate returns true if any of the predicates is true for the update.
+func anyPredicate(predicates []fooPredicate, old, new *samplev1alpha1.Foo) bool {
+	for _, predicate := range predicates {
+		if predicate(old, new) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,193 @@
+package controller
+
+import (
+	"sync"
+	"time"
+
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+)
+
+// Trigger is the source which enqueued a Foo.
+type Trigger string
+
+const (
+	// TriggerFooAdd is the trigger when a Foo is added.
+	TriggerFooAdd Trigger = "FooAdd"
+	// TriggerFooUpdate is the trigger when a Foo is updated.
+	TriggerFooUpdate Trigger = "FooUpdate"
+	// TriggerFooDelete is the trigger when a Foo is deleted.
+	TriggerFooDelete Trigger = "FooDelete"
+	// TriggerResync is the trigger of the periodic resync of the informer.
+	TriggerResync Trigger = "Resync"
+	// TriggerDeploymentChange is the trigger when a Deployment of a Foo is
+	// added, updated or deleted.
+	TriggerDeploymentChange Trigger = "DeploymentChange"
+	// TriggerPodDisruptionBudgetChange is the trigger when a
+	// PodDisruptionBudget of a Foo is added, updated or deleted.
+	TriggerPodDisruptionBudgetChange Trigger = "PodDisruptionBudgetChange"
+	// TriggerHorizontalPodAutoscalerChange is the trigger when a
+	// HorizontalPodAutoscaler of a Foo is added, updated or deleted.
+	TriggerHorizontalPodAutoscalerChange Trigger = "HorizontalPodAutoscalerChange"
+	// TriggerPodChange is the trigger when a pod of a Foo is added, updated
+	// or deleted.
+	TriggerPodChange Trigger = "PodChange"
+	// TriggerShardRebalance is the trigger when a Foo moves to the local
+	// shard from another replica.
+	TriggerShardRebalance Trigger = "ShardRebalance"
+	// TriggerRequeue is the trigger when a Foo is put back on the workqueue
+	// by processNextWorkItem.
+	TriggerRequeue Trigger = "Requeue"
+	// TriggerExternal is the trigger when a Foo is enqueued from outside of
+	// the informers.
+	TriggerExternal Trigger = "External"
+)
+
+// typedRateLimitingQueue is a type-safe wrapper of
+// workqueue.RateLimitingInterface, whose items are always T.
+type typedRateLimitingQueue[T comparable] struct {
+	queue workqueue.RateLimitingInterface
+}
+
+func newTypedRateLimitingQueue[T comparable](queue workqueue.RateLimitingInterface) *typedRateLimitingQueue[T] {
+	return &typedRateLimitingQueue[T]{queue: queue}
+}
+
+func (q *typedRateLimitingQueue[T]) Add(item T) {
+	q.queue.Add(item)
+}
+
+func (q *typedRateLimitingQueue[T]) AddAfter(item T, duration time.Duration) {
+	q.queue.AddAfter(item, duration)
+}
+
+func (q *typedRateLimitingQueue[T]) AddRateLimited(item T) {
+	q.queue.AddRateLimited(item)
+}
+
+func (q *typedRateLimitingQueue[T]) Forget(item T) {
+	q.queue.Forget(item)
+}
+
+func (q *typedRateLimitingQueue[T]) NumRequeues(item T) int {
+	return q.queue.NumRequeues(item)
+}
+
+func (q *typedRateLimitingQueue[T]) Done(item T) {
+	q.queue.Done(item)
+}
+
+func (q *typedRateLimitingQueue[T]) Len() int {
+	return q.queue.Len()
+}
+
+func (q *typedRateLimitingQueue[T]) ShutDown() {
+	q.queue.ShutDown()
+}
+
+// Get blocks until it can return an item to be processed. shutdown is true
+// when the queue is shutting down.
+func (q *typedRateLimitingQueue[T]) Get() (item T, shutdown bool) {
+	obj, shutdown := q.queue.Get()
+	if shutdown {
+		return item, true
+	}
+	// Only T can be added to the queue.
+	return obj.(T), false
+}
+
+// fooQueue is the workqueue of the keys of Foos, which records the triggers
+// and the spans of the enqueue sources of each key until it's processed. They
+// are not part of the items so that a Foo enqueued by several sources is
+// processed only once.
+type fooQueue struct {
+	*typedRateLimitingQueue[types.NamespacedName]
+
+	mu       sync.Mutex
+	triggers map[types.NamespacedName][]Trigger
+	links    map[types.NamespacedName][]trace.Link
+}
+
+// newFooQueue returns a fooQueue whose delays are measured with the clock.
+func newFooQueue(rateLimiter workqueue.RateLimiter, clock clock.WithTicker) *fooQueue {
+	return newFooQueueFrom(workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{
+		Name:  "foo",
+		Clock: clock,
+	}))
+}
+
+// newFooQueueFrom returns a fooQueue on the queue, whose items must be the
+// keys of Foos.
+func newFooQueueFrom(queue workqueue.RateLimitingInterface) *fooQueue {
+	return &fooQueue{
+		typedRateLimitingQueue: newTypedRateLimitingQueue[types.NamespacedName](queue),
+		triggers:               map[types.NamespacedName][]Trigger{},
+		links:                  map[types.NamespacedName][]trace.Link{},
+	}
+}
+
+// Enqueue adds the key to the queue and records the trigger and the span of
+// the enqueue source, which is ignored if it's invalid.
+func (q *fooQueue) Enqueue(key types.NamespacedName, trigger Trigger, source trace.SpanContext) {
+	q.mu.Lock()
+	if !containsTrigger(q.triggers[key], trigger) {
+		q.triggers[key] = append(q.triggers[key], trigger)
+	}
+	if source.IsValid() {
+		q.links[key] = append(q.links[key], trace.Link{SpanContext: source})
+	}
+	q.mu.Unlock()
+	q.Add(key)
+}
+
+// PopTriggers returns the triggers recorded for the key since it was last
+// popped. TriggerRequeue is returned if there's no trigger as the key is
+// put back on the queue by processNextWorkItem.
+func (q *fooQueue) PopTriggers(key types.NamespacedName) []Trigger {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	triggers, ok := q.triggers[key]
+	if !ok {
+		return []Trigger{TriggerRequeue}
+	}
+	delete(q.triggers, key)
+	return triggers
+}
+
+// PopLinks returns the links to the spans of the enqueue sources recorded for
+// the key since it was last popped.
+func (q *fooQueue) PopLinks(key types.NamespacedName) []trace.Link {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	links := q.links[key]
+	delete(q.links, key)
+	return links
+}
+
+// PeekTriggers returns the triggers recorded for the key without removing
+// them.
+func (q *fooQueue) PeekTriggers(key types.NamespacedName) []Trigger {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return append([]Trigger(nil), q.triggers[key]...)
+}
+
+// triggerStrings returns the triggers as strings for the attributes of spans.
+func triggerStrings(triggers []Trigger) []string {
+	strs := make([]string, 0, len(triggers))
+	for _, trigger := range triggers {
+		strs = append(strs, string(trigger))
+	}
+	return strs
+}
+
+func containsTrigger(triggers []Trigger, trigger Trigger) bool {
+	for _, t := range triggers {
+		if t == trigger {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"time"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+
+	"golang.org/x/time/rate"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/utils/clock"
+)
+
+// RateLimiterOptions configures the rate limiter of the workqueue, which is
+// the max of a per-item exponential backoff and an overall token bucket.
+// The defaults are the same as workqueue.DefaultControllerRateLimiter.
+type RateLimiterOptions struct {
+	// BaseDelay is the delay of the first retry of an item, which is doubled
+	// on every failure of the item.
+	BaseDelay time.Duration
+	// MaxDelay is the upper limit of the delay of an item.
+	MaxDelay time.Duration
+	// QPS is the overall rate of retries of all the items.
+	QPS float64
+	// Burst is the bucket size of the overall rate of retries.
+	Burst int
+}
+
+// DefaultRateLimiterOptions returns the options of
+// workqueue.DefaultControllerRateLimiter.
+func DefaultRateLimiterOptions() RateLimiterOptions {
+	return RateLimiterOptions{
+		BaseDelay: configv1alpha1.DefaultQueueBaseDelay,
+		MaxDelay:  configv1alpha1.DefaultQueueMaxDelay,
+		QPS:       configv1alpha1.DefaultQueueQPS,
+		Burst:     configv1alpha1.DefaultQueueBurst,
+	}
+}
+
+// NewRateLimiter returns the rate limiter of the workqueue. The token bucket
+// reads the time from the given clock, so the backoff can be asserted
+// deterministically with a fake clock.
+func NewRateLimiter(opts RateLimiterOptions, clock clock.PassiveClock) workqueue.RateLimiter {
+	return workqueue.NewMaxOfRateLimiter(
+		workqueue.NewItemExponentialFailureRateLimiter(opts.BaseDelay, opts.MaxDelay),
+		&bucketRateLimiter{
+			limiter: rate.NewLimiter(rate.Limit(opts.QPS), opts.Burst),
+			clock:   clock,
+		},
+	)
+}
+
+// bucketRateLimiter is workqueue.BucketRateLimiter with a clock.
+type bucketRateLimiter struct {
+	limiter *rate.Limiter
+	clock   clock.PassiveClock
+}
+
+var _ workqueue.RateLimiter = &bucketRateLimiter{}
+
+func (r *bucketRateLimiter) When(item interface{}) time.Duration {
+	now := r.clock.Now()
+	return r.limiter.ReserveN(now, 1).DelayFrom(now)
+}
+
+func (r *bucketRateLimiter) NumRequeues(item interface{}) int {
+	return 0
+}
+
+func (r *bucketRateLimiter) Forget(item interface{}) {
+}
@@ -0,0 +1,75 @@
+package controller
+
+import (
+	"testing"
+	"time"
+
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+// TestRateLimiter checks the delays of the retries are the max of the
+// per-item exponential backoff and the overall token bucket, whose tokens
+// are refilled by stepping the fake clock.
+func TestRateLimiter(t *testing.T) {
+	type step struct {
+		// elapse is the time passed before the step.
+		elapse time.Duration
+		// forget forgets the item instead of retrying it.
+		forget bool
+		item   string
+		want   time.Duration
+	}
+	for _, tc := range []struct {
+		name  string
+		opts  RateLimiterOptions
+		steps []step
+	}{
+		{
+			name: "exponential backoff",
+			opts: RateLimiterOptions{BaseDelay: time.Second, MaxDelay: 5 * time.Second, QPS: 100, Burst: 100},
+			steps: []step{
+				{item: "a", want: time.Second},
+				{item: "a", want: 2 * time.Second},
+				{item: "b", want: time.Second},
+				{item: "a", want: 4 * time.Second},
+				{item: "a", want: 5 * time.Second},
+				{item: "a", want: 5 * time.Second},
+				{item: "a", forget: true},
+				{item: "a", want: time.Second},
+			},
+		},
+		{
+			name: "token bucket",
+			opts: RateLimiterOptions{BaseDelay: 5 * time.Millisecond, MaxDelay: time.Minute, QPS: 1, Burst: 2},
+			steps: []step{
+				{item: "a", want: 5 * time.Millisecond},
+				{item: "b", want: 5 * time.Millisecond},
+				// The burst is used up, so the next token is available
+				// in a second, and the one after in two.
+				{item: "c", want: time.Second},
+				{item: "d", want: 2 * time.Second},
+				// A token is left after three seconds.
+				{elapse: 3 * time.Second, item: "e", want: 5 * time.Millisecond},
+				{item: "a", want: time.Second},
+				// The bucket is refilled up to the burst, so the backoff of
+				// the item applies.
+				{elapse: 10 * time.Second, item: "a", want: 20 * time.Millisecond},
+			},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := testingclock.NewFakePassiveClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC))
+			rateLimiter := NewRateLimiter(tc.opts, clock)
+			for i, step := range tc.steps {
+				clock.SetTime(clock.Now().Add(step.elapse))
+				if step.forget {
+					rateLimiter.Forget(step.item)
+					continue
+				}
+				if got := rateLimiter.When(step.item); got != step.want {
+					t.Errorf("step %d: expected a delay of %v for %q, got %v", i, step.want, step.item, got)
+				}
+			}
+		})
+	}
+}
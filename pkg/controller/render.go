@@ -0,0 +1,152 @@
+package controller
+
+import (
+	"fmt"
+	"strings"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	corev1 "k8s.io/api/core/v1"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/pointer"
+)
+
+// The defaults set by the API server on the fields of the Deployments which
+// aren't set by the controller.
+const (
+	defaultRevisionHistoryLimit          int32 = 10
+	defaultProgressDeadlineSeconds       int32 = 600
+	defaultTerminationGracePeriodSeconds int64 = 30
+)
+
+// Render returns the objects the controller creates for the Foo as the API
+// server stores them with their defaults: the Deployment, and the canary
+// Deployment, PodDisruptionBudget and HorizontalPodAutoscaler when they're
+// specified. It doesn't need a cluster, so the state of the existing objects,
+// such as the paused rollouts, isn't taken into account.
+func Render(foo *samplev1alpha1.Foo) ([]runtime.Object, error) {
+	if foo.Spec.DeploymentName == "" {
+		return nil, fmt.Errorf("deploymentName of Foo %s must be specified", klog.KObj(foo))
+	}
+	deployment := newDeployment(foo)
+	setDeploymentDefaults(deployment)
+	deployment.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+	objects := []runtime.Object{deployment}
+
+	if foo.Spec.Canary != nil {
+		canary := newCanaryDeployment(foo, deployment)
+		setDeploymentDefaults(canary)
+		canary.SetGroupVersionKind(appsv1.SchemeGroupVersion.WithKind("Deployment"))
+		objects = append(objects, canary)
+	}
+	// The PodDisruptionBudget blocking evictions of the only replica is
+	// skipped as in syncPodDisruptionBudget.
+	if budget := foo.Spec.DisruptionBudget; budget != nil && !(getReplicas(foo) == 1 && blocksEviction(budget, 1)) {
+		pdb := newPodDisruptionBudget(foo)
+		pdb.SetGroupVersionKind(policyv1.SchemeGroupVersion.WithKind("PodDisruptionBudget"))
+		objects = append(objects, pdb)
+	}
+	if foo.Spec.Autoscaling != nil {
+		hpa := newHorizontalPodAutoscaler(foo)
+		hpa.SetGroupVersionKind(autoscalingv2.SchemeGroupVersion.WithKind("HorizontalPodAutoscaler"))
+		objects = append(objects, hpa)
+	}
+	return objects, nil
+}
+
+// setDeploymentDefaults sets the defaults of the API server on the fields of
+// the Deployment and its pod template left empty by the controller.
+func setDeploymentDefaults(deployment *appsv1.Deployment) {
+	spec := &deployment.Spec
+	if spec.Replicas == nil {
+		spec.Replicas = pointer.Int32(1)
+	}
+	spec.Strategy = defaultDeploymentStrategy(spec.Strategy)
+	if spec.RevisionHistoryLimit == nil {
+		spec.RevisionHistoryLimit = pointer.Int32(defaultRevisionHistoryLimit)
+	}
+	if spec.ProgressDeadlineSeconds == nil {
+		spec.ProgressDeadlineSeconds = pointer.Int32(defaultProgressDeadlineSeconds)
+	}
+
+	podSpec := &spec.Template.Spec
+	if podSpec.RestartPolicy == "" {
+		podSpec.RestartPolicy = corev1.RestartPolicyAlways
+	}
+	if podSpec.TerminationGracePeriodSeconds == nil {
+		podSpec.TerminationGracePeriodSeconds = pointer.Int64(defaultTerminationGracePeriodSeconds)
+	}
+	if podSpec.DNSPolicy == "" {
+		podSpec.DNSPolicy = corev1.DNSClusterFirst
+	}
+	if podSpec.SecurityContext == nil {
+		podSpec.SecurityContext = &corev1.PodSecurityContext{}
+	}
+	if podSpec.SchedulerName == "" {
+		podSpec.SchedulerName = corev1.DefaultSchedulerName
+	}
+	for i := range podSpec.Containers {
+		container := &podSpec.Containers[i]
+		if container.TerminationMessagePath == "" {
+			container.TerminationMessagePath = corev1.TerminationMessagePathDefault
+		}
+		if container.TerminationMessagePolicy == "" {
+			container.TerminationMessagePolicy = corev1.TerminationMessageReadFile
+		}
+		if container.ImagePullPolicy == "" {
+			container.ImagePullPolicy = defaultImagePullPolicy(container.Image)
+		}
+		for j := range container.Ports {
+			if container.Ports[j].Protocol == "" {
+				container.Ports[j].Protocol = corev1.ProtocolTCP
+			}
+		}
+		for j := range container.Env {
+			if ref := container.Env[j].ValueFrom; ref != nil && ref.FieldRef != nil && ref.FieldRef.APIVersion == "" {
+				ref.FieldRef.APIVersion = "v1"
+			}
+		}
+	}
+}
+
+// defaultImagePullPolicy returns the pull policy defaulted by the API server,
+// which is Always for the latest tag or no tag, and IfNotPresent otherwise.
+func defaultImagePullPolicy(image string) corev1.PullPolicy {
+	if strings.Contains(image, "@") {
+		return corev1.PullIfNotPresent
+	}
+	name := image[strings.LastIndex(image, "/")+1:]
+	if _, tag, ok := strings.Cut(name, ":"); !ok || tag == "latest" {
+		return corev1.PullAlways
+	}
+	return corev1.PullIfNotPresent
+}
+
+// DiffRendered returns the changes of the labels and the spec from the live
+// object to the rendered one. The other fields, such as the annotations and
+// the status, are set by the API server and the other controllers.
+func DiffRendered(live, rendered runtime.Object) ([]FieldChange, error) {
+	var objects [2]map[string]interface{}
+	for i, obj := range []runtime.Object{live, rendered} {
+		u, err := runtime.DefaultUnstructuredConverter.ToUnstructured(obj)
+		if err != nil {
+			return nil, err
+		}
+		labels, _, err := unstructured.NestedFieldNoCopy(u, "metadata", "labels")
+		if err != nil {
+			return nil, err
+		}
+		objects[i] = map[string]interface{}{
+			"metadata": map[string]interface{}{"labels": labels},
+			"spec":     u["spec"],
+		}
+	}
+	var changes []FieldChange
+	diffValues("", objects[0], objects[1], &changes)
+	return changes, nil
+}
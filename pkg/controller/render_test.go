@@ -0,0 +1,86 @@
+package controller
+
+import (
+	"testing"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/utils/pointer"
+)
+
+func TestRender(t *testing.T) {
+	minAvailable := intstr.FromInt(1)
+	for _, tc := range []struct {
+		name  string
+		spec  func(*samplev1alpha1.FooSpec)
+		kinds []string
+	}{
+		{
+			name:  "deployment",
+			kinds: []string{"Deployment"},
+		},
+		{
+			name: "canary and autoscaling",
+			spec: func(spec *samplev1alpha1.FooSpec) {
+				spec.Canary = &samplev1alpha1.FooCanary{Template: samplev1alpha1.FooCanaryTemplate{Image: "nginx:1.25"}}
+				spec.Autoscaling = &samplev1alpha1.FooAutoscaling{MinReplicas: pointer.Int32(2), MaxReplicas: 5}
+			},
+			kinds: []string{"Deployment", "Deployment", "HorizontalPodAutoscaler"},
+		},
+		{
+			name: "disruption budget",
+			spec: func(spec *samplev1alpha1.FooSpec) {
+				spec.Replicas = pointer.Int32(2)
+				spec.DisruptionBudget = &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable}
+			},
+			kinds: []string{"Deployment", "PodDisruptionBudget"},
+		},
+		{
+			name: "disruption budget blocking evictions",
+			spec: func(spec *samplev1alpha1.FooSpec) {
+				spec.DisruptionBudget = &samplev1alpha1.FooDisruptionBudget{MinAvailable: &minAvailable}
+			},
+			kinds: []string{"Deployment"},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			foo := newFoo("test", pointer.Int32(1))
+			if tc.spec != nil {
+				tc.spec(&foo.Spec)
+			}
+			objects, err := Render(foo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var kinds []string
+			for _, obj := range objects {
+				kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+			}
+			if len(kinds) != len(tc.kinds) {
+				t.Fatalf("Expected %v, got %v", tc.kinds, kinds)
+			}
+			for i := range kinds {
+				if kinds[i] != tc.kinds[i] {
+					t.Fatalf("Expected %v, got %v", tc.kinds, kinds)
+				}
+			}
+		})
+	}
+}
+
+func TestDefaultImagePullPolicy(t *testing.T) {
+	for image, want := range map[string]corev1.PullPolicy{
+		"nginx":                         corev1.PullAlways,
+		"nginx:latest":                  corev1.PullAlways,
+		"nginx:1.25":                    corev1.PullIfNotPresent,
+		"localhost:5000/nginx":          corev1.PullAlways,
+		"localhost:5000/nginx:1.25":     corev1.PullIfNotPresent,
+		"nginx@sha256:0123456789abcdef": corev1.PullIfNotPresent,
+	} {
+		if got := defaultImagePullPolicy(image); got != want {
+			t.Errorf("Expected %s for %q, got %s", want, image, got)
+		}
+	}
+}
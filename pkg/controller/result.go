@@ -0,0 +1,43 @@
+package controller
+
+import (
+	"errors"
+	"time"
+)
+
+// Result is the result of syncHandler, which tells processNextWorkItem
+// whether and when the Foo should be processed again.
+type Result struct {
+	// Requeue puts the Foo back on the workqueue with the rate limiter.
+	Requeue bool
+	// RequeueAfter puts the Foo back on the workqueue after the duration if
+	// it's greater than 0. It takes precedence over Requeue.
+	RequeueAfter time.Duration
+}
+
+// terminalError is an error which can't be fixed by retrying, such as an
+// invalid Foo, so the Foo is not requeued until it changes.
+type terminalError struct {
+	err error
+}
+
+func (e *terminalError) Error() string {
+	return e.err.Error()
+}
+
+func (e *terminalError) Unwrap() error {
+	return e.err
+}
+
+// newTerminalError wraps the error so that syncHandler doesn't requeue the
+// Foo for it.
+func newTerminalError(err error) error {
+	return &terminalError{err: err}
+}
+
+// isTerminalError returns true if the error or any error it wraps is a
+// terminal error.
+func isTerminalError(err error) bool {
+	var terminal *terminalError
+	return errors.As(err, &terminal)
+}
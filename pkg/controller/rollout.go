@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/rand"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// RollbackAnnotation is the annotation on a Foo to request a rollback of
+	// the Deployment to the pod template of the previous rollout. The
+	// controller removes it once the rollback is processed.
+	RollbackAnnotation = "example.com/rollback"
+
+	// rolloutPausedAnnotation is the annotation on a Deployment whose
+	// rollout is paused by the controller, so the controller only resumes
+	// the rollouts it paused.
+	rolloutPausedAnnotation = "example.com/rollout-paused"
+
+	// ConditionTypeProgressing is the condition type of a Foo which reports
+	// the progress of the rollout of the Deployment.
+	ConditionTypeProgressing = "Progressing"
+
+	// RolledBack is used as part of the Event 'reason' when the Deployment of
+	// a Foo is rolled back
+	RolledBack = "RolledBack"
+	// RollbackFailed is used as part of the Event 'reason' when the rollback
+	// of a Foo can't be processed
+	RollbackFailed = "RollbackFailed"
+
+	// MessageRolledBack is the message used for an Event fired when the
+	// Deployment of a Foo is rolled back
+	MessageRolledBack = "Deployment %q is rolled back to the pod template %q"
+	// MessageRollbackNoPrevious is the message used for an Event fired when
+	// a rollback is requested without any previous rollout
+	MessageRollbackNoPrevious = "no previous pod template is recorded in status"
+	// MessageRollbackNotFound is the message used for an Event fired when
+	// the ReplicaSet of the previous rollout doesn't exist anymore
+	MessageRollbackNotFound = "no ReplicaSet is found for the pod template %q"
+
+	// Reasons of the Progressing condition.
+	reasonRolloutInProgress = "RolloutInProgress"
+	reasonRolloutPaused     = "RolloutPaused"
+	reasonRolloutComplete   = "RolloutComplete"
+)
+
+// rolloutRequeueInterval is the interval to check the progress of a rollout.
+const rolloutRequeueInterval = 30 * time.Second
+
+// defaultMaxSurgeAndUnavailable is the default of RollingUpdate params set
+// by the API server.
+var defaultMaxSurgeAndUnavailable = intstr.FromString("25%")
+
+// rollback restores the pod template of the previous rollout, recorded in the
+// status of the Foo, from the ReplicaSets of the Deployment. The rollback
+// annotation is removed from the Foo whether or not the rollback succeeds, so
+// the updated Foo and Deployment are returned.
+func (c *Controller) rollback(ctx context.Context, foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) (*samplev1alpha1.Foo, *appsv1.Deployment, error) {
+	ctx, span := c.tracer.Start(ctx, "rollback")
+	defer span.End()
+	template, err := c.findPreviousTemplate(ctx, foo, deployment)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if template != nil {
+		deploymentCopy := deployment.DeepCopy()
+		deploymentCopy.Spec.Template = *template
+		deployment, err = c.kubeclientset.AppsV1().Deployments(foo.Namespace).Update(ctx, deploymentCopy, metav1.UpdateOptions{})
+		if err != nil {
+			return nil, nil, err
+		}
+		msg := fmt.Sprintf(MessageRolledBack, deployment.Name, foo.Status.PreviousTemplateHash)
+		c.event(ctx, foo, corev1.EventTypeNormal, RolledBack, msg)
+		klog.FromContext(ctx).Info("Rolled back Deployment", "deployment", klog.KObj(deployment), "templateHash", foo.Status.PreviousTemplateHash)
+	}
+
+	fooCopy := foo.DeepCopy()
+	delete(fooCopy.Annotations, RollbackAnnotation)
+	foo, err = c.sampleclientset.ExampleV1alpha1().Foos(foo.Namespace).Update(ctx, fooCopy, metav1.UpdateOptions{})
+	if err != nil {
+		return nil, nil, err
+	}
+	// The rollback is recorded in the status, which is updated at the end of
+	// the sync, so the restored pod template is kept until the Foo changes.
+	if template != nil {
+		foo.Status.RolledBackGeneration = foo.Generation
+	}
+	return foo, deployment, nil
+}
+
+// isRolledBack returns true if the Deployment has been rolled back since the
+// spec of the Foo last changed.
+func isRolledBack(foo *samplev1alpha1.Foo) bool {
+	return foo.Status.RolledBackGeneration != 0 && foo.Status.RolledBackGeneration == foo.Generation
+}
+
+// findPreviousTemplate returns the pod template of the ReplicaSet of the
+// Deployment whose hash is the previous template hash in the status of the
+// Foo. nil is returned with a warning Event when it's not found.
+func (c *Controller) findPreviousTemplate(ctx context.Context, foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) (*corev1.PodTemplateSpec, error) {
+	if foo.Status.PreviousTemplateHash == "" {
+		c.event(ctx, foo, corev1.EventTypeWarning, RollbackFailed, MessageRollbackNoPrevious)
+		return nil, nil
+	}
+
+	// Rollbacks are rare, so the ReplicaSets are listed from the API server
+	// instead of caching all of them in an informer.
+	selector, err := metav1.LabelSelectorAsSelector(deployment.Spec.Selector)
+	if err != nil {
+		return nil, err
+	}
+	replicaSets, err := c.kubeclientset.AppsV1().ReplicaSets(deployment.Namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+	for i := range replicaSets.Items {
+		rs := &replicaSets.Items[i]
+		if !metav1.IsControlledBy(rs, deployment) {
+			continue
+		}
+		template := rs.Spec.Template.DeepCopy()
+		// The Deployment controller adds the label to distinguish ReplicaSets.
+		delete(template.Labels, appsv1.DefaultDeploymentUniqueLabelKey)
+		if computeTemplateHash(template) == foo.Status.PreviousTemplateHash {
+			return template, nil
+		}
+	}
+	c.eventf(ctx, foo, corev1.EventTypeWarning, RollbackFailed, MessageRollbackNotFound, foo.Status.PreviousTemplateHash)
+	return nil, nil
+}
+
+// shouldPauseRollout returns true if the rollout of the Deployment reaches
+// spec.rollout.pauseAt of the Foo. The current value is kept while the
+// Deployment controller hasn't observed the latest Deployment.
+func shouldPauseRollout(foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) bool {
+	if foo.Spec.Rollout == nil || foo.Spec.Rollout.PauseAt == nil {
+		return false
+	}
+	if deployment.Status.ObservedGeneration < deployment.Generation {
+		return deployment.Spec.Paused
+	}
+	replicas := getDeploymentReplicas(deployment)
+	updated := deployment.Status.UpdatedReplicas
+	if replicas == 0 || updated >= replicas {
+		return false
+	}
+	return updated*100 >= *foo.Spec.Rollout.PauseAt*replicas
+}
+
+// setRolloutPaused pauses or resumes the rollout of the Deployment, and
+// records in the annotation that the pause is owned by the controller. A
+// rollout paused by someone else, such as with kubectl rollout pause, is
+// neither resumed nor taken over.
+func setRolloutPaused(deployment *appsv1.Deployment, pause bool) {
+	_, owned := deployment.Annotations[rolloutPausedAnnotation]
+	switch {
+	case pause && !deployment.Spec.Paused:
+		deployment.Spec.Paused = true
+		if deployment.Annotations == nil {
+			deployment.Annotations = map[string]string{}
+		}
+		deployment.Annotations[rolloutPausedAnnotation] = "true"
+	case !pause && deployment.Spec.Paused && owned:
+		deployment.Spec.Paused = false
+		delete(deployment.Annotations, rolloutPausedAnnotation)
+	case !deployment.Spec.Paused && owned:
+		// The rollout has been resumed by someone else.
+		delete(deployment.Annotations, rolloutPausedAnnotation)
+	}
+}
+
+// newProgressingCondition returns the Progressing condition of the Foo from
+// the status of the Deployment.
+func newProgressingCondition(foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) metav1.Condition {
+	replicas := getDeploymentReplicas(deployment)
+	condition := metav1.Condition{
+		Type:               ConditionTypeProgressing,
+		ObservedGeneration: foo.Generation,
+		Message:            fmt.Sprintf("%d of %d replicas updated", deployment.Status.UpdatedReplicas, replicas),
+	}
+	switch {
+	case deployment.Spec.Paused:
+		condition.Status = metav1.ConditionUnknown
+		condition.Reason = reasonRolloutPaused
+	case deployment.Status.ObservedGeneration >= deployment.Generation &&
+		deployment.Status.UpdatedReplicas == replicas &&
+		deployment.Status.Replicas == replicas:
+		condition.Status = metav1.ConditionFalse
+		condition.Reason = reasonRolloutComplete
+	default:
+		condition.Status = metav1.ConditionTrue
+		condition.Reason = reasonRolloutInProgress
+	}
+	return condition
+}
+
+// setRolloutStatus records the progress of the rollout of the Deployment in
+// the status of the Foo. The template hash is shifted to the previous one
+// when the pod template of the Deployment changes.
+func setRolloutStatus(status *samplev1alpha1.FooStatus, foo *samplev1alpha1.Foo, deployment *appsv1.Deployment) {
+	status.UpdatedReplicas = deployment.Status.UpdatedReplicas
+	if hash := computeTemplateHash(&deployment.Spec.Template); hash != status.TemplateHash {
+		if status.TemplateHash != "" {
+			status.PreviousTemplateHash = status.TemplateHash
+		}
+		status.TemplateHash = hash
+	}
+	meta.SetStatusCondition(&status.Conditions, newProgressingCondition(foo, deployment))
+}
+
+// computeTemplateHash returns a hash of the pod template, which is used to
+// identify the pod template of a rollout.
+func computeTemplateHash(template *corev1.PodTemplateSpec) string {
+	hasher := fnv.New32a()
+	// Marshaling the pod template never fails.
+	data, _ := json.Marshal(template)
+	hasher.Write(data)
+	return rand.SafeEncodeString(fmt.Sprint(hasher.Sum32()))
+}
+
+// defaultDeploymentStrategy returns the strategy with the defaults set by the
+// API server, so it can be compared with the strategy of a Deployment.
+func defaultDeploymentStrategy(strategy appsv1.DeploymentStrategy) appsv1.DeploymentStrategy {
+	strategy = *strategy.DeepCopy()
+	if strategy.Type == "" {
+		strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
+	}
+	if strategy.Type != appsv1.RollingUpdateDeploymentStrategyType {
+		return strategy
+	}
+	if strategy.RollingUpdate == nil {
+		strategy.RollingUpdate = &appsv1.RollingUpdateDeployment{}
+	}
+	if strategy.RollingUpdate.MaxSurge == nil {
+		maxSurge := defaultMaxSurgeAndUnavailable
+		strategy.RollingUpdate.MaxSurge = &maxSurge
+	}
+	if strategy.RollingUpdate.MaxUnavailable == nil {
+		maxUnavailable := defaultMaxSurgeAndUnavailable
+		strategy.RollingUpdate.MaxUnavailable = &maxUnavailable
+	}
+	return strategy
+}
+
+// getDeploymentReplicas returns the desired number of replicas of the
+// Deployment, which is 1 when it's not specified.
+func getDeploymentReplicas(deployment *appsv1.Deployment) int32 {
+	if deployment.Spec.Replicas == nil {
+		return 1
+	}
+	return *deployment.Spec.Replicas
+}
@@ -0,0 +1,332 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sort"
+	"sync"
+	"time"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"k8s.io/utils/clock"
+)
+
+const (
+	// shardLabel is the label of the Leases of the shard members.
+	shardLabel = "example.com/sample-controller-shard"
+	// shardLeasePrefix is the prefix of the names of the Leases of the shard
+	// members.
+	shardLeasePrefix = "sample-controller-shard-"
+	// virtualNodesPerMember is the number of points of each member on the
+	// hash ring, which evens out the number of keys per member.
+	virtualNodesPerMember = 100
+)
+
+// Sharder tells whether the key of a Foo belongs to the local shard.
+type Sharder interface {
+	Owns(key types.NamespacedName) bool
+}
+
+// ShardOptions configures the membership of the shards.
+type ShardOptions struct {
+	// Name is the identity of this replica, which must be unique among the
+	// replicas.
+	Name string
+	// Namespace is the namespace of the Leases of the members.
+	Namespace string
+	// LeaseDuration is the duration after which a member is considered gone
+	// if it doesn't renew its Lease.
+	LeaseDuration time.Duration
+	// RenewInterval is the interval to renew the Lease and observe the
+	// members. It must be shorter than LeaseDuration.
+	RenewInterval time.Duration
+	// HandoffDelay is the delay before keys moved from another member are
+	// processed, which must cover the difference in the time the members
+	// observe a membership change plus the longest reconcile. It must not
+	// be shorter than RenewInterval.
+	HandoffDelay time.Duration
+}
+
+// DefaultShardOptions returns the default options with the name.
+func DefaultShardOptions(name string) ShardOptions {
+	return ShardOptions{
+		Name:          name,
+		Namespace:     configv1alpha1.DefaultShardLeaseNamespace,
+		LeaseDuration: configv1alpha1.DefaultShardLeaseDuration,
+		RenewInterval: configv1alpha1.DefaultShardRenewInterval,
+		HandoffDelay:  configv1alpha1.DefaultShardHandoffDelay,
+	}
+}
+
+// hashRing assigns keys to members by consistent hashing, so only the keys
+// of the joining or leaving member move on a membership change.
+type hashRing struct {
+	points  []uint64
+	members map[uint64]string
+}
+
+func newHashRing(members []string) *hashRing {
+	ring := &hashRing{members: map[uint64]string{}}
+	for _, member := range members {
+		for i := 0; i < virtualNodesPerMember; i++ {
+			point := hashString(fmt.Sprintf("%s#%d", member, i))
+			ring.points = append(ring.points, point)
+			ring.members[point] = member
+		}
+	}
+	sort.Slice(ring.points, func(i, j int) bool { return ring.points[i] < ring.points[j] })
+	return ring
+}
+
+// owner returns the member owning the key, which is the first point
+// clockwise from the hash of the key. An empty string is returned if there's
+// no member.
+func (r *hashRing) owner(key types.NamespacedName) string {
+	if r == nil || len(r.points) == 0 {
+		return ""
+	}
+	hash := hashString(key.String())
+	i := sort.Search(len(r.points), func(i int) bool { return r.points[i] >= hash })
+	if i == len(r.points) {
+		i = 0
+	}
+	return r.members[r.points[i]]
+}
+
+// hashString returns the FNV-1a hash of the string with its bits mixed, as
+// the hashes of strings differing only in the last bytes, such as the virtual
+// nodes of a member, are otherwise close on the ring.
+func hashString(s string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(s))
+	h := hasher.Sum64()
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+// ShardManager keeps the Lease of this replica and the hash ring of the live
+// members. When the members change, the keys moved away from this replica are
+// released immediately, while the keys moved to this replica are acquired
+// after HandoffDelay so that the previous owner has stopped processing them.
+// No key is owned while the Lease of this replica is expired, as the other
+// members take its keys over, and they are acquired again after the handoff
+// once the Lease is renewed.
+type ShardManager struct {
+	client kubernetes.Interface
+	opts   ShardOptions
+	clock  clock.Clock
+	// onRebalance is called when the keys moved to this replica are
+	// acquired, so they can be enqueued.
+	onRebalance func()
+
+	mu sync.RWMutex
+	// renewedAt is the time of the last renewal of the Lease, which the
+	// other members consider expired after LeaseDuration.
+	renewedAt time.Time
+	// members is the sorted names of the live members observed last.
+	members []string
+	// active is the ring whose keys are fully acquired.
+	active *hashRing
+	// pending is the ring of the latest members, which becomes active at
+	// activateAt.
+	pending    *hashRing
+	activateAt time.Time
+}
+
+var _ Sharder = &ShardManager{}
+
+// NewShardManager returns a ShardManager for the options, which joins the
+// shards on Run.
+func NewShardManager(client kubernetes.Interface, opts ShardOptions, clock clock.Clock) *ShardManager {
+	return &ShardManager{
+		client: client,
+		opts:   opts,
+		clock:  clock,
+	}
+}
+
+// OnRebalance sets the function called when the keys moved to this replica
+// are acquired, such as Controller.EnqueueShard. It must be set before Run.
+func (m *ShardManager) OnRebalance(fn func()) {
+	m.onRebalance = fn
+}
+
+// Owns returns true if the key belongs to this replica on the latest ring,
+// and it has been handed off from the previous owner.
+func (m *ShardManager) Owns(key types.NamespacedName) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	now := m.clock.Now()
+	if !m.holdsLease(now) || m.pending.owner(key) != m.opts.Name {
+		return false
+	}
+	return m.active.owner(key) == m.opts.Name || !now.Before(m.activateAt)
+}
+
+// holdsLease returns true if the Lease of this replica is not expired at the
+// time. It must be called with the lock held.
+func (m *ShardManager) holdsLease(now time.Time) bool {
+	return now.Before(m.renewedAt.Add(m.opts.LeaseDuration))
+}
+
+// Run renews the Lease of this replica and observes the members until the
+// context is cancelled. The Lease is deleted on stop so the other members
+// take over the keys without waiting for it to expire.
+func (m *ShardManager) Run(ctx context.Context) {
+	logger := klog.LoggerWithValues(klog.FromContext(ctx), "shard", m.opts.Name)
+	ctx = klog.NewContext(ctx, logger)
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := m.renew(ctx); err != nil {
+			logger.Error(err, "Failed to renew shard lease")
+		}
+		if err := m.observe(ctx); err != nil {
+			logger.Error(err, "Failed to observe shard members")
+		}
+		m.activate(ctx)
+	}, m.opts.RenewInterval)
+
+	// The context is already cancelled.
+	err := m.client.CoordinationV1().Leases(m.opts.Namespace).Delete(context.Background(), shardLeasePrefix+m.opts.Name, metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		logger.Error(err, "Failed to delete shard lease")
+	}
+}
+
+// renew creates or updates the Lease of this replica, and records the time of
+// the renewal.
+func (m *ShardManager) renew(ctx context.Context) error {
+	renewedAt := m.clock.Now()
+	if err := m.updateLease(ctx, metav1.NewMicroTime(renewedAt)); err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.holdsLease(renewedAt) {
+		// The other members may have taken over the keys of this replica
+		// since the Lease expired, so the keys are acquired again after
+		// the handoff as when joining.
+		m.members, m.active, m.pending = nil, nil, nil
+	}
+	m.renewedAt = renewedAt
+	return nil
+}
+
+// updateLease creates or updates the Lease of this replica renewed at the
+// time.
+func (m *ShardManager) updateLease(ctx context.Context, now metav1.MicroTime) error {
+	leases := m.client.CoordinationV1().Leases(m.opts.Namespace)
+	duration := int32(m.opts.LeaseDuration.Seconds())
+	lease, err := leases.Get(ctx, shardLeasePrefix+m.opts.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = leases.Create(ctx, &coordinationv1.Lease{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      shardLeasePrefix + m.opts.Name,
+				Namespace: m.opts.Namespace,
+				Labels:    map[string]string{shardLabel: "true"},
+			},
+			Spec: coordinationv1.LeaseSpec{
+				HolderIdentity:       &m.opts.Name,
+				LeaseDurationSeconds: &duration,
+				AcquireTime:          &now,
+				RenewTime:            &now,
+			},
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+	lease.Spec.HolderIdentity = &m.opts.Name
+	lease.Spec.LeaseDurationSeconds = &duration
+	lease.Spec.RenewTime = &now
+	_, err = leases.Update(ctx, lease, metav1.UpdateOptions{})
+	return err
+}
+
+// observe lists the Leases of the members and replaces the pending ring if
+// the live members change. The first ring is activated without waiting for
+// the handoff when this replica is the only member, as the keys can only have
+// been owned by the replicas whose Leases expired.
+func (m *ShardManager) observe(ctx context.Context) error {
+	leases, err := m.client.CoordinationV1().Leases(m.opts.Namespace).List(ctx, metav1.ListOptions{LabelSelector: shardLabel})
+	if err != nil {
+		return err
+	}
+	now := m.clock.Now()
+	var members []string
+	// lastExpiry is the latest expiry of the Leases of the other replicas
+	// which are gone.
+	var lastExpiry time.Time
+	for _, lease := range leases.Items {
+		if lease.Spec.HolderIdentity == nil || lease.Spec.RenewTime == nil || lease.Spec.LeaseDurationSeconds == nil {
+			continue
+		}
+		expiry := lease.Spec.RenewTime.Add(time.Duration(*lease.Spec.LeaseDurationSeconds) * time.Second)
+		switch {
+		case now.Before(expiry):
+			members = append(members, *lease.Spec.HolderIdentity)
+		case *lease.Spec.HolderIdentity != m.opts.Name && expiry.After(lastExpiry):
+			lastExpiry = expiry
+		}
+	}
+	sort.Strings(members)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if equalMembers(members, m.members) {
+		return nil
+	}
+	klog.FromContext(ctx).Info("Shard members changed", "from", m.members, "to", members)
+	activateAt := now.Add(m.opts.HandoffDelay)
+	if m.pending == nil && equalMembers(members, []string{m.opts.Name}) {
+		// The replicas whose Leases expired have stopped owning keys at
+		// the expiry, and finish their reconciles within the handoff.
+		activateAt = lastExpiry.Add(m.opts.HandoffDelay)
+	}
+	m.members = members
+	m.pending = newHashRing(members)
+	m.activateAt = activateAt
+	return nil
+}
+
+// activate makes the pending ring active once the handoff delay passes, and
+// calls onRebalance to enqueue the acquired keys.
+func (m *ShardManager) activate(ctx context.Context) {
+	m.mu.Lock()
+	if m.pending == m.active || m.clock.Now().Before(m.activateAt) {
+		m.mu.Unlock()
+		return
+	}
+	m.active = m.pending
+	members := m.members
+	m.mu.Unlock()
+	klog.FromContext(ctx).Info("Shard ring is active", "members", members)
+	if m.onRebalance != nil {
+		m.onRebalance()
+	}
+}
+
+func equalMembers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,204 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	coordinationv1 "k8s.io/api/coordination/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	testingclock "k8s.io/utils/clock/testing"
+	"k8s.io/utils/pointer"
+)
+
+const shardTestNamespace = "kube-system"
+
+func shardTestKeys() []types.NamespacedName {
+	var keys []types.NamespacedName
+	for i := 0; i < 1000; i++ {
+		keys = append(keys, types.NamespacedName{Namespace: fmt.Sprintf("ns-%d", i%10), Name: fmt.Sprintf("foo-%d", i)})
+	}
+	return keys
+}
+
+// TestHashRing checks the keys are spread over the members, and only the
+// keys of a leaving or joining member move.
+func TestHashRing(t *testing.T) {
+	keys := shardTestKeys()
+	if owner := newHashRing(nil).owner(keys[0]); owner != "" {
+		t.Errorf("Expected no owner without members, got %q", owner)
+	}
+
+	ring := newHashRing([]string{"a", "b", "c"})
+	counts := map[string]int{}
+	for _, key := range keys {
+		counts[ring.owner(key)]++
+	}
+	for _, member := range []string{"a", "b", "c"} {
+		if counts[member] < len(keys)/5 {
+			t.Errorf("Expected %s to own at least %d keys, got %d", member, len(keys)/5, counts[member])
+		}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		members []string
+		// moved is the member the moved keys belong to, on either ring.
+		moved string
+	}{
+		{name: "join", members: []string{"a", "b", "c", "d"}, moved: "d"},
+		{name: "leave", members: []string{"a", "c"}, moved: "b"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			next := newHashRing(tc.members)
+			for _, key := range keys {
+				before, after := ring.owner(key), next.owner(key)
+				if before != after && before != tc.moved && after != tc.moved {
+					t.Errorf("Expected %v to stay on %s, moved to %s", key, before, after)
+				}
+			}
+		})
+	}
+}
+
+// newTestShardManager returns a ShardManager on the fake clock, which ticks
+// as Run does.
+func newTestShardManager(client *k8sfake.Clientset, name string, clock *testingclock.FakeClock) *ShardManager {
+	opts := DefaultShardOptions(name)
+	opts.Namespace = shardTestNamespace
+	return NewShardManager(client, opts, clock)
+}
+
+func tickShard(m *ShardManager) {
+	ctx := context.Background()
+	_ = m.renew(ctx)
+	_ = m.observe(ctx)
+	m.activate(ctx)
+}
+
+func ownedKeys(m *ShardManager, keys []types.NamespacedName) int {
+	var n int
+	for _, key := range keys {
+		if m.Owns(key) {
+			n++
+		}
+	}
+	return n
+}
+
+// TestShardManagerStartup checks a replica starting as the only member owns
+// all the keys immediately, or after the handoff from the replicas whose
+// Leases expired lately.
+func TestShardManagerStartup(t *testing.T) {
+	keys := shardTestKeys()
+	now := time.Now()
+	for _, tc := range []struct {
+		name string
+		// expiredAgo is how long ago the Lease of another replica expired.
+		expiredAgo time.Duration
+		// wait is the time after which all the keys are owned.
+		wait time.Duration
+	}{
+		{name: "no other lease"},
+		{name: "expired lately", expiredAgo: 5 * time.Second, wait: 10 * time.Second},
+		{name: "expired long ago", expiredAgo: time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			clock := testingclock.NewFakeClock(now)
+			client := k8sfake.NewSimpleClientset()
+			if tc.expiredAgo > 0 {
+				renewTime := metav1.NewMicroTime(now.Add(-tc.expiredAgo - 15*time.Second))
+				_, err := client.CoordinationV1().Leases(shardTestNamespace).Create(context.Background(), &coordinationv1.Lease{
+					ObjectMeta: metav1.ObjectMeta{Name: shardLeasePrefix + "b", Namespace: shardTestNamespace, Labels: map[string]string{shardLabel: "true"}},
+					Spec: coordinationv1.LeaseSpec{
+						HolderIdentity:       pointer.String("b"),
+						LeaseDurationSeconds: pointer.Int32(15),
+						RenewTime:            &renewTime,
+					},
+				}, metav1.CreateOptions{})
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			m := newTestShardManager(client, "a", clock)
+			var rebalanced int
+			m.OnRebalance(func() { rebalanced++ })
+			if n := ownedKeys(m, keys); n != 0 {
+				t.Errorf("Expected no key owned before the first renewal, got %d", n)
+			}
+
+			tickShard(m)
+			if tc.wait > 0 {
+				if n := ownedKeys(m, keys); n != 0 {
+					t.Errorf("Expected no key owned before the handoff, got %d", n)
+				}
+				clock.Step(tc.wait)
+				tickShard(m)
+			}
+			if n := ownedKeys(m, keys); n != len(keys) {
+				t.Errorf("Expected all the %d keys owned, got %d", len(keys), n)
+			}
+			if rebalanced != 1 {
+				t.Errorf("Expected 1 rebalance, got %d", rebalanced)
+			}
+		})
+	}
+}
+
+// TestShardManagerHandoff runs two replicas on the fake clock while the
+// Lease of one can't be renewed for a while, and checks no key is owned by
+// both, and each key is owned by one once they settle.
+func TestShardManagerHandoff(t *testing.T) {
+	keys := shardTestKeys()
+	clock := testingclock.NewFakeClock(time.Now())
+	client := k8sfake.NewSimpleClientset()
+	var failing bool
+	client.PrependReactor("get", "leases", func(action core.Action) (bool, runtime.Object, error) {
+		if failing && action.(core.GetAction).GetName() == shardLeasePrefix+"a" {
+			return true, nil, fmt.Errorf("connection refused")
+		}
+		return false, nil, nil
+	})
+	a := newTestShardManager(client, "a", clock)
+	b := newTestShardManager(client, "b", clock)
+	ring := newHashRing([]string{"a", "b"})
+
+	// b joins 2s after a, and the Lease of a can't be renewed from 60s to
+	// 90s, so b takes over all the keys and hands the keys of a back.
+	for second := 0; second <= 180; second++ {
+		failing = second >= 60 && second < 90
+		if second%5 == 0 {
+			tickShard(a)
+		}
+		if second >= 2 && second%5 == 2 {
+			tickShard(b)
+		}
+		for _, key := range keys {
+			if a.Owns(key) && b.Owns(key) {
+				t.Fatalf("Expected %v owned by one replica at %ds", key, second)
+			}
+		}
+		switch second {
+		case 50, 180:
+			for _, key := range keys {
+				if owner := ring.owner(key); owner == "a" && !a.Owns(key) || owner == "b" && !b.Owns(key) {
+					t.Errorf("Expected %v owned by %s at %ds", key, owner, second)
+				}
+			}
+		case 85:
+			if n := ownedKeys(a, keys); n != 0 {
+				t.Errorf("Expected no key owned by a with the expired lease, got %d", n)
+			}
+		case 88:
+			if n := ownedKeys(b, keys); n != len(keys) {
+				t.Errorf("Expected all the keys owned by b while a rejoins, got %d", n)
+			}
+		}
+		clock.Step(time.Second)
+	}
+}
@@ -0,0 +1,917 @@
+package controller
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	samplev1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/example.com/v1alpha1"
+	"github.com/nakamasato/sample-controller/pkg/faultinjection"
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/fake"
+	informers "github.com/nakamasato/sample-controller/pkg/generated/informers/externalversions"
+
+	appsv1 "k8s.io/api/apps/v1"
+	autoscalingv2 "k8s.io/api/autoscaling/v2"
+	policyv1 "k8s.io/api/policy/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	kubeinformers "k8s.io/client-go/informers"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	core "k8s.io/client-go/testing"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2/ktesting"
+	testingclock "k8s.io/utils/clock/testing"
+	"sigs.k8s.io/yaml"
+)
+
+var (
+	simulationSeeds = flag.Int("simulation.seeds", 20, "number of seeds to run each simulation timeline with")
+	simulationSeed  = flag.Int64("simulation.seed", -1, "seed to replay the simulation timelines with (all the seeds up to -simulation.seeds if negative)")
+)
+
+const (
+	// simulationNamespace is the namespace of the simulated objects.
+	simulationNamespace = metav1.NamespaceDefault
+	// maxSettleSteps is the number of steps after which a settle step fails,
+	// which catches the Foos that are reconciled forever.
+	maxSettleSteps = 1000
+)
+
+var (
+	foosResource        = samplev1alpha1.SchemeGroupVersion.WithResource("foos")
+	deploymentsResource = appsv1.SchemeGroupVersion.WithResource("deployments")
+	pdbsResource        = policyv1.SchemeGroupVersion.WithResource("poddisruptionbudgets")
+	hpasResource        = autoscalingv2.SchemeGroupVersion.WithResource("horizontalpodautoscalers")
+)
+
+// timeline is a script of cluster events and controller steps, which is read
+// from testdata/simulation.
+type timeline struct {
+	Steps []timelineStep `json:"steps"`
+}
+
+// timelineStep is a step of a timeline, of which exactly one field is set.
+type timelineStep struct {
+	// The changes of the objects, which are made through the API and
+	// delivered to the informers by the deliver and settle steps.
+	CreateFoo         *fooStep        `json:"createFoo,omitempty"`
+	UpdateFoo         *fooStep        `json:"updateFoo,omitempty"`
+	DeleteFoo         *objectStep     `json:"deleteFoo,omitempty"`
+	CreateDeployment  *deploymentStep `json:"createDeployment,omitempty"`
+	ScaleDeployment   *deploymentStep `json:"scaleDeployment,omitempty"`
+	RolloutDeployment *objectStep     `json:"rolloutDeployment,omitempty"`
+	DeleteDeployment  *objectStep     `json:"deleteDeployment,omitempty"`
+	// CollectGarbage deletes the objects whose controller doesn't exist,
+	// as the garbage collector does.
+	CollectGarbage *struct{} `json:"collectGarbage,omitempty"`
+	// FailNext makes the next matching requests of the controller fail.
+	FailNext *failStep `json:"failNext,omitempty"`
+
+	// Deliver delivers the number of pending informer events, picked by the
+	// seed from the informers with pending events.
+	Deliver *int `json:"deliver,omitempty"`
+	// Resync delivers a resync of all the cached Foos.
+	Resync *struct{} `json:"resync,omitempty"`
+	// Process processes the number of items of the workqueue, or fewer if
+	// the workqueue is empty.
+	Process *int `json:"process,omitempty"`
+	// Advance steps the clock, which releases the delayed items due.
+	Advance *metav1.Duration `json:"advance,omitempty"`
+	// Settle delivers the pending events and processes the workqueue in an
+	// order picked by the seed until both are empty.
+	Settle *struct{} `json:"settle,omitempty"`
+	// Expect checks the state of the cluster.
+	Expect *expectStep `json:"expect,omitempty"`
+}
+
+type objectStep struct {
+	Name string `json:"name"`
+}
+
+type fooStep struct {
+	Name           string            `json:"name"`
+	DeploymentName string            `json:"deploymentName,omitempty"`
+	Replicas       *int32            `json:"replicas,omitempty"`
+	Labels         map[string]string `json:"labels,omitempty"`
+}
+
+type deploymentStep struct {
+	Name     string `json:"name"`
+	Replicas int32  `json:"replicas"`
+	// Managed sets the managed label on the Deployment.
+	Managed bool `json:"managed,omitempty"`
+}
+
+// failStep is a faultinjection.Fault.
+type failStep struct {
+	Verb        string `json:"verb"`
+	Resource    string `json:"resource"`
+	Subresource string `json:"subresource,omitempty"`
+	// Count is the number of requests to fail. Defaults to 1.
+	Count       int     `json:"count,omitempty"`
+	After       int     `json:"after,omitempty"`
+	Probability float64 `json:"probability,omitempty"`
+	// Error is one of "conflict", "tooManyRequests", "timeout", "none" for
+	// a slow request, and "internal" (the default).
+	Error  string          `json:"error,omitempty"`
+	Delay  metav1.Duration `json:"delay,omitempty"`
+	Commit bool            `json:"commit,omitempty"`
+}
+
+// faultErrors are the errors of the failSteps.
+var faultErrors = map[string]faultinjection.ErrorFunc{
+	"":                faultinjection.InternalError,
+	"internal":        faultinjection.InternalError,
+	"conflict":        faultinjection.Conflict,
+	"tooManyRequests": faultinjection.TooManyRequests,
+	"timeout":         faultinjection.Timeout,
+	"none":            nil,
+}
+
+type expectStep struct {
+	Foo               string `json:"foo,omitempty"`
+	Deployment        string `json:"deployment,omitempty"`
+	Exists            *bool  `json:"exists,omitempty"`
+	Replicas          *int32 `json:"replicas,omitempty"`
+	ControlledBy      string `json:"controlledBy,omitempty"`
+	AvailableReplicas *int32 `json:"availableReplicas,omitempty"`
+	Synced            bool   `json:"synced,omitempty"`
+	QueueLen          *int   `json:"queueLen,omitempty"`
+	Pending           *int   `json:"pending,omitempty"`
+	Description       string `json:"description,omitempty"`
+}
+
+// invariant is a property of the cluster which must hold after every step.
+type invariant struct {
+	name  string
+	check func(s *simulation) error
+}
+
+var invariants = []invariant{
+	{name: "at most one controlling Deployment per Foo", check: checkSingleDeployment},
+	{name: "at most one controller per Deployment", check: checkSingleController},
+	{name: "observed generation never ahead of the Foo", check: checkObservedGeneration},
+	{name: "status never ahead of the Deployment", check: checkStatusNotAhead},
+}
+
+// simulation drives a Controller deterministically. The informers are not
+// started: the events of the fake clientsets are queued and delivered to the
+// informer caches and the event handlers by the steps, and the workqueue is
+// processed one item at a time. The delays of the workqueue are measured with
+// a fake clock, which only moves on the advance steps. The order of the
+// events and the items left open by the timeline is picked by the seed.
+type simulation struct {
+	t    *testing.T
+	ctx  context.Context
+	rng  *rand.Rand
+	seed int64
+
+	clock      *testingclock.FakeClock
+	kubeclient *k8sfake.Clientset
+	client     *fake.Clientset
+	controller *Controller
+	delaying   *simDelayingQueue
+
+	// streams are the informers in a fixed order.
+	streams []*simStream
+	// resourceVersion is the last resource version given to an object.
+	resourceVersion int
+	// faults injects the failures into the requests of the controller,
+	// which are made while processing is set.
+	faults     *faultinjection.Injector
+	processing bool
+	// availableHistory is the available replicas each Deployment has ever
+	// had, keyed by the UID.
+	availableHistory map[types.UID]map[int32]bool
+
+	// trace is the log of the steps for the failure message.
+	trace []string
+	step  int
+}
+
+// simStream is an informer fed by a watch of a fake clientset.
+type simStream struct {
+	resource schema.GroupVersionResource
+	watch    watch.Interface
+	indexer  cache.Indexer
+	handler  cache.ResourceEventHandler
+	pending  []watch.Event
+}
+
+func TestSimulation(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "simulation", "*.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	seeds := make([]int64, 0, *simulationSeeds)
+	if *simulationSeed >= 0 {
+		seeds = append(seeds, *simulationSeed)
+	} else {
+		for seed := 0; seed < *simulationSeeds; seed++ {
+			seeds = append(seeds, int64(seed))
+		}
+	}
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var tl timeline
+		if err := yaml.UnmarshalStrict(data, &tl); err != nil {
+			t.Fatalf("Failed to parse %s: %v", path, err)
+		}
+		name := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		t.Run(name, func(t *testing.T) {
+			for _, seed := range seeds {
+				seed := seed
+				t.Run(fmt.Sprintf("seed=%d", seed), func(t *testing.T) {
+					newSimulation(t, seed).run(tl)
+				})
+			}
+		})
+	}
+}
+
+func newSimulation(t *testing.T, seed int64) *simulation {
+	_, ctx := ktesting.NewTestContext(t)
+	s := &simulation{
+		t:                t,
+		ctx:              ctx,
+		rng:              rand.New(rand.NewSource(seed)),
+		seed:             seed,
+		clock:            testingclock.NewFakeClock(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)),
+		kubeclient:       k8sfake.NewSimpleClientset(),
+		client:           fake.NewSimpleClientset(),
+		availableHistory: map[types.UID]map[int32]bool{},
+	}
+	s.faults = faultinjection.New(seed, s.clock)
+	for _, client := range []faultinjection.Client{s.kubeclient, s.client} {
+		reactor := s.apiReactor(client.Tracker())
+		client.PrependReactor("*", "*", reactor)
+		objectReaction := core.ObjectReaction(client.Tracker())
+		faults := s.faults.Reactor(func(action core.Action) (bool, runtime.Object, error) {
+			if handled, obj, err := reactor(action); handled {
+				return true, obj, err
+			}
+			return objectReaction(action)
+		})
+		client.PrependReactor("*", "*", func(action core.Action) (bool, runtime.Object, error) {
+			if !s.processing {
+				return false, nil, nil
+			}
+			return faults(action)
+		})
+	}
+
+	i := informers.NewSharedInformerFactory(s.client, 0)
+	k8sI := kubeinformers.NewSharedInformerFactory(s.kubeclient, 0)
+	informerSet := Informers{
+		Deployments:              k8sI.Apps().V1().Deployments(),
+		PodDisruptionBudgets:     k8sI.Policy().V1().PodDisruptionBudgets(),
+		HorizontalPodAutoscalers: k8sI.Autoscaling().V2().HorizontalPodAutoscalers(),
+		Pods:                     k8sI.Core().V1().Pods(),
+		Foos:                     i.Example().V1alpha1().Foos(),
+	}
+	rateLimiter := NewRateLimiter(DefaultRateLimiterOptions(), s.clock)
+	s.controller = NewController(ctx, s.kubeclient, s.client, []Informers{informerSet}, ControllerOptions{
+		RateLimiter: rateLimiter,
+		Recorder:    &record.FakeRecorder{},
+		Clock:       s.clock,
+	})
+	s.delaying = &simDelayingQueue{
+		Interface: workqueue.NewWithConfig(workqueue.QueueConfig{Clock: s.clock}),
+		clock:     s.clock,
+	}
+	s.controller.workqueue.ShutDown()
+	s.controller.workqueue = newFooQueueFrom(workqueue.NewRateLimitingQueueWithConfig(rateLimiter, workqueue.RateLimitingQueueConfig{
+		Clock:         s.clock,
+		DelayingQueue: s.delaying,
+	}))
+	t.Cleanup(s.controller.workqueue.ShutDown)
+
+	s.streams = []*simStream{
+		s.newStream(s.client.Tracker(), foosResource, informerSet.Foos.Informer(), s.controller.fooEventHandler()),
+		s.newStream(s.kubeclient.Tracker(), deploymentsResource, informerSet.Deployments.Informer(), s.controller.ownedObjectEventHandler()),
+		s.newStream(s.kubeclient.Tracker(), pdbsResource, informerSet.PodDisruptionBudgets.Informer(), s.controller.ownedObjectEventHandler()),
+		s.newStream(s.kubeclient.Tracker(), hpasResource, informerSet.HorizontalPodAutoscalers.Informer(), s.controller.ownedObjectEventHandler()),
+	}
+	return s
+}
+
+func (s *simulation) newStream(tracker core.ObjectTracker, resource schema.GroupVersionResource, informer cache.SharedIndexInformer, handler cache.ResourceEventHandler) *simStream {
+	w, err := tracker.Watch(resource, metav1.NamespaceAll)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	s.t.Cleanup(w.Stop)
+	return &simStream{resource: resource, watch: w, indexer: informer.GetIndexer(), handler: handler}
+}
+
+// run runs the steps of the timeline and checks the invariants after every
+// step.
+func (s *simulation) run(tl timeline) {
+	for _, step := range tl.Steps {
+		s.runStep(step)
+	}
+}
+
+// do records the step, runs it, collects the informer events it causes and
+// checks the invariants.
+func (s *simulation) do(desc string, fn func() error) {
+	s.step++
+	s.trace = append(s.trace, fmt.Sprintf("%4d %s %s", s.step, s.clock.Now().Format(time.TimeOnly), desc))
+	if err := fn(); err != nil {
+		s.fail("step failed: %v", err)
+	}
+	// The slow requests step the clock.
+	s.delaying.release()
+	s.collectEvents()
+	s.recordHistory()
+	for _, inv := range invariants {
+		if err := inv.check(s); err != nil {
+			s.fail("invariant %q is violated: %v", inv.name, err)
+		}
+	}
+}
+
+// fail fails the test with the trace and how to replay the seed.
+func (s *simulation) fail(format string, args ...interface{}) {
+	s.t.Helper()
+	s.t.Fatalf("%s\nafter the steps:\n%s\nreplay with: go test -run '%s' -v -simulation.seed=%d ./pkg/controller",
+		fmt.Sprintf(format, args...), strings.Join(s.trace, "\n"), strings.SplitN(s.t.Name(), "/seed=", 2)[0], s.seed)
+}
+
+func (s *simulation) runStep(step timelineStep) {
+	switch {
+	case step.CreateFoo != nil:
+		s.do("createFoo "+step.CreateFoo.Name, func() error { return s.createFoo(step.CreateFoo) })
+	case step.UpdateFoo != nil:
+		s.do("updateFoo "+step.UpdateFoo.Name, func() error { return s.updateFoo(step.UpdateFoo) })
+	case step.DeleteFoo != nil:
+		s.do("deleteFoo "+step.DeleteFoo.Name, func() error {
+			return s.client.ExampleV1alpha1().Foos(simulationNamespace).Delete(s.ctx, step.DeleteFoo.Name, metav1.DeleteOptions{})
+		})
+	case step.CreateDeployment != nil:
+		s.do("createDeployment "+step.CreateDeployment.Name, func() error { return s.createDeployment(step.CreateDeployment) })
+	case step.ScaleDeployment != nil:
+		s.do("scaleDeployment "+step.ScaleDeployment.Name, func() error { return s.scaleDeployment(step.ScaleDeployment) })
+	case step.RolloutDeployment != nil:
+		s.do("rolloutDeployment "+step.RolloutDeployment.Name, func() error { return s.rolloutDeployment(step.RolloutDeployment.Name) })
+	case step.DeleteDeployment != nil:
+		s.do("deleteDeployment "+step.DeleteDeployment.Name, func() error {
+			return s.kubeclient.AppsV1().Deployments(simulationNamespace).Delete(s.ctx, step.DeleteDeployment.Name, metav1.DeleteOptions{})
+		})
+	case step.CollectGarbage != nil:
+		s.do("collectGarbage", s.collectGarbage)
+	case step.FailNext != nil:
+		failure := step.FailNext
+		fault := faultinjection.Fault{
+			Verb:        failure.Verb,
+			Resource:    failure.Resource,
+			Subresource: failure.Subresource,
+			Delay:       failure.Delay.Duration,
+			Commit:      failure.Commit,
+			After:       failure.After,
+			Count:       failure.Count,
+			Probability: failure.Probability,
+		}
+		if fault.Count == 0 {
+			fault.Count = 1
+		}
+		s.do(fmt.Sprintf("failNext %s x%d error=%s", &fault, fault.Count, failure.Error), func() error {
+			errorFunc, ok := faultErrors[failure.Error]
+			if !ok {
+				return fmt.Errorf("unknown error %q", failure.Error)
+			}
+			fault.Error = errorFunc
+			s.faults.Add(fault)
+			return nil
+		})
+	case step.Deliver != nil:
+		for n := 0; n < *step.Deliver && s.pendingEvents() > 0; n++ {
+			s.deliverOne()
+		}
+	case step.Resync != nil:
+		s.do("resync", s.resync)
+	case step.Process != nil:
+		for n := 0; n < *step.Process && s.controller.workqueue.Len() > 0; n++ {
+			s.processOne()
+		}
+	case step.Advance != nil:
+		s.do("advance "+step.Advance.Duration.String(), func() error {
+			s.clock.Step(step.Advance.Duration)
+			return nil
+		})
+	case step.Settle != nil:
+		s.settle()
+	case step.Expect != nil:
+		s.do("expect "+step.Expect.Description, func() error { return s.expect(step.Expect) })
+	default:
+		s.t.Fatalf("Empty step in the timeline")
+	}
+}
+
+// settle delivers the pending events and processes the workqueue in an order
+// picked by the seed until both are empty.
+func (s *simulation) settle() {
+	for n := 0; ; n++ {
+		events, items := s.pendingEvents(), s.controller.workqueue.Len()
+		if events == 0 && items == 0 {
+			return
+		}
+		if n == maxSettleSteps {
+			s.fail("not settled after %d steps", maxSettleSteps)
+		}
+		if items == 0 || (events > 0 && s.rng.Intn(2) == 0) {
+			s.deliverOne()
+		} else {
+			s.processOne()
+		}
+	}
+}
+
+// deliverOne delivers the next event of an informer picked by the seed.
+func (s *simulation) deliverOne() {
+	var streams []*simStream
+	for _, stream := range s.streams {
+		if len(stream.pending) > 0 {
+			streams = append(streams, stream)
+		}
+	}
+	stream := streams[s.rng.Intn(len(streams))]
+	event := stream.pending[0]
+	stream.pending = stream.pending[1:]
+	object := event.Object.(metav1.Object)
+	s.do(fmt.Sprintf("deliver %s %s %s rv=%s", event.Type, stream.resource.Resource, object.GetName(), object.GetResourceVersion()), func() error {
+		return stream.deliver(event)
+	})
+}
+
+// deliver updates the cache with the event and calls the event handler, in
+// the same way as a shared informer.
+func (st *simStream) deliver(event watch.Event) error {
+	obj, err := transformObject(event.Object.DeepCopyObject())
+	if err != nil {
+		return err
+	}
+	old, exists, err := st.indexer.Get(obj)
+	if err != nil {
+		return err
+	}
+	switch event.Type {
+	case watch.Added, watch.Modified:
+		if err := st.indexer.Update(obj); err != nil {
+			return err
+		}
+		if exists {
+			st.handler.OnUpdate(old, obj)
+		} else {
+			st.handler.OnAdd(obj, false)
+		}
+	case watch.Deleted:
+		if err := st.indexer.Delete(obj); err != nil {
+			return err
+		}
+		st.handler.OnDelete(obj)
+	}
+	return nil
+}
+
+// processOne processes the next item of the workqueue.
+func (s *simulation) processOne() {
+	key, _ := s.controller.workqueue.Get()
+	s.do(fmt.Sprintf("process %s %v", key.Name, s.controller.workqueue.PeekTriggers(key)), func() error {
+		s.processing = true
+		defer func() { s.processing = false }()
+		s.controller.processWorkItem(s.ctx, key)
+		return nil
+	})
+}
+
+// resync delivers an update of every cached Foo to itself.
+func (s *simulation) resync() error {
+	stream := s.streams[0]
+	keys := stream.indexer.ListKeys()
+	sort.Strings(keys)
+	for _, key := range keys {
+		obj, exists, err := stream.indexer.GetByKey(key)
+		if err != nil || !exists {
+			return err
+		}
+		stream.handler.OnUpdate(obj, obj)
+	}
+	return nil
+}
+
+// collectEvents moves the events of the watches to the pending events of the
+// informers.
+func (s *simulation) collectEvents() {
+	for _, stream := range s.streams {
+		for {
+			select {
+			case event := <-stream.watch.ResultChan():
+				stream.pending = append(stream.pending, event)
+				continue
+			default:
+			}
+			break
+		}
+	}
+}
+
+func (s *simulation) pendingEvents() int {
+	var n int
+	for _, stream := range s.streams {
+		n += len(stream.pending)
+	}
+	return n
+}
+
+// recordHistory records the available replicas of every Deployment.
+func (s *simulation) recordHistory() {
+	for _, deployment := range s.deployments() {
+		history, ok := s.availableHistory[deployment.UID]
+		if !ok {
+			history = map[int32]bool{}
+			s.availableHistory[deployment.UID] = history
+		}
+		history[deployment.Status.AvailableReplicas] = true
+	}
+}
+
+func (s *simulation) createFoo(step *fooStep) error {
+	foo := newFoo(step.Name, step.Replicas)
+	foo.Namespace = simulationNamespace
+	foo.Labels = step.Labels
+	if step.DeploymentName != "" {
+		foo.Spec.DeploymentName = step.DeploymentName
+	}
+	_, err := s.client.ExampleV1alpha1().Foos(simulationNamespace).Create(s.ctx, foo, metav1.CreateOptions{})
+	return err
+}
+
+func (s *simulation) updateFoo(step *fooStep) error {
+	foo, err := s.client.ExampleV1alpha1().Foos(simulationNamespace).Get(s.ctx, step.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	if step.DeploymentName != "" {
+		foo.Spec.DeploymentName = step.DeploymentName
+	}
+	if step.Replicas != nil {
+		foo.Spec.Replicas = step.Replicas
+	}
+	if step.Labels != nil {
+		foo.Labels = step.Labels
+	}
+	_, err = s.client.ExampleV1alpha1().Foos(simulationNamespace).Update(s.ctx, foo, metav1.UpdateOptions{})
+	return err
+}
+
+// createDeployment creates a Deployment not controlled by any Foo.
+func (s *simulation) createDeployment(step *deploymentStep) error {
+	deployment := newDeployment(newFoo(step.Name, &step.Replicas))
+	deployment.Name = step.Name
+	deployment.OwnerReferences = nil
+	if !step.Managed {
+		delete(deployment.Labels, managedByLabel)
+	}
+	_, err := s.kubeclient.AppsV1().Deployments(simulationNamespace).Create(s.ctx, deployment, metav1.CreateOptions{})
+	return err
+}
+
+// scaleDeployment changes the replicas of the Deployment as someone other
+// than the controller.
+func (s *simulation) scaleDeployment(step *deploymentStep) error {
+	deployment, err := s.kubeclient.AppsV1().Deployments(simulationNamespace).Get(s.ctx, step.Name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	deployment.Spec.Replicas = &step.Replicas
+	_, err = s.kubeclient.AppsV1().Deployments(simulationNamespace).Update(s.ctx, deployment, metav1.UpdateOptions{})
+	return err
+}
+
+// rolloutDeployment completes the rollout of the Deployment, as the
+// Deployment controller does.
+func (s *simulation) rolloutDeployment(name string) error {
+	deployment, err := s.kubeclient.AppsV1().Deployments(simulationNamespace).Get(s.ctx, name, metav1.GetOptions{})
+	if err != nil {
+		return err
+	}
+	replicas := getDeploymentReplicas(deployment)
+	deployment.Status.ObservedGeneration = deployment.Generation
+	deployment.Status.Replicas = replicas
+	deployment.Status.UpdatedReplicas = replicas
+	deployment.Status.ReadyReplicas = replicas
+	deployment.Status.AvailableReplicas = replicas
+	_, err = s.kubeclient.AppsV1().Deployments(simulationNamespace).UpdateStatus(s.ctx, deployment, metav1.UpdateOptions{})
+	return err
+}
+
+// collectGarbage deletes the Deployments whose controller doesn't exist.
+func (s *simulation) collectGarbage() error {
+	foos := map[types.UID]bool{}
+	for _, foo := range s.foos() {
+		foos[foo.UID] = true
+	}
+	for _, deployment := range s.deployments() {
+		if owner := metav1.GetControllerOf(deployment); owner != nil && !foos[owner.UID] {
+			if err := s.kubeclient.AppsV1().Deployments(simulationNamespace).Delete(s.ctx, deployment.Name, metav1.DeleteOptions{}); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *simulation) expect(step *expectStep) error {
+	if step.QueueLen != nil && s.controller.workqueue.Len() != *step.QueueLen {
+		return fmt.Errorf("expected %d items in the workqueue, got %d", *step.QueueLen, s.controller.workqueue.Len())
+	}
+	if step.Pending != nil && s.pendingEvents() != *step.Pending {
+		return fmt.Errorf("expected %d pending events, got %d", *step.Pending, s.pendingEvents())
+	}
+	if step.Foo != "" {
+		foo, getErr := s.client.ExampleV1alpha1().Foos(simulationNamespace).Get(s.ctx, step.Foo, metav1.GetOptions{})
+		if err := checkExists("Foo", step.Foo, step.Exists, getErr); err != nil {
+			return err
+		}
+		if getErr != nil {
+			return nil
+		}
+		if step.Synced && foo.Status.ObservedGeneration != foo.Generation {
+			return fmt.Errorf("expected Foo %s to be synced, observed generation %d of %d", foo.Name, foo.Status.ObservedGeneration, foo.Generation)
+		}
+		if step.AvailableReplicas != nil && foo.Status.AvailableReplicas != *step.AvailableReplicas {
+			return fmt.Errorf("expected Foo %s to have %d available replicas, got %d", foo.Name, *step.AvailableReplicas, foo.Status.AvailableReplicas)
+		}
+	}
+	if step.Deployment != "" {
+		deployment, getErr := s.kubeclient.AppsV1().Deployments(simulationNamespace).Get(s.ctx, step.Deployment, metav1.GetOptions{})
+		if err := checkExists("Deployment", step.Deployment, step.Exists, getErr); err != nil {
+			return err
+		}
+		if getErr != nil {
+			return nil
+		}
+		if step.Replicas != nil && getDeploymentReplicas(deployment) != *step.Replicas {
+			return fmt.Errorf("expected Deployment %s to have %d replicas, got %d", deployment.Name, *step.Replicas, getDeploymentReplicas(deployment))
+		}
+		if step.ControlledBy != "" {
+			owner := metav1.GetControllerOf(deployment)
+			foo, err := s.client.ExampleV1alpha1().Foos(simulationNamespace).Get(s.ctx, step.ControlledBy, metav1.GetOptions{})
+			if err != nil {
+				return err
+			}
+			if owner == nil || owner.UID != foo.UID {
+				return fmt.Errorf("expected Deployment %s to be controlled by Foo %s, got %v", deployment.Name, foo.Name, owner)
+			}
+		}
+	}
+	return nil
+}
+
+// checkExists checks the result of a Get against the expectation of the
+// existence, which defaults to true.
+func checkExists(kind, name string, exists *bool, err error) error {
+	expected := exists == nil || *exists
+	switch {
+	case errors.IsNotFound(err) && expected:
+		return fmt.Errorf("expected %s %s to exist", kind, name)
+	case errors.IsNotFound(err):
+		return nil
+	case err != nil:
+		return err
+	case !expected:
+		return fmt.Errorf("expected %s %s not to exist", kind, name)
+	}
+	return nil
+}
+
+func (s *simulation) foos() []*samplev1alpha1.Foo {
+	list, err := s.client.Tracker().List(foosResource, samplev1alpha1.SchemeGroupVersion.WithKind("Foo"), simulationNamespace)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var foos []*samplev1alpha1.Foo
+	for i := range list.(*samplev1alpha1.FooList).Items {
+		foos = append(foos, &list.(*samplev1alpha1.FooList).Items[i])
+	}
+	return foos
+}
+
+func (s *simulation) deployments() []*appsv1.Deployment {
+	list, err := s.kubeclient.Tracker().List(deploymentsResource, appsv1.SchemeGroupVersion.WithKind("Deployment"), simulationNamespace)
+	if err != nil {
+		s.t.Fatal(err)
+	}
+	var deployments []*appsv1.Deployment
+	for i := range list.(*appsv1.DeploymentList).Items {
+		deployments = append(deployments, &list.(*appsv1.DeploymentList).Items[i])
+	}
+	return deployments
+}
+
+// apiReactor makes the fake clientset behave like the API server in the ways
+// the controller relies on: every write bumps the resource version, an
+// update with a stale resource version is a conflict, the generation is
+// bumped on a change of the spec, and the status is only written through the
+// status subresource.
+func (s *simulation) apiReactor(tracker core.ObjectTracker) core.ReactionFunc {
+	return func(action core.Action) (bool, runtime.Object, error) {
+		switch action := action.(type) {
+		case core.CreateActionImpl:
+			object, err := meta.Accessor(action.GetObject())
+			if err != nil {
+				return true, nil, err
+			}
+			s.resourceVersion++
+			object.SetResourceVersion(fmt.Sprint(s.resourceVersion))
+			object.SetUID(types.UID(fmt.Sprintf("uid-%d", s.resourceVersion)))
+			object.SetGeneration(1)
+			return false, nil, nil
+		case core.UpdateActionImpl:
+			obj, err := s.update(tracker, action)
+			return true, obj, err
+		}
+		return false, nil, nil
+	}
+}
+
+func (s *simulation) update(tracker core.ObjectTracker, action core.UpdateActionImpl) (runtime.Object, error) {
+	obj := action.GetObject().DeepCopyObject()
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	stored, err := tracker.Get(action.GetResource(), action.GetNamespace(), object.GetName())
+	if err != nil {
+		return nil, err
+	}
+	storedObject, _ := meta.Accessor(stored)
+	// An update without a resource version is unconditional.
+	if object.GetResourceVersion() != "" && object.GetResourceVersion() != storedObject.GetResourceVersion() {
+		return nil, errors.NewConflict(action.GetResource().GroupResource(), object.GetName(), fmt.Errorf("the object has been modified"))
+	}
+	status := action.GetSubresource() == "status"
+	switch updated := obj.(type) {
+	case *samplev1alpha1.Foo:
+		current := stored.(*samplev1alpha1.Foo)
+		if status {
+			updated.Spec, updated.ObjectMeta = current.Spec, current.ObjectMeta
+		} else {
+			updated.Status = current.Status
+			updated.Generation = current.Generation
+			if !equality.Semantic.DeepEqual(current.Spec, updated.Spec) {
+				updated.Generation++
+			}
+		}
+	case *appsv1.Deployment:
+		current := stored.(*appsv1.Deployment)
+		if status {
+			updated.Spec, updated.ObjectMeta = current.Spec, current.ObjectMeta
+		} else {
+			updated.Status = current.Status
+			updated.Generation = current.Generation
+			if !equality.Semantic.DeepEqual(current.Spec, updated.Spec) {
+				updated.Generation++
+			}
+		}
+	}
+	object.SetUID(storedObject.GetUID())
+	s.resourceVersion++
+	object.SetResourceVersion(fmt.Sprint(s.resourceVersion))
+	if err := tracker.Update(action.GetResource(), obj, action.GetNamespace()); err != nil {
+		return nil, err
+	}
+	return obj, nil
+}
+
+// simDelayingQueue is a workqueue.DelayingInterface which releases the
+// delayed items synchronously when the fake clock is stepped, instead of
+// from a goroutine.
+type simDelayingQueue struct {
+	workqueue.Interface
+	clock   *testingclock.FakeClock
+	waiting []simWaitingItem
+}
+
+type simWaitingItem struct {
+	item    interface{}
+	readyAt time.Time
+}
+
+var _ workqueue.DelayingInterface = &simDelayingQueue{}
+
+// AddAfter adds the item after the duration. An item already waiting keeps
+// the earlier time, in the same way as the delaying queue of client-go.
+func (q *simDelayingQueue) AddAfter(item interface{}, duration time.Duration) {
+	if duration <= 0 {
+		q.Add(item)
+		return
+	}
+	readyAt := q.clock.Now().Add(duration)
+	for i := range q.waiting {
+		if q.waiting[i].item == item {
+			if readyAt.Before(q.waiting[i].readyAt) {
+				q.waiting[i].readyAt = readyAt
+			}
+			return
+		}
+	}
+	q.waiting = append(q.waiting, simWaitingItem{item: item, readyAt: readyAt})
+}
+
+// release adds the waiting items that are due in the order of the time.
+func (q *simDelayingQueue) release() {
+	sort.SliceStable(q.waiting, func(i, j int) bool { return q.waiting[i].readyAt.Before(q.waiting[j].readyAt) })
+	now := q.clock.Now()
+	for len(q.waiting) > 0 && !q.waiting[0].readyAt.After(now) {
+		q.Add(q.waiting[0].item)
+		q.waiting = q.waiting[1:]
+	}
+}
+
+// checkSingleDeployment checks that every Foo controls at most one
+// Deployment other than the canary.
+func checkSingleDeployment(s *simulation) error {
+	for _, foo := range s.foos() {
+		var names []string
+		for _, deployment := range s.deployments() {
+			if metav1.IsControlledBy(deployment, foo) && deployment.Name != canaryDeploymentName(foo) {
+				names = append(names, deployment.Name)
+			}
+		}
+		if len(names) > 1 {
+			sort.Strings(names)
+			return fmt.Errorf("Foo %s controls the Deployments %v", foo.Name, names)
+		}
+	}
+	return nil
+}
+
+// checkSingleController checks that every Deployment has at most one
+// controller.
+func checkSingleController(s *simulation) error {
+	for _, deployment := range s.deployments() {
+		var controllers int
+		for _, ref := range deployment.OwnerReferences {
+			if ref.Controller != nil && *ref.Controller {
+				controllers++
+			}
+		}
+		if controllers > 1 {
+			return fmt.Errorf("Deployment %s has %d controllers", deployment.Name, controllers)
+		}
+	}
+	return nil
+}
+
+// checkObservedGeneration checks that the status of every Foo doesn't
+// observe a generation the Foo doesn't have yet.
+func checkObservedGeneration(s *simulation) error {
+	for _, foo := range s.foos() {
+		if foo.Status.ObservedGeneration > foo.Generation {
+			return fmt.Errorf("Foo %s observed generation %d of %d", foo.Name, foo.Status.ObservedGeneration, foo.Generation)
+		}
+	}
+	return nil
+}
+
+// checkStatusNotAhead checks that the available replicas in the status of
+// every Foo are the ones its Deployment has had at some point.
+func checkStatusNotAhead(s *simulation) error {
+	deployments := map[string]*appsv1.Deployment{}
+	for _, deployment := range s.deployments() {
+		deployments[deployment.Name] = deployment
+	}
+	for _, foo := range s.foos() {
+		if foo.Status.Stable == nil {
+			continue
+		}
+		deployment, ok := deployments[foo.Status.Stable.DeploymentName]
+		if !ok {
+			continue
+		}
+		if !s.availableHistory[deployment.UID][foo.Status.AvailableReplicas] {
+			return fmt.Errorf("Foo %s has %d available replicas, which Deployment %s never had", foo.Name, foo.Status.AvailableReplicas, deployment.Name)
+		}
+	}
+	return nil
+}
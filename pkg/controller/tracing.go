@@ -0,0 +1,145 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	configv1alpha1 "github.com/nakamasato/sample-controller/pkg/apis/config/v1alpha1"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+	"go.opentelemetry.io/otel/trace/noop"
+	"k8s.io/client-go/rest"
+)
+
+const (
+	// TracingExporterNone disables tracing.
+	TracingExporterNone = configv1alpha1.TracingExporterNone
+	// TracingExporterStdout writes the spans to stdout.
+	TracingExporterStdout = configv1alpha1.TracingExporterStdout
+	// TracingExporterFile writes the spans to TracingOptions.File.
+	TracingExporterFile = configv1alpha1.TracingExporterFile
+	// TracingExporterOTLP sends the spans to an OTLP/HTTP endpoint.
+	TracingExporterOTLP = configv1alpha1.TracingExporterOTLP
+
+	// TraceIDAnnotation is the annotation on the Events emitted during a
+	// traced reconcile with the trace ID.
+	TraceIDAnnotation = "example.com/trace-id"
+
+	// tracerName is the name of the tracer of the controller.
+	tracerName = "github.com/nakamasato/sample-controller"
+)
+
+// TracingOptions configures the tracing of the reconciles and API calls.
+type TracingOptions struct {
+	// Exporter is one of TracingExporterNone, TracingExporterStdout,
+	// TracingExporterFile and TracingExporterOTLP.
+	Exporter string
+	// File is the path of the file of TracingExporterFile.
+	File string
+	// OTLPEndpoint is the host:port of the OTLP/HTTP endpoint. The
+	// OTEL_EXPORTER_OTLP_* environment variables are used if it's empty.
+	OTLPEndpoint string
+	// OTLPInsecure disables TLS to the OTLP/HTTP endpoint.
+	OTLPInsecure bool
+	// SampleRatio is the ratio of the traces that are sampled, unless the
+	// parent span is sampled.
+	SampleRatio float64
+}
+
+// DefaultTracingOptions returns the default options, which disable tracing.
+func DefaultTracingOptions() TracingOptions {
+	return TracingOptions{
+		Exporter:    configv1alpha1.DefaultTracingExporter,
+		SampleRatio: configv1alpha1.DefaultTracingSampleRatio,
+	}
+}
+
+// NewTracerProvider returns the TracerProvider for the options and the
+// function to flush and shut it down.
+func NewTracerProvider(ctx context.Context, opts TracingOptions) (trace.TracerProvider, func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	var file io.WriteCloser
+	var err error
+	switch opts.Exporter {
+	case TracingExporterNone:
+		return noop.NewTracerProvider(), func(context.Context) error { return nil }, nil
+	case TracingExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	case TracingExporterFile:
+		file, err = os.OpenFile(opts.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+		if err != nil {
+			return nil, nil, err
+		}
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(file))
+	case TracingExporterOTLP:
+		var clientOpts []otlptracehttp.Option
+		if opts.OTLPEndpoint != "" {
+			clientOpts = append(clientOpts, otlptracehttp.WithEndpoint(opts.OTLPEndpoint))
+		}
+		if opts.OTLPInsecure {
+			clientOpts = append(clientOpts, otlptracehttp.WithInsecure())
+		}
+		exporter, err = otlptracehttp.New(ctx, clientOpts...)
+	default:
+		return nil, nil, fmt.Errorf("unknown tracing exporter %q", opts.Exporter)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(semconv.ServiceName(controllerAgentName)))
+	if err != nil {
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(opts.SampleRatio))),
+	)
+	shutdown := func(ctx context.Context) error {
+		err := provider.Shutdown(ctx)
+		if file != nil {
+			if closeErr := file.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		return err
+	}
+	return provider, shutdown, nil
+}
+
+// WrapTransport makes the clients of the config create a child span for each
+// request to the API server in a span, such as a reconcile. The requests of
+// the informers and the event broadcaster, which have no parent span, are not
+// traced.
+func WrapTransport(config *rest.Config, provider trace.TracerProvider) {
+	config.Wrap(func(rt http.RoundTripper) http.RoundTripper {
+		return otelhttp.NewTransport(rt,
+			otelhttp.WithTracerProvider(provider),
+			otelhttp.WithFilter(func(r *http.Request) bool {
+				return trace.SpanContextFromContext(r.Context()).IsValid()
+			}),
+			otelhttp.WithSpanNameFormatter(func(_ string, r *http.Request) string {
+				return r.Method + " " + r.URL.Path
+			}),
+		)
+	})
+}
+
+// fooAttributes returns the attributes of the spans of the Foo.
+func fooAttributes(namespace, name string) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("foo", name),
+		attribute.String("namespace", namespace),
+	}
+}
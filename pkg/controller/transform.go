@@ -0,0 +1,72 @@
+package controller
+
+import (
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/cache"
+)
+
+// transformObject is the cache.TransformFunc of the informers, which strips
+// the fields never read by the controller before the objects are cached:
+//   - managedFields of all the objects, which the API server keeps when an
+//     object is updated without them.
+//   - spec, status and the last-applied-configuration annotation of the
+//     Deployments not controlled by a Foo, as only their owner references
+//     are read.
+//   - spec, the last-applied-configuration annotation and all the status but
+//     the container statuses of the Pods, as only their labels and container
+//     statuses are read.
+//
+// The annotation is kept on the other objects as they are updated from the
+// cache, which would remove it.
+func transformObject(obj interface{}) (interface{}, error) {
+	// Tombstones are passed as they are.
+	object, err := meta.Accessor(obj)
+	if err != nil {
+		return obj, nil
+	}
+	object.SetManagedFields(nil)
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		if !isControlledByFoo(o) {
+			stripLastAppliedConfig(o)
+			o.Spec = appsv1.DeploymentSpec{}
+			o.Status = appsv1.DeploymentStatus{}
+		}
+	case *corev1.Pod:
+		stripLastAppliedConfig(o)
+		o.Spec = corev1.PodSpec{}
+		o.Status = corev1.PodStatus{
+			InitContainerStatuses: o.Status.InitContainerStatuses,
+			ContainerStatuses:     o.Status.ContainerStatuses,
+		}
+	}
+	return obj, nil
+}
+
+// stripLastAppliedConfig removes the last-applied-configuration annotation,
+// which holds a copy of the whole object applied by kubectl.
+func stripLastAppliedConfig(object metav1.Object) {
+	annotations := object.GetAnnotations()
+	if _, ok := annotations[corev1.LastAppliedConfigAnnotation]; !ok {
+		return
+	}
+	stripped := make(map[string]string, len(annotations)-1)
+	for k, v := range annotations {
+		if k != corev1.LastAppliedConfigAnnotation {
+			stripped[k] = v
+		}
+	}
+	object.SetAnnotations(stripped)
+}
+
+var _ cache.TransformFunc = transformObject
+
+// isControlledByFoo returns true if the controller of the object is a Foo.
+func isControlledByFoo(object metav1.Object) bool {
+	ownerRef := metav1.GetControllerOf(object)
+	return ownerRef != nil && ownerRef.Kind == "Foo"
+}
@@ -0,0 +1,342 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"runtime"
+	"testing"
+
+	"github.com/nakamasato/sample-controller/pkg/generated/clientset/versioned/fake"
+	listers "github.com/nakamasato/sample-controller/pkg/generated/listers/example.com/v1alpha1"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	k8sfake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/utils/pointer"
+)
+
+const benchmarkDeployments = 10000
+
+// BenchmarkDeploymentCache reports the heap used by the Deployment cache with
+// 10k Deployments not owned by a Foo, with and without transformObject.
+func BenchmarkDeploymentCache(b *testing.B) {
+	for _, bm := range []struct {
+		name      string
+		transform cache.TransformFunc
+	}{
+		{name: "full"},
+		{name: "transformed", transform: transformObject},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			var heap uint64
+			for n := 0; n < b.N; n++ {
+				heap += measureDeploymentCache(b, bm.transform)
+			}
+			b.ReportMetric(float64(heap)/float64(b.N), "heap-bytes/op")
+		})
+	}
+}
+
+// measureDeploymentCache fills an indexer with the Deployments through the
+// transform and returns the heap it retains.
+func measureDeploymentCache(b *testing.B, transform cache.TransformFunc) uint64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for i := 0; i < benchmarkDeployments; i++ {
+		var obj interface{} = newBenchmarkDeployment(i)
+		if transform != nil {
+			var err error
+			if obj, err = transform(obj); err != nil {
+				b.Fatal(err)
+			}
+		}
+		if err := indexer.Add(obj); err != nil {
+			b.Fatal(err)
+		}
+	}
+
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	runtime.KeepAlive(indexer)
+	if after.HeapAlloc < before.HeapAlloc {
+		return 0
+	}
+	return after.HeapAlloc - before.HeapAlloc
+}
+
+// newBenchmarkDeployment returns a Deployment as returned by the API server
+// after kubectl apply, with managedFields and the last-applied-configuration
+// annotation.
+func newBenchmarkDeployment(i int) *appsv1.Deployment {
+	replicas := int32(3)
+	labels := map[string]string{"app": fmt.Sprintf("app-%d", i)}
+	deployment := &appsv1.Deployment{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("deployment-%d", i),
+			Namespace: fmt.Sprintf("namespace-%d", i%100),
+			Labels:    labels,
+		},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: labels},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec: corev1.PodSpec{
+					Containers: []corev1.Container{
+						{
+							Name:  "app",
+							Image: "nginx:latest",
+							Env: []corev1.EnvVar{
+								{Name: "FOO", Value: "foo"},
+								{Name: "BAR", Value: "bar"},
+							},
+							Ports: []corev1.ContainerPort{{ContainerPort: 80}},
+						},
+					},
+				},
+			},
+		},
+		Status: appsv1.DeploymentStatus{
+			Replicas:          replicas,
+			UpdatedReplicas:   replicas,
+			AvailableReplicas: replicas,
+			Conditions: []appsv1.DeploymentCondition{
+				{Type: appsv1.DeploymentAvailable, Status: corev1.ConditionTrue, Reason: "MinimumReplicasAvailable", Message: "Deployment has minimum availability."},
+				{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionTrue, Reason: "NewReplicaSetAvailable", Message: "ReplicaSet has successfully progressed."},
+			},
+		},
+	}
+	lastApplied, _ := json.Marshal(deployment)
+	deployment.Annotations = map[string]string{corev1.LastAppliedConfigAnnotation: string(lastApplied)}
+	deployment.ManagedFields = []metav1.ManagedFieldsEntry{
+		{
+			Manager:    "kubectl-client-side-apply",
+			Operation:  metav1.ManagedFieldsOperationUpdate,
+			APIVersion: "apps/v1",
+			FieldsType: "FieldsV1",
+			FieldsV1:   &metav1.FieldsV1{Raw: []byte(`{"f:metadata":{"f:annotations":{".":{},"f:kubectl.kubernetes.io/last-applied-configuration":{}},"f:labels":{".":{},"f:app":{}}},"f:spec":{"f:replicas":{},"f:selector":{},"f:template":{"f:metadata":{"f:labels":{".":{},"f:app":{}}},"f:spec":{"f:containers":{"k:{\"name\":\"app\"}":{".":{},"f:env":{".":{},"k:{\"name\":\"BAR\"}":{".":{},"f:name":{},"f:value":{}},"k:{\"name\":\"FOO\"}":{".":{},"f:name":{},"f:value":{}}},"f:image":{},"f:name":{},"f:ports":{".":{},"k:{\"containerPort\":80,\"protocol\":\"TCP\"}":{".":{},"f:containerPort":{}}}}}}}}}`)},
+		},
+		{
+			Manager:     "kube-controller-manager",
+			Operation:   metav1.ManagedFieldsOperationUpdate,
+			APIVersion:  "apps/v1",
+			FieldsType:  "FieldsV1",
+			Subresource: "status",
+			FieldsV1:    &metav1.FieldsV1{Raw: []byte(`{"f:status":{"f:availableReplicas":{},"f:conditions":{".":{},"k:{\"type\":\"Available\"}":{".":{},"f:lastTransitionTime":{},"f:lastUpdateTime":{},"f:message":{},"f:reason":{},"f:status":{},"f:type":{}},"k:{\"type\":\"Progressing\"}":{".":{},"f:lastTransitionTime":{},"f:lastUpdateTime":{},"f:message":{},"f:reason":{},"f:status":{},"f:type":{}}},"f:observedGeneration":{},"f:readyReplicas":{},"f:replicas":{},"f:updatedReplicas":{}}}`)},
+		},
+	}
+	return deployment
+}
+
+// withApplied returns the object as returned by the API server after kubectl
+// apply, with managedFields and the last-applied-configuration annotation.
+func withApplied[T metav1.Object](object T) T {
+	object.SetAnnotations(map[string]string{corev1.LastAppliedConfigAnnotation: "{}", "example.com/note": "kept"})
+	object.SetManagedFields([]metav1.ManagedFieldsEntry{{Manager: "kubectl-client-side-apply", Operation: metav1.ManagedFieldsOperationUpdate}})
+	return object
+}
+
+func TestTransformObject(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	owned := newDeployment(foo)
+	owned.Status = appsv1.DeploymentStatus{Replicas: 1, AvailableReplicas: 1}
+	pod := newPod(foo, "test-pod", 1, "CrashLoopBackOff")
+	pod.Spec = corev1.PodSpec{Containers: []corev1.Container{{Name: "nginx", Image: "nginx:latest"}}}
+	pod.Status.Phase = corev1.PodRunning
+	pod.Status.InitContainerStatuses = []corev1.ContainerStatus{{Name: "init"}}
+	tombstone := cache.DeletedFinalStateUnknown{Key: "default/test", Obj: withApplied(foo.DeepCopy())}
+
+	for _, tc := range []struct {
+		name string
+		obj  interface{}
+		want interface{}
+	}{
+		{
+			name: "foo",
+			obj:  withApplied(foo.DeepCopy()),
+			want: func() interface{} {
+				want := withApplied(foo.DeepCopy())
+				want.ManagedFields = nil
+				return want
+			}(),
+		},
+		{
+			name: "deployment controlled by a foo",
+			obj:  withApplied(owned.DeepCopy()),
+			want: func() interface{} {
+				want := withApplied(owned.DeepCopy())
+				want.ManagedFields = nil
+				return want
+			}(),
+		},
+		{
+			name: "deployment not controlled by a foo",
+			obj:  newBenchmarkDeployment(0),
+			want: func() interface{} {
+				want := newBenchmarkDeployment(0)
+				want.ManagedFields = nil
+				want.Annotations = nil
+				want.Spec = appsv1.DeploymentSpec{}
+				want.Status = appsv1.DeploymentStatus{}
+				return want
+			}(),
+		},
+		{
+			name: "pod",
+			obj:  withApplied(pod.DeepCopy()),
+			want: func() interface{} {
+				want := withApplied(pod.DeepCopy())
+				want.ManagedFields = nil
+				want.Annotations = map[string]string{"example.com/note": "kept"}
+				want.Spec = corev1.PodSpec{}
+				want.Status.Phase = ""
+				return want
+			}(),
+		},
+		{
+			name: "tombstone",
+			obj:  tombstone,
+			want: tombstone,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := transformObject(tc.obj)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if diff := cmp.Diff(tc.want, got, cmpopts.EquateEmpty()); diff != "" {
+				t.Errorf("Unexpected object (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+// TestNewInformers checks the informers cache only the Deployments matching
+// the selector and the pods of Foos, stripped by transformObject.
+func TestNewInformers(t *testing.T) {
+	foo := newFoo("test", pointer.Int32(1))
+	managed := withApplied(newDeployment(foo))
+	unmanaged := newBenchmarkDeployment(0)
+	unmanaged.Namespace = metav1.NamespaceDefault
+	pod := newPod(foo, "test-pod", 0, "")
+	otherPod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "other-pod", Namespace: metav1.NamespaceDefault}}
+	kubeclient := k8sfake.NewSimpleClientset(managed, unmanaged, pod, otherPod)
+
+	informerSets, factories, err := NewInformers(kubeclient, fake.NewSimpleClientset(), nil, "", ManagedDeploymentSelector, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	for _, factory := range factories {
+		factory.Start(stopCh)
+	}
+	informerSet := informerSets[0]
+	if !cache.WaitForCacheSync(stopCh, informerSet.Deployments.Informer().HasSynced, informerSet.Pods.Informer().HasSynced) {
+		t.Fatal("Failed to sync the informers")
+	}
+
+	deployments, err := informerSet.Deployments.Lister().List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(deployments) != 1 || deployments[0].Name != managed.Name {
+		t.Fatalf("Expected only the Deployment %s cached, got %d Deployments", managed.Name, len(deployments))
+	}
+	if deployments[0].ManagedFields != nil || deployments[0].Spec.Replicas == nil {
+		t.Errorf("Expected the cached Deployment without managedFields and with the spec, got %+v", deployments[0].ObjectMeta)
+	}
+	pods, err := informerSet.Pods.Lister().List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pods) != 1 || pods[0].Name != pod.Name {
+		t.Errorf("Expected only the pod %s cached, got %d pods", pod.Name, len(pods))
+	}
+}
+
+// TestMergeIndexers checks the lister over the informers for two namespaces
+// reads the Foos of both, and not the ones of an unwatched namespace.
+func TestMergeIndexers(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	for _, namespace := range []string{"ns-a", "ns-b", "ns-c"} {
+		foo := newFoo("test", pointer.Int32(1))
+		foo.Namespace = namespace
+		if err := client.Tracker().Add(foo); err != nil {
+			t.Fatal(err)
+		}
+	}
+	informerSets, factories, err := NewInformers(k8sfake.NewSimpleClientset(), client, []string{"ns-a", "ns-b"}, "", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	var fooInformers []cache.SharedIndexInformer
+	for _, informerSet := range informerSets {
+		fooInformers = append(fooInformers, informerSet.Foos.Informer())
+	}
+	for _, factory := range factories {
+		factory.Start(stopCh)
+	}
+	if !cache.WaitForCacheSync(stopCh, mergeHasSynced(fooInformers)) {
+		t.Fatal("Failed to sync the informers")
+	}
+	indexer := mergeIndexers(fooInformers)
+	lister := listers.NewFooLister(indexer)
+
+	foos, err := lister.List(labels.Everything())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, foo := range foos {
+		keys = append(keys, foo.Namespace+"/"+foo.Name)
+	}
+	if diff := cmp.Diff([]string{"ns-a/test", "ns-b/test"}, keys, cmpopts.SortSlices(func(a, b string) bool { return a < b })); diff != "" {
+		t.Errorf("Expected the Foos of the watched namespaces listed (-want +got):\n%s", diff)
+	}
+
+	for _, tc := range []struct {
+		namespace string
+		found     bool
+	}{
+		{namespace: "ns-a", found: true},
+		{namespace: "ns-b", found: true},
+		{namespace: "ns-c"},
+	} {
+		t.Run(tc.namespace, func(t *testing.T) {
+			foo, err := lister.Foos(tc.namespace).Get("test")
+			switch {
+			case tc.found && err != nil:
+				t.Errorf("Expected the Foo in %s, got %v", tc.namespace, err)
+			case tc.found && foo.Namespace != tc.namespace:
+				t.Errorf("Expected the Foo in %s, got the one in %s", tc.namespace, foo.Namespace)
+			case !tc.found && !errors.IsNotFound(err):
+				t.Errorf("Expected the Foo in %s not found, got %v", tc.namespace, err)
+			}
+
+			indexed, err := indexer.ByIndex(cache.NamespaceIndex, tc.namespace)
+			if err != nil {
+				t.Fatal(err)
+			}
+			want := 0
+			if tc.found {
+				want = 1
+			}
+			if len(indexed) != want {
+				t.Errorf("Expected %d Foos indexed in %s, got %d", want, tc.namespace, len(indexed))
+			}
+		})
+	}
+}
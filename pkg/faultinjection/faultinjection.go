@@ -0,0 +1,238 @@
+// Package faultinjection provides reactors for the fake clientsets which fail
+// or slow down the requests matching a fault, to test that a controller
+// converges under flaky API behavior.
+package faultinjection
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	core "k8s.io/client-go/testing"
+	"k8s.io/utils/clock"
+)
+
+// Any matches any verb, resource or subresource.
+const Any = "*"
+
+// ErrorFunc returns the error of a fault for the request.
+type ErrorFunc func(action core.Action) error
+
+// Conflict returns a 409 Conflict, as for a request with a stale resource
+// version.
+func Conflict(action core.Action) error {
+	return errors.NewConflict(action.GetResource().GroupResource(), actionName(action), fmt.Errorf("injected conflict"))
+}
+
+// TooManyRequests returns a 429 Too Many Requests, as for a throttled
+// request.
+func TooManyRequests(action core.Action) error {
+	return errors.NewTooManyRequests("injected throttling", 1)
+}
+
+// InternalError returns a 500 Internal Server Error.
+func InternalError(action core.Action) error {
+	return errors.NewInternalError(fmt.Errorf("injected failure of %s %s", action.GetVerb(), action.GetResource().GroupResource()))
+}
+
+// Timeout returns a 504 Gateway Timeout, as for a request which may or may
+// not have been done. Set Fault.Commit to make it done.
+func Timeout(action core.Action) error {
+	return errors.NewTimeoutError(fmt.Sprintf("injected timeout of %s %s", action.GetVerb(), action.GetResource().GroupResource()), 1)
+}
+
+// Fault is a failure or a delay of the requests matching the verb, resource
+// and subresource.
+type Fault struct {
+	// Verb and Resource are matched as by core.Action.Matches. Empty or Any
+	// matches any verb or resource.
+	Verb     string
+	Resource string
+	// Subresource is matched exactly, so the empty subresource only matches
+	// the requests of the resource itself. Any matches any subresource.
+	Subresource string
+
+	// Error returns the error of the request. A fault without Error only
+	// delays the request.
+	Error ErrorFunc
+	// Delay is the time the request is delayed for before it fails or is
+	// done, as a slow response.
+	Delay time.Duration
+	// Commit makes the request done before Error is returned, as a timeout
+	// of a request which succeeded.
+	Commit bool
+
+	// After is the number of matching requests which are let through before
+	// the fault is scheduled.
+	After int
+	// Count is the number of times the fault is injected. Zero means no
+	// limit.
+	Count int
+	// Probability is the probability of the fault for each scheduled request.
+	// Zero means every scheduled request.
+	Probability float64
+}
+
+func (f *Fault) matches(action core.Action) bool {
+	if f.Verb != "" && f.Verb != Any && f.Verb != action.GetVerb() {
+		return false
+	}
+	if f.Resource != "" && f.Resource != Any && f.Resource != action.GetResource().Resource {
+		return false
+	}
+	return f.Subresource == Any || f.Subresource == action.GetSubresource()
+}
+
+func (f *Fault) String() string {
+	verb, resource := f.Verb, f.Resource
+	if verb == "" {
+		verb = Any
+	}
+	if resource == "" {
+		resource = Any
+	}
+	if f.Subresource != "" {
+		resource += "/" + f.Subresource
+	}
+	return verb + " " + resource
+}
+
+// Injection is a fault injected into a request.
+type Injection struct {
+	Fault  *Fault
+	Action core.Action
+	Err    error
+}
+
+// Injector injects the faults into the requests of the fake clientsets it's
+// installed into. The probabilities are drawn from a source seeded with the
+// seed, so the same requests get the same faults.
+type Injector struct {
+	clock clock.Clock
+
+	mu       sync.Mutex
+	rand     *rand.Rand
+	faults   []*scheduledFault
+	injected []Injection
+}
+
+// scheduledFault is a fault with the count of the matching requests.
+type scheduledFault struct {
+	*Fault
+	matched  int
+	injected int
+}
+
+// New returns an Injector without faults. The delays are slept with the
+// clock, so a fake clock is stepped instead.
+func New(seed int64, clock clock.Clock) *Injector {
+	return &Injector{
+		clock: clock,
+		rand:  rand.New(rand.NewSource(seed)),
+	}
+}
+
+// Add adds the faults, which are checked in the order they're added. A
+// request gets the first fault scheduled for it.
+func (i *Injector) Add(faults ...Fault) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for n := range faults {
+		fault := faults[n]
+		i.faults = append(i.faults, &scheduledFault{Fault: &fault})
+	}
+}
+
+// Injected returns the injected faults in order.
+func (i *Injector) Injected() []Injection {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return append([]Injection(nil), i.injected...)
+}
+
+// Client is a fake clientset, such as the Clientset of
+// k8s.io/client-go/kubernetes/fake.
+type Client interface {
+	PrependReactor(verb, resource string, reaction core.ReactionFunc)
+	Tracker() core.ObjectTracker
+}
+
+// Install prepends the reactor of the Injector to the fake clientset. The
+// committed requests are done on the tracker of the clientset.
+func (i *Injector) Install(client Client) {
+	client.PrependReactor(Any, Any, i.Reactor(core.ObjectReaction(client.Tracker())))
+}
+
+// Reactor returns a reactor which injects the faults. The requests without a
+// fault and the delayed requests are handled by the next reactors, and the
+// committed requests by commit.
+func (i *Injector) Reactor(commit core.ReactionFunc) core.ReactionFunc {
+	return func(action core.Action) (bool, runtime.Object, error) {
+		fault := i.schedule(action)
+		if fault == nil {
+			return false, nil, nil
+		}
+		if fault.Delay > 0 {
+			i.clock.Sleep(fault.Delay)
+		}
+		if fault.Error == nil {
+			i.record(Injection{Fault: fault, Action: action})
+			return false, nil, nil
+		}
+		if fault.Commit {
+			if _, _, err := commit(action); err != nil {
+				i.record(Injection{Fault: fault, Action: action, Err: err})
+				return true, nil, err
+			}
+		}
+		err := fault.Error(action)
+		i.record(Injection{Fault: fault, Action: action, Err: err})
+		return true, nil, err
+	}
+}
+
+// schedule returns the fault of the request, if any.
+func (i *Injector) schedule(action core.Action) *Fault {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	for _, fault := range i.faults {
+		if !fault.matches(action) {
+			continue
+		}
+		fault.matched++
+		if fault.matched <= fault.After || (fault.Count > 0 && fault.injected >= fault.Count) {
+			continue
+		}
+		if fault.Probability > 0 && i.rand.Float64() >= fault.Probability {
+			continue
+		}
+		fault.injected++
+		return fault.Fault
+	}
+	return nil
+}
+
+func (i *Injector) record(injection Injection) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.injected = append(i.injected, injection)
+}
+
+// actionName returns the name of the object of the request. The get,
+// delete and patch actions have the name, and the create and update actions
+// the object.
+func actionName(action core.Action) string {
+	switch action := action.(type) {
+	case interface{ GetName() string }:
+		return action.GetName()
+	case interface{ GetObject() runtime.Object }:
+		if accessor, err := meta.Accessor(action.GetObject()); err == nil {
+			return accessor.GetName()
+		}
+	}
+	return ""
+}
@@ -0,0 +1,128 @@
+package faultinjection
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	testingclock "k8s.io/utils/clock/testing"
+)
+
+func newDeployment(name string) *appsv1.Deployment {
+	return &appsv1.Deployment{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: metav1.NamespaceDefault}}
+}
+
+func TestSchedule(t *testing.T) {
+	client := fake.NewSimpleClientset(newDeployment("a"))
+	injector := New(0, testingclock.NewFakeClock(time.Now()))
+	injector.Add(Fault{Verb: "get", Resource: "deployments", Error: InternalError, After: 1, Count: 2})
+	injector.Install(client)
+
+	ctx := context.Background()
+	var failed []bool
+	for n := 0; n < 5; n++ {
+		_, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Get(ctx, "a", metav1.GetOptions{})
+		if err != nil && !errors.IsInternalError(err) {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		failed = append(failed, err != nil)
+	}
+	if want := []bool{false, true, true, false, false}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("Expected the failures %v, got %v", want, failed)
+	}
+	if _, err := client.AppsV1().Deployments(metav1.NamespaceDefault).List(ctx, metav1.ListOptions{}); err != nil {
+		t.Errorf("Expected the list not to match, got %v", err)
+	}
+	if n := len(injector.Injected()); n != 2 {
+		t.Errorf("Expected 2 injections, got %d", n)
+	}
+}
+
+func TestSubresource(t *testing.T) {
+	client := fake.NewSimpleClientset(newDeployment("a"))
+	injector := New(0, testingclock.NewFakeClock(time.Now()))
+	injector.Add(Fault{Verb: "update", Resource: "deployments", Subresource: "status", Error: Conflict})
+	injector.Install(client)
+
+	ctx := context.Background()
+	if _, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Update(ctx, newDeployment("a"), metav1.UpdateOptions{}); err != nil {
+		t.Errorf("Expected the update to succeed, got %v", err)
+	}
+	_, err := client.AppsV1().Deployments(metav1.NamespaceDefault).UpdateStatus(ctx, newDeployment("a"), metav1.UpdateOptions{})
+	if !errors.IsConflict(err) {
+		t.Errorf("Expected a conflict, got %v", err)
+	}
+}
+
+func TestProbability(t *testing.T) {
+	failures := func(seed int64) []bool {
+		client := fake.NewSimpleClientset(newDeployment("a"))
+		injector := New(seed, testingclock.NewFakeClock(time.Now()))
+		injector.Add(Fault{Error: TooManyRequests, Probability: 0.5})
+		injector.Install(client)
+		var failed []bool
+		for n := 0; n < 100; n++ {
+			_, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Get(context.Background(), "a", metav1.GetOptions{})
+			if err != nil && !errors.IsTooManyRequests(err) {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			failed = append(failed, err != nil)
+		}
+		return failed
+	}
+
+	first := failures(1)
+	if !reflect.DeepEqual(first, failures(1)) {
+		t.Errorf("Expected the same failures with the same seed")
+	}
+	count := 0
+	for _, failed := range first {
+		if failed {
+			count++
+		}
+	}
+	if count < 25 || count > 75 {
+		t.Errorf("Expected about 50 failures of 100, got %d", count)
+	}
+}
+
+func TestCommit(t *testing.T) {
+	client := fake.NewSimpleClientset()
+	injector := New(0, testingclock.NewFakeClock(time.Now()))
+	injector.Add(Fault{Verb: "create", Resource: "deployments", Error: Timeout, Commit: true, Count: 1})
+	injector.Install(client)
+
+	ctx := context.Background()
+	_, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Create(ctx, newDeployment("a"), metav1.CreateOptions{})
+	if !errors.IsTimeout(err) {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if _, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Get(ctx, "a", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the Deployment to be created, got %v", err)
+	}
+	_, err = client.AppsV1().Deployments(metav1.NamespaceDefault).Create(ctx, newDeployment("a"), metav1.CreateOptions{})
+	if !errors.IsAlreadyExists(err) {
+		t.Errorf("Expected the retry to find the Deployment, got %v", err)
+	}
+}
+
+func TestDelay(t *testing.T) {
+	start := time.Now()
+	clock := testingclock.NewFakeClock(start)
+	client := fake.NewSimpleClientset(newDeployment("a"))
+	injector := New(0, clock)
+	injector.Add(Fault{Verb: "get", Delay: time.Second})
+	injector.Install(client)
+
+	if _, err := client.AppsV1().Deployments(metav1.NamespaceDefault).Get(context.Background(), "a", metav1.GetOptions{}); err != nil {
+		t.Errorf("Expected the slow request to succeed, got %v", err)
+	}
+	if elapsed := clock.Since(start); elapsed != time.Second {
+		t.Errorf("Expected the request to take 1s, took %s", elapsed)
+	}
+}